@@ -2,13 +2,28 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 
 	"github.com/JasirZaeem/ape/pkg/repl"
+	"github.com/JasirZaeem/ape/pkg/script"
 )
 
+const USAGE = `usage:
+  ape                             start the REPL
+  ape run [--vm] file.ape [args]  run a script, args are available as the args array
+  ape file.ape [args]             same as run, for #!/usr/bin/env ape lines
+
+options:
+  --vm  compile the script to bytecode and run it on the virtual machine
+`
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(run(os.Args[1:]))
+	}
+
 	currentUser, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -20,3 +35,30 @@ func main() {
 	fmt.Printf("Type in commands\n")
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+func run(args []string) int {
+	switch args[0] {
+	case "run":
+		args = args[1:]
+		var options script.Options
+		if len(args) > 0 && args[0] == "--vm" {
+			options.VM = true
+			args = args[1:]
+		}
+		if len(args) < 1 {
+			usage(os.Stderr)
+			return script.EXIT_USAGE
+		}
+		return script.Run(args[0], args[1:], os.Stderr, options)
+	case "help", "-h", "--help":
+		usage(os.Stdout)
+		return script.EXIT_OK
+	default:
+		// A shebang line runs the interpreter with the script path first
+		return script.Run(args[0], args[1:], os.Stderr, script.Options{})
+	}
+}
+
+func usage(out io.Writer) {
+	io.WriteString(out, USAGE)
+}
@@ -1,20 +1,73 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
 	"github.com/JasirZaeem/ape/pkg/evaluator"
 	"github.com/JasirZaeem/ape/pkg/format"
 	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/module"
 	"github.com/JasirZaeem/ape/pkg/object"
 	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/resolver"
+	"github.com/JasirZaeem/ape/pkg/token"
+	"github.com/JasirZaeem/ape/pkg/vm"
+	"io"
 	"strings"
 	"syscall/js"
+	"time"
 )
 
+// Programs are stopped rather than left to freeze the browser tab
+const TIMEOUT = 10 * time.Second
+
+var LIMITS = object.Limits{MaxDepth: 10000, MaxHeap: 256 << 20}
+
 // global environment
 var env *object.Environment
 
+// global state of programs run on the virtual machine
+var machine *vm.VM
+
+// modules the playground can import, they outlive resets of the environment
+var modules = module.NewMemoryLoader(nil)
+
+// output of the program being run, returned with its result for the
+// playground's output pane
+var output strings.Builder
+
+var streams = &object.IO{Stdout: &output, ReadLine: readLine}
+
+func newEnvironment() *object.Environment {
+	env := evaluator.NewModules(modules).NewEnvironment("")
+	env.SetRuntime(&object.Runtime{IO: streams})
+	return env
+}
+
+func newMachine() *vm.VM {
+	machine := vm.New(vm.NewModules(modules).NewModule(""))
+	machine.SetIO(streams)
+	return machine
+}
+
+// readLine asks for a line of input with the browser's prompt dialog, the
+// prompt and the line are added to the output like a terminal would show them
+func readLine(prompt string) (string, error) {
+	ask := js.Global().Get("prompt")
+	if ask.Type() != js.TypeFunction {
+		return "", io.EOF
+	}
+
+	line := ask.Invoke(prompt)
+	if line.IsNull() || line.IsUndefined() {
+		return "", io.EOF
+	}
+	output.WriteString(prompt + line.String() + "\n")
+	return line.String(), nil
+}
+
 func Run(this js.Value, args []js.Value) (ret interface{}) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -24,14 +77,15 @@ func Run(this js.Value, args []js.Value) (ret interface{}) {
 			}
 		}
 	}()
-	// ensure only one argument is passed
-	if len(args) != 1 {
+	// the code, optionally followed by "vm" to run it on the virtual machine
+	if len(args) != 1 && len(args) != 2 {
 		return map[string]interface{}{
 			"type":  "WASM_ERROR",
-			"value": fmt.Sprintf("wrong number of arguments. got = %d, want = 1", len(args)),
+			"value": fmt.Sprintf("wrong number of arguments. got = %d, want = 1 or 2", len(args)),
 		}
 
 	}
+	useVM := len(args) == 2 && args[1].String() == "vm"
 
 	// get the code from the argument
 	code := args[0].String()
@@ -40,26 +94,90 @@ func Run(this js.Value, args []js.Value) (ret interface{}) {
 	p := parser.New(l)
 
 	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		return map[string]interface{}{
-			"type":  "PARSER_ERROR",
-			"value": strings.Join(p.Errors(), "\n"),
-		}
+	if len(p.Diagnostics()) != 0 {
+		return parserErrorResult(p.Diagnostics())
 	}
 
-	evaluated := evaluator.Eval(program, env)
-
-	if evaluated != nil {
-		return map[string]interface{}{
-			"type":  string(evaluated.Type()),
-			"value": evaluated.Inspect(),
+	// Undefined names are reported like syntax errors, warnings come with the result
+	var diagnostics []diagnostic.Diagnostic
+	if useVM {
+		diagnostics = resolver.Resolve(program, append(evaluator.BuiltinNames(), machine.Names()...))
+	} else {
+		diagnostics = evaluator.Resolve(program, env)
+	}
+	for _, d := range diagnostics {
+		if d.Severity == diagnostic.ERROR {
+			return parserErrorResult(diagnostics)
 		}
 	}
 
-	return map[string]interface{}{
+	output.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	var evaluated object.Object
+	if useVM {
+		evaluated = machine.RunWithLimits(ctx, program, LIMITS)
+	} else {
+		evaluated = evaluator.EvalWithLimits(ctx, program, env, LIMITS)
+	}
+	cancel()
+
+	result := map[string]interface{}{
 		"type":  "EMPTY",
 		"value": "",
 	}
+	if err, ok := evaluated.(*object.Error); ok {
+		result["type"] = string(err.Type())
+		result["value"] = err.Traceback()
+	} else if evaluated != nil {
+		result["type"] = string(evaluated.Type())
+		result["value"] = evaluated.Inspect()
+	}
+	if output.Len() != 0 {
+		result["output"] = output.String()
+	}
+	if len(diagnostics) != 0 {
+		result["diagnostics"] = diagnosticDetails(diagnostics)
+	}
+
+	return result
+}
+
+// parserErrorResult reports the diagnostics both as text and as objects the
+// playground can use to mark up the editor
+func parserErrorResult(diagnostics []diagnostic.Diagnostic) map[string]interface{} {
+	messages := make([]string, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		messages = append(messages, d.String())
+	}
+
+	return map[string]interface{}{
+		"type":        "PARSER_ERROR",
+		"value":       strings.Join(messages, "\n"),
+		"diagnostics": diagnosticDetails(diagnostics),
+	}
+}
+
+func diagnosticDetails(diagnostics []diagnostic.Diagnostic) []interface{} {
+	details := make([]interface{}, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		details = append(details, map[string]interface{}{
+			"severity": d.Severity.String(),
+			"code":     string(d.Code),
+			"message":  d.Message,
+			"hint":     d.Hint,
+			"start":    positionToMap(d.Span.Start),
+			"end":      positionToMap(d.Span.End),
+		})
+	}
+	return details
+}
+
+func positionToMap(pos token.Position) map[string]interface{} {
+	return map[string]interface{}{
+		"offset": pos.Offset,
+		"line":   pos.Line,
+		"column": pos.Column,
+	}
 }
 
 func Reset(this js.Value, args []js.Value) interface{} {
@@ -67,7 +185,19 @@ func Reset(this js.Value, args []js.Value) interface{} {
 		return fmt.Sprintf("wrong number of arguments. got = %d, want = 0", len(args))
 	}
 
-	env = object.NewEnvironment()
+	env = newEnvironment()
+	machine = newMachine()
+	return nil
+}
+
+// AddModule stores the code of a module the playground can import, modules
+// already imported are only reloaded after a reset
+func AddModule(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 {
+		return fmt.Sprintf("wrong number of arguments. got = %d, want = 2", len(args))
+	}
+
+	modules.Add(args[0].String(), args[1].String())
 	return nil
 }
 
@@ -90,11 +220,8 @@ func Format(this js.Value, args []js.Value) (ret interface{}) {
 	p := parser.New(l)
 
 	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		return map[string]interface{}{
-			"type":  "PARSER_ERROR",
-			"value": strings.Join(p.Errors(), "\n"),
-		}
+	if len(p.Diagnostics()) != 0 {
+		return parserErrorResult(p.Diagnostics())
 	}
 
 	formatter := format.New()
@@ -122,13 +249,8 @@ func JsonAst(this js.Value, args []js.Value) (ret interface{}) {
 	l := lexer.New(code)
 	p := parser.New(l)
 
+	// Broken programs still have an AST, recovery fills the gaps with Bad nodes
 	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		return map[string]interface{}{
-			"type":  "PARSER_ERROR",
-			"value": strings.Join(p.Errors(), "\n"),
-		}
-	}
 
 	astJson, err := json.MarshalIndent(program, "", "  ")
 	if err != nil {
@@ -138,10 +260,14 @@ func JsonAst(this js.Value, args []js.Value) (ret interface{}) {
 		}
 	}
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"type":  "JSON_AST",
 		"value": string(astJson),
 	}
+	if len(p.Diagnostics()) != 0 {
+		result["diagnostics"] = parserErrorResult(p.Diagnostics())["diagnostics"]
+	}
+	return result
 }
 
 func RegisterCallbacks() {
@@ -149,11 +275,13 @@ func RegisterCallbacks() {
 	js.Global().Set("resetApeEnvironment", js.FuncOf(Reset))
 	js.Global().Set("formatApeProgram", js.FuncOf(Format))
 	js.Global().Set("getApeAst", js.FuncOf(JsonAst))
+	js.Global().Set("addApeModule", js.FuncOf(AddModule))
 }
 
 func main() {
 	c := make(chan struct{}, 0)
-	env = object.NewEnvironment()
+	env = newEnvironment()
+	machine = newMachine()
 
 	fmt.Println("APE Interpreter Initialized")
 	RegisterCallbacks()
@@ -1,3 +1,13 @@
 module github.com/JasirZaeem/ape
 
 go 1.19
+
+require (
+	github.com/peterh/liner v1.2.2
+	golang.org/x/term v0.5.0
+)
+
+require (
+	github.com/mattn/go-runewidth v0.0.3 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+)
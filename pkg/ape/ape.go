@@ -0,0 +1,263 @@
+// Package ape embeds the Ape interpreter in Go programs.
+//
+//	interp := ape.New()
+//	interp.Register("env", os.Getenv)
+//	if _, err := interp.EvalFile("rules.ape"); err != nil {
+//		log.Fatal(err)
+//	}
+//	allowed, err := interp.Call("allow", map[string]interface{}{"user": "ape"})
+//
+// Programs evaluated by an Interpreter share their top level variables, like
+// the lines entered in the REPL, so functions defined by one can be called
+// from Go and by the programs evaluated after it.
+package ape
+
+import (
+	"context"
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/module"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"io"
+	"os"
+	"strings"
+)
+
+// Interpreter evaluates Ape programs for a Go program, it must not be used by
+// more than one goroutine at a time
+type Interpreter struct {
+	// Names defined by the host, visible to the programs and every module
+	// they import
+	globals *object.Environment
+	env     *object.Environment
+	limits  object.Limits
+	// The IO of the programs, kept when the output or input is set so the
+	// programs and their functions called later share it
+	io    *object.IO
+	stdin io.Reader
+	// Reads the lines of stdin, nil while stdin is that of StandardIO
+	input *object.IO
+}
+
+// New creates an interpreter whose programs import modules from files,
+// relative to the file importing them or to the working directory
+func New() *Interpreter {
+	return NewWithLoader(module.NewFileLoader())
+}
+
+// NewWithLoader is New for programs that import modules through loader
+func NewWithLoader(loader module.Loader) *Interpreter {
+	globals := object.NewEnvironment()
+	modules := evaluator.NewModules(loader)
+	modules.SetGlobals(globals)
+
+	i := &Interpreter{
+		globals: globals,
+		env:     modules.NewEnvironment(""),
+		stdin:   os.Stdin,
+	}
+	i.io = &object.IO{Stdout: os.Stdout, ReadLine: i.readLine}
+	return i
+}
+
+// Define binds name to value converted by ToObject, for the programs of the
+// interpreter and the modules they import
+func (i *Interpreter) Define(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("cannot define %s: %w", name, err)
+	}
+	i.globals.Set(name, obj)
+	return nil
+}
+
+// Register defines fn as a function called name, see Function for the
+// functions that can be registered
+func (i *Interpreter) Register(name string, fn interface{}) error {
+	builtin, err := Function(name, fn)
+	if err != nil {
+		return err
+	}
+	i.globals.Set(name, builtin)
+	return nil
+}
+
+// SetStdout sets where print and the other output builtins write to,
+// os.Stdout by default
+func (i *Interpreter) SetStdout(w io.Writer) {
+	i.io.Stdout = w
+}
+
+// SetStdin sets where input and read_line read from, os.Stdin by default.
+// Input read ahead from the previous reader is dropped.
+func (i *Interpreter) SetStdin(r io.Reader) {
+	i.stdin = r
+	i.input = object.NewIO(io.Discard, r)
+}
+
+// Stdout returns the writer set by SetStdout
+func (i *Interpreter) Stdout() io.Writer {
+	return i.io.Stdout
+}
+
+// Stdin returns the reader set by SetStdin
+func (i *Interpreter) Stdin() io.Reader {
+	return i.stdin
+}
+
+// readLine reads a line of input for the programs, showing prompt on the
+// output set by SetStdout
+func (i *Interpreter) readLine(prompt string) (string, error) {
+	io.WriteString(i.io.Stdout, prompt)
+	input := i.input
+	if input == nil {
+		// Other readers of os.Stdin would take the input buffered by a
+		// reader of its own
+		input = object.StandardIO
+	}
+	return input.ReadLine("")
+}
+
+// SetLimits caps what evaluating programs and calling their functions may
+// use, see evaluator.EvalWithLimits
+func (i *Interpreter) SetLimits(limits object.Limits) {
+	i.limits = limits
+}
+
+// Eval evaluates source and returns the value of its last statement, nil if
+// it has none
+func (i *Interpreter) Eval(source string) (object.Object, error) {
+	return i.EvalContext(context.Background(), source)
+}
+
+// EvalContext is Eval stopped with a TimeoutError or CancelledError once ctx
+// is done
+func (i *Interpreter) EvalContext(ctx context.Context, source string) (object.Object, error) {
+	return i.eval(ctx, "", source)
+}
+
+// EvalFile is Eval for the file at path, the modules it imports are found
+// relative to it
+func (i *Interpreter) EvalFile(path string) (object.Object, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return i.eval(context.Background(), path, string(source))
+}
+
+func (i *Interpreter) eval(ctx context.Context, name, source string) (object.Object, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		return nil, &SyntaxError{Name: name, Source: source, Diagnostics: p.Diagnostics()}
+	}
+
+	var errors []diagnostic.Diagnostic
+	for _, d := range evaluator.Resolve(program, i.env) {
+		if d.Severity == diagnostic.ERROR {
+			errors = append(errors, d)
+		}
+	}
+	if len(errors) != 0 {
+		return nil, &SyntaxError{Name: name, Source: source, Diagnostics: errors}
+	}
+
+	// Imports and errors are relative to the file being evaluated
+	mod := i.env.Module()
+	previous := mod.Name
+	mod.Name = name
+	defer func() { mod.Name = previous }()
+
+	i.env.SetRuntime(&object.Runtime{IO: i.io})
+	return result(evaluator.EvalWithLimits(ctx, program, i.env, i.limits))
+}
+
+// Get returns the value of a top level variable of the programs evaluated or
+// of a name defined by the host
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	return i.env.Get(name)
+}
+
+// Call calls the function called name with args converted by ToObject
+func (i *Interpreter) Call(name string, args ...interface{}) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is Call stopped with a TimeoutError or CancelledError once ctx
+// is done
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...interface{}) (object.Object, error) {
+	fn, ok := i.env.Get(name)
+	if !ok {
+		if fn, ok = evaluator.Builtin(name); !ok {
+			return nil, fmt.Errorf("cannot call %s: no function named %s", name, name)
+		}
+	}
+	return i.call(ctx, fn, args)
+}
+
+// CallFunction calls fn, an Ape function or a builtin, with args converted
+// by ToObject
+func (i *Interpreter) CallFunction(fn object.Object, args ...interface{}) (object.Object, error) {
+	return i.call(context.Background(), fn, args)
+}
+
+func (i *Interpreter) call(ctx context.Context, fn object.Object, args []interface{}) (object.Object, error) {
+	objects := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert argument %d: %w", n+1, err)
+		}
+		objects[n] = obj
+	}
+	return result(evaluator.ApplyWithRuntime(fn, objects, &object.Runtime{Context: ctx, Limits: i.limits, IO: i.io}))
+}
+
+// result turns an error raised by Ape code into a Go error
+func result(obj object.Object) (object.Object, error) {
+	if err, ok := obj.(*object.Error); ok {
+		return nil, &Error{Object: err}
+	}
+	return obj, nil
+}
+
+// Error is an error raised by Ape code and not caught by it
+type Error struct {
+	Object *object.Error
+}
+
+// Error returns the message of the error with its traceback
+func (e *Error) Error() string {
+	return e.Object.Traceback()
+}
+
+// Kind is the kind of the error like "TypeError", errors raised by throw are
+// of kind "Error"
+func (e *Error) Kind() string {
+	return e.Object.Kind
+}
+
+// SyntaxError keeps a program from being evaluated, it has the errors found
+// by the parser or by the resolver
+type SyntaxError struct {
+	Name        string // of the file, empty for source evaluated by Eval
+	Source      string
+	Diagnostics []diagnostic.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = "<source>"
+	}
+
+	var out strings.Builder
+	for _, d := range e.Diagnostics {
+		out.WriteString(diagnostic.Render(name, e.Source, d))
+	}
+	return strings.TrimRight(out.String(), "\n")
+}
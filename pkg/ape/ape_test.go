@@ -0,0 +1,299 @@
+package ape_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/ape"
+	"github.com/JasirZaeem/ape/pkg/module"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testEval(t *testing.T, interp *ape.Interpreter, source string) object.Object {
+	t.Helper()
+
+	result, err := interp.Eval(source)
+	if err != nil {
+		t.Fatalf("error evaluating %q: %s", source, err)
+	}
+	return result
+}
+
+func TestEvalKeepsTopLevelVariables(t *testing.T) {
+	interp := ape.New()
+	testEval(t, interp, "let count = 1; let inc = fn() { count = count + 1 };")
+	testEval(t, interp, "inc(); inc();")
+
+	if result := testEval(t, interp, "count"); result.Inspect() != "3" {
+		t.Errorf("wrong count. expected = 3, got = %s", result.Inspect())
+	}
+}
+
+func TestDefineAndRegister(t *testing.T) {
+	interp := ape.New()
+	if err := interp.Define("config", map[string]interface{}{"name": "ape", "sizes": []int{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := interp.Register("repeat", strings.Repeat); err != nil {
+		t.Fatal(err)
+	}
+	if err := interp.Register("sum", func(numbers ...float64) float64 {
+		total := 0.0
+		for _, n := range numbers {
+			total += n
+		}
+		return total
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := interp.Register("fail", func(message string) (int, error) {
+		return 0, errors.New(message)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := interp.Register("explode", func(message string) int {
+		panic(message)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := interp.Register("first", func(args ...object.Object) object.Object {
+		return args[0]
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`config["name"]`, "ape"},
+		{`config["sizes"][1]`, "2"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`sum(0.5)`, "0.5"},
+		{`sum(1, 2.5)`, "3.5"},
+		{`first([1], 2)`, "[1]"},
+		{`try { fail("no") } catch (e) { e["message"] }`, "no"},
+		{`try { explode("boom") } catch (e) { e["type"] + ": " + e["message"] }`, "Error: panic in `explode`: boom"},
+		{`try { repeat(1, 2) } catch (e) { e["message"] }`, "argument to `repeat` must be STRING, got INTEGER"},
+		{`try { repeat("a") } catch (e) { e["message"] }`, "wrong number of arguments. got = 1, want = 2"},
+	}
+
+	for _, tt := range tests {
+		if result := testEval(t, interp, tt.input); result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected = %s, got = %s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+
+	if err := interp.Register("bad", 1); err == nil {
+		t.Errorf("expected an error registering a value that is not a function")
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := ape.New()
+	testEval(t, interp, `let greet = fn(who) { "hello " + who["name"] };`)
+
+	result, err := interp.Call("greet", map[string]string{"name": "ape"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ape.FromObject(result); got != "hello ape" {
+		t.Errorf("wrong result. expected = %q, got = %v", "hello ape", got)
+	}
+
+	result, err = interp.Call("len", []int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ape.FromObject(result); got != int64(3) {
+		t.Errorf("wrong result calling a builtin. expected = 3, got = %v", got)
+	}
+
+	fn := testEval(t, interp, "fn(a, b) { a * b }")
+	result, err = interp.CallFunction(fn, 6, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ape.FromObject(result); got != int64(42) {
+		t.Errorf("wrong result calling a function. expected = 42, got = %v", got)
+	}
+
+	if _, err := interp.Call("missing"); err == nil {
+		t.Errorf("expected an error calling a function that is not defined")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	interp := ape.New()
+
+	tests := []struct {
+		input    string
+		kind     string
+		expected string
+	}{
+		{"let = 1", "syntax", "<source>:1:5: error[P"},
+		{"a + 1", "syntax", "<source>:1:1: error[R001]: undefined name a"},
+		{"1 + true", "TypeError", "type mismatch: INTEGER + BOOLEAN"},
+		{`throw "up"`, "Error", "up"},
+	}
+
+	for _, tt := range tests {
+		_, err := interp.Eval(tt.input)
+
+		var syntaxErr *ape.SyntaxError
+		var evalErr *ape.Error
+		switch {
+		case errors.As(err, &syntaxErr):
+			if tt.kind != "syntax" {
+				t.Errorf("wrong error for %q. expected = %s, got = syntax error %q", tt.input, tt.kind, err)
+			}
+		case errors.As(err, &evalErr):
+			if evalErr.Kind() != tt.kind {
+				t.Errorf("wrong error kind for %q. expected = %s, got = %s", tt.input, tt.kind, evalErr.Kind())
+			}
+		default:
+			t.Errorf("expected an error for %q, got = %v", tt.input, err)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error for %q. expected to contain %q, got = %q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	interp := ape.New()
+	interp.SetLimits(object.Limits{MaxSteps: 1000})
+	testEval(t, interp, "let spin = fn() { while (true) {} };")
+
+	_, err := interp.Call("spin")
+	var evalErr *ape.Error
+	if !errors.As(err, &evalErr) || evalErr.Kind() != "StepLimitError" {
+		t.Errorf("expected a StepLimitError, got = %v", err)
+	}
+}
+
+func TestModulesSeeHostDefinitions(t *testing.T) {
+	loader := module.NewMemoryLoader(map[string]string{
+		"greet.ape": `export let greet = fn() { prefix + "ape" };`,
+	})
+	interp := ape.NewWithLoader(loader)
+	if err := interp.Define("prefix", "hello "); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := testEval(t, interp, `import "greet.ape" as g; g["greet"]()`); result.Inspect() != "hello ape" {
+		t.Errorf("wrong result. expected = %q, got = %q", "hello ape", result.Inspect())
+	}
+}
+
+func TestIO(t *testing.T) {
+	interp := ape.New()
+	var out bytes.Buffer
+	interp.SetStdout(&out)
+	interp.SetStdin(strings.NewReader("ape\n"))
+
+	testEval(t, interp, `print("a", 1); let greet = fn() { printf("hello %s\n", read_line()) };`)
+	if _, err := interp.Call("greet"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "a1\nhello ape\n"
+	if out.String() != expected {
+		t.Errorf("wrong output. expected = %q, got = %q", expected, out.String())
+	}
+}
+
+func TestSetStdoutKeepsInput(t *testing.T) {
+	interp := ape.New()
+	var first, second bytes.Buffer
+	interp.SetStdout(&first)
+	interp.SetStdin(strings.NewReader("one\ntwo\n"))
+
+	testEval(t, interp, `print(input("> "))`)
+	// The second line was read ahead with the first one
+	interp.SetStdout(&second)
+	testEval(t, interp, `print(input("> "))`)
+
+	if first.String() != "> one\n" || second.String() != "> two\n" {
+		t.Errorf("wrong output. got = %q and %q", first.String(), second.String())
+	}
+}
+
+func TestConversions(t *testing.T) {
+	huge, _ := new(big.Int).SetString("18446744073709551615", 10)
+	cyclic := []interface{}{1, nil}
+	cyclic[1] = cyclic
+	self := map[string]interface{}{}
+	self["self"] = self
+	shared := []interface{}{"a"}
+
+	tests := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{nil, nil},
+		{true, true},
+		{int8(-3), int64(-3)},
+		{uint(7), int64(7)},
+		{uint64(18446744073709551615), huge},
+		{float32(1.5), 1.5},
+		{"ape", "ape"},
+		{[2]string{"a", "b"}, []interface{}{"a", "b"}},
+		{map[string]int{"1": 1}, map[string]interface{}{"1": int64(1)}},
+		{map[int]bool{1: true}, map[interface{}]interface{}{int64(1): true}},
+		{map[interface{}]string{1: "a", "1": "b"}, map[interface{}]interface{}{int64(1): "a", "1": "b"}},
+		{&struct{}{}, fmt.Errorf("cannot convert struct {} to an Ape value")},
+		{(*int)(nil), nil},
+		{cyclic, fmt.Errorf("cannot convert []interface {} that contains itself")},
+		{self, fmt.Errorf("cannot convert map[string]interface {} that contains itself")},
+		{[]interface{}{shared, shared}, []interface{}{[]interface{}{"a"}, []interface{}{"a"}}},
+	}
+
+	for _, tt := range tests {
+		obj, err := ape.ToObject(tt.value)
+		if expected, ok := tt.expected.(error); ok {
+			if err == nil || err.Error() != expected.Error() {
+				t.Errorf("wrong error converting %#v. expected = %q, got = %v", tt.value, expected, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("error converting %#v: %s", tt.value, err)
+			continue
+		}
+
+		if got := ape.FromObject(obj); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong round trip for %#v. expected = %#v, got = %#v", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestMapsConvertInKeyOrder(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{map[string]int{"c": 3, "a": 1, "b": 2, "d": 4}, "{a: 1, b: 2, c: 3, d: 4}"},
+		{map[int]bool{10: true, -1: false, 3: true}, "{-1: false, 3: true, 10: true}"},
+		{map[interface{}]int{"a": 1, 2: 2, true: 3}, "{true: 3, 2: 2, a: 1}"},
+	}
+
+	for _, tt := range tests {
+		// Go randomizes the order of maps, converting a few times would see it
+		for i := 0; i < 10; i++ {
+			obj, err := ape.ToObject(tt.value)
+			if err != nil {
+				t.Fatalf("error converting %#v: %s", tt.value, err)
+			}
+			if obj.Inspect() != tt.expected {
+				t.Fatalf("wrong order for %#v. expected = %q, got = %q", tt.value, tt.expected, obj.Inspect())
+			}
+		}
+	}
+}
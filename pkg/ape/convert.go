@@ -0,0 +1,432 @@
+package ape
+
+import (
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+)
+
+// ToObject converts a Go value to the Ape object for it:
+//
+//	nil                      null
+//	bool                     boolean
+//	integers, *big.Int       integer
+//	floats                   float
+//	string                   string
+//	slices and arrays        array
+//	maps                     hash ordered by key, the keys must be usable as hash keys
+//	functions                function, see Function
+//	pointers                 the value pointed to
+//	object.Object            itself
+func ToObject(value interface{}) (object.Object, error) {
+	if value == nil {
+		return evaluator.NULL, nil
+	}
+	if obj, ok := value.(object.Object); ok {
+		return obj, nil
+	}
+	return toObject(reflect.ValueOf(value), map[visit]bool{})
+}
+
+// visit is a pointer, map or slice being converted, one converted again
+// before it is done contains itself
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func toObject(v reflect.Value, seen map[visit]bool) (object.Object, error) {
+	if v.Type().Implements(objectType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+	if v.Type() == bigIntType {
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return object.NewInteger(new(big.Int).Set(v.Interface().(*big.Int))), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if !v.IsNil() {
+			key := visit{v.Pointer(), v.Type(), 0}
+			if v.Kind() == reflect.Slice {
+				key.len = v.Len()
+			}
+			if seen[key] {
+				return nil, fmt.Errorf("cannot convert %s that contains itself", v.Type())
+			}
+			seen[key] = true
+			defer delete(seen, key)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return object.NewInteger(new(big.Int).SetUint64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := toObject(v.Index(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		hash := object.NewHash()
+		for _, k := range sortedKeys(v) {
+			key, err := toObject(k, seen)
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := toObject(v.MapIndex(k), seen)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(hashable, value)
+		}
+		return hash, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return function("function", v)
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return toObject(v.Elem(), seen)
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to an Ape value", v.Type())
+}
+
+// sortedKeys returns the keys of the map v in order, Go does not keep the order
+// of maps but hashes converted from them should always have the same order
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+	return keys
+}
+
+// lessKey orders map keys by their value, keys of different types by the name
+// of their type
+func lessKey(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	if !a.IsValid() || !b.IsValid() {
+		return !a.IsValid() && b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return a.Type().String() < b.Type().String()
+	}
+
+	switch a.Kind() {
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	}
+	return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
+
+// FromObject converts an Ape object to the Go value for it:
+//
+//	null      nil
+//	boolean   bool
+//	integer   int64, or *big.Int if it does not fit
+//	float     float64
+//	string    string
+//	array     []interface{}
+//	hash      map[string]interface{}, or map[interface{}]interface{} if a key
+//	          is not a string
+//
+// Other objects, like functions, are returned as they are, and so are arrays
+// and hashes used as hash keys.
+func FromObject(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil
+	case *object.Boolean:
+		return obj.Value
+	case *object.Integer:
+		return obj.Value
+	case *object.BigInt:
+		return new(big.Int).Set(obj.Value)
+	case *object.Float:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		values := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			values[i] = FromObject(element)
+		}
+		return values
+	case *object.Hash:
+		pairs := obj.OrderedPairs()
+		if !stringKeys(pairs) {
+			values := make(map[interface{}]interface{}, len(pairs))
+			for _, pair := range pairs {
+				key := FromObject(pair.Key)
+				// Go can not use slices and maps as keys
+				if key != nil && !reflect.TypeOf(key).Comparable() {
+					key = pair.Key
+				}
+				values[key] = FromObject(pair.Value)
+			}
+			return values
+		}
+
+		values := make(map[string]interface{}, len(pairs))
+		for _, pair := range pairs {
+			values[pair.Key.(*object.String).Value] = FromObject(pair.Value)
+		}
+		return values
+	}
+	return obj
+}
+
+func stringKeys(pairs []object.HashPair) bool {
+	for _, pair := range pairs {
+		if _, ok := pair.Key.(*object.String); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Function converts a Go function to a builtin Ape function called name.
+// A BuiltinFunction, or a function of the same signature, gets its arguments
+// as they are. Other functions get them converted to the types of their
+// parameters, an argument that can not be converted is a TypeError. Their
+// results are converted by ToObject, no result gives null and a last result
+// of type error raises an error when it is not nil, and so does a panic.
+func Function(name string, fn interface{}) (*object.Builtin, error) {
+	switch fn := fn.(type) {
+	case object.BuiltinFunction:
+		return &object.Builtin{Fn: fn}, nil
+	case func(args ...object.Object) object.Object:
+		return &object.Builtin{Fn: fn}, nil
+	}
+
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("cannot register %s: %T is not a function", name, fn)
+	}
+	return function(name, v)
+}
+
+func function(name string, fn reflect.Value) (*object.Builtin, error) {
+	t := fn.Type()
+
+	results := t.NumOut()
+	returnsError := results > 0 && t.Out(results-1) == errorType
+	if returnsError {
+		results--
+	}
+	if results > 1 {
+		return nil, fmt.Errorf("cannot register %s: functions return one value and an optional error, %s returns %d values", name, t, results)
+	}
+
+	params := t.NumIn()
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if len(args) != params && !(t.IsVariadic() && len(args) >= params-1) {
+			return evaluator.NewError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = %d", len(args), params)
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var paramType reflect.Type
+			if t.IsVariadic() && i >= params-1 {
+				paramType = t.In(params - 1).Elem()
+			} else {
+				paramType = t.In(i)
+			}
+			value, err := fromObject(arg, paramType)
+			if err != nil {
+				return evaluator.NewError(object.TYPE_ERROR, "argument to `%s` must be %s, got %s", name, err, arg.Type())
+			}
+			in[i] = value
+		}
+
+		out, panicked := call(name, fn, in)
+		if panicked != nil {
+			return panicked
+		}
+		if returnsError {
+			if err := out[results]; !err.IsNil() {
+				return evaluator.NewError(object.ERROR, "%s", err.Interface().(error))
+			}
+		}
+		if results == 0 {
+			return evaluator.NULL
+		}
+
+		obj, err := toObject(out[0], map[visit]bool{})
+		if err != nil {
+			return evaluator.NewError(object.ERROR, "result of `%s`: %s", name, err)
+		}
+		return obj
+	}}, nil
+}
+
+// call calls fn with in, a panic in fn is returned as an error so that it
+// does not unwind through the interpreter and the host
+func call(name string, fn reflect.Value, in []reflect.Value) (out []reflect.Value, err *object.Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = evaluator.NewError(object.ERROR, "panic in `%s`: %v", name, r)
+		}
+	}()
+	return fn.Call(in), nil
+}
+
+// typeError is the description of the Ape values a Go type takes, for errors
+// converting arguments
+type typeError string
+
+func (e typeError) Error() string { return string(e) }
+
+// fromObject converts obj to a value of type t
+func fromObject(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		value := FromObject(obj)
+		if value == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(value), nil
+	}
+	if t == bigIntType {
+		if value, ok := object.ToBig(obj); ok {
+			return reflect.ValueOf(new(big.Int).Set(value)), nil
+		}
+		return reflect.Value{}, typeError("INTEGER")
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := obj.(*object.Boolean)
+		if !ok {
+			return v, typeError("BOOLEAN")
+		}
+		v.SetBool(b.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*object.Integer)
+		if !ok || v.OverflowInt(i.Value) {
+			return v, typeError(fmt.Sprintf("INTEGER that fits in %s", t))
+		}
+		v.SetInt(i.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value, ok := object.ToBig(obj)
+		if !ok || value.Sign() < 0 || !value.IsUint64() || v.OverflowUint(value.Uint64()) {
+			return v, typeError(fmt.Sprintf("INTEGER that fits in %s", t))
+		}
+		v.SetUint(value.Uint64())
+	case reflect.Float32, reflect.Float64:
+		switch number := obj.(type) {
+		case *object.Float:
+			v.SetFloat(number.Value)
+		case *object.Integer:
+			v.SetFloat(float64(number.Value))
+		default:
+			return v, typeError("FLOAT")
+		}
+		if t.Kind() == reflect.Float32 && v.OverflowFloat(v.Float()) && !math.IsInf(v.Float(), 0) {
+			return v, typeError("FLOAT that fits in float32")
+		}
+	case reflect.String:
+		s, ok := obj.(*object.String)
+		if !ok {
+			return v, typeError("STRING")
+		}
+		v.SetString(s.Value)
+	case reflect.Slice:
+		array, ok := obj.(*object.Array)
+		if !ok {
+			return v, typeError("ARRAY")
+		}
+		v.Set(reflect.MakeSlice(t, len(array.Elements), len(array.Elements)))
+		for i, element := range array.Elements {
+			value, err := fromObject(element, t.Elem())
+			if err != nil {
+				return v, typeError(fmt.Sprintf("ARRAY of %s", err))
+			}
+			v.Index(i).Set(value)
+		}
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return v, typeError("HASH")
+		}
+		v.Set(reflect.MakeMapWithSize(t, hash.Len()))
+		for _, pair := range hash.OrderedPairs() {
+			key, err := fromObject(pair.Key, t.Key())
+			if err != nil {
+				return v, typeError(fmt.Sprintf("HASH with %s keys", err))
+			}
+			value, err := fromObject(pair.Value, t.Elem())
+			if err != nil {
+				return v, typeError(fmt.Sprintf("HASH of %s", err))
+			}
+			v.SetMapIndex(key, value)
+		}
+	default:
+		return v, typeError(t.String())
+	}
+
+	return v, nil
+}
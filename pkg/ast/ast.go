@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/JasirZaeem/ape/pkg/token"
+	"math/big"
+	"strconv"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Span() token.Span
 }
 
 type Statement interface {
@@ -24,6 +27,8 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Comments   []*Comment `json:",omitempty"`
+	Resolved   bool       `json:"-"` // set once the resolver bound its identifiers
 }
 
 func (p *Program) TokenLiteral() string {
@@ -34,6 +39,16 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Span() token.Span {
+	if len(p.Statements) == 0 {
+		return token.Span{}
+	}
+	return token.Span{
+		Start: p.Statements[0].Span().Start,
+		End:   p.Statements[len(p.Statements)-1].Span().End,
+	}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -44,6 +59,44 @@ func (p *Program) String() string {
 	return out.String()
 }
 
+// Comment is kept beside the statements of a Program rather than in the tree,
+// its text includes the # or /* */ delimiters
+type Comment struct {
+	Token token.Token
+}
+
+func (c *Comment) TokenLiteral() string { return c.Token.Literal }
+func (c *Comment) Span() token.Span     { return c.Token.Span() }
+func (c *Comment) String() string       { return c.Token.Literal }
+func (c *Comment) Text() string         { return c.Token.Literal }
+func (c *Comment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string
+		Span token.Span
+		Text string
+	}{
+		Type: "Comment",
+		Span: c.Span(),
+		Text: c.Text(),
+	})
+}
+
+// startOf returns where node starts, or fallback if the node is missing
+func startOf(node Node, fallback token.Position) token.Position {
+	if node == nil {
+		return fallback
+	}
+	return node.Span().Start
+}
+
+// endOf returns where node ends, or fallback if the node is missing
+func endOf(node Node, fallback token.Position) token.Position {
+	if node == nil {
+		return fallback
+	}
+	return node.Span().End
+}
+
 type LetStatement struct {
 	Token token.Token
 	Name  *Identifier
@@ -52,6 +105,13 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Span() token.Span {
+	end := ls.Token.End
+	if ls.Name != nil {
+		end = ls.Name.Token.End
+	}
+	return token.Span{Start: ls.Token.Start, End: endOf(ls.Value, end)}
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -70,10 +130,12 @@ func (ls *LetStatement) String() string {
 func (ls *LetStatement) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type  string
+		Span  token.Span
 		Name  string
 		Value Expression
 	}{
 		Type:  "LetStatement",
+		Span:  ls.Span(),
 		Name:  ls.Name.String(),
 		Value: ls.Value,
 	})
@@ -86,6 +148,9 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Span() token.Span {
+	return token.Span{Start: rs.Token.Start, End: endOf(rs.ReturnValue, rs.Token.End)}
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -102,13 +167,149 @@ func (rs *ReturnStatement) String() string {
 func (rs *ReturnStatement) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type        string
+		Span        token.Span
 		ReturnValue Expression
 	}{
 		Type:        "ReturnStatement",
+		Span:        rs.Span(),
 		ReturnValue: rs.ReturnValue,
 	})
 }
 
+type ImportStatement struct {
+	Token token.Token
+	Path  *StringLiteral
+	Alias *Identifier
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) Span() token.Span {
+	return token.Span{Start: is.Token.Start, End: is.Alias.Token.End}
+}
+func (is *ImportStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(is.TokenLiteral() + " ")
+	out.WriteString(strconv.Quote(is.Path.Value))
+	out.WriteString(" as ")
+	out.WriteString(is.Alias.String())
+	out.WriteString(";")
+
+	return out.String()
+}
+func (is *ImportStatement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type  string
+		Span  token.Span
+		Path  string
+		Alias string
+	}{
+		Type:  "ImportStatement",
+		Span:  is.Span(),
+		Path:  is.Path.Value,
+		Alias: is.Alias.Value,
+	})
+}
+
+// ExportStatement makes the name bound by Statement readable by modules that
+// import the one it is in
+type ExportStatement struct {
+	Token     token.Token
+	Statement *LetStatement
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) Span() token.Span {
+	return token.Span{Start: es.Token.Start, End: es.Statement.Span().End}
+}
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+func (es *ExportStatement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type      string
+		Span      token.Span
+		Statement *LetStatement
+	}{
+		Type:      "ExportStatement",
+		Span:      es.Span(),
+		Statement: es.Statement,
+	})
+}
+
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Span() token.Span     { return bs.Token.Span() }
+func (bs *BreakStatement) String() string       { return bs.TokenLiteral() + ";" }
+func (bs *BreakStatement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string
+		Span token.Span
+	}{
+		Type: "BreakStatement",
+		Span: bs.Span(),
+	})
+}
+
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Span() token.Span     { return cs.Token.Span() }
+func (cs *ContinueStatement) String() string       { return cs.TokenLiteral() + ";" }
+func (cs *ContinueStatement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string
+		Span token.Span
+	}{
+		Type: "ContinueStatement",
+		Span: cs.Span(),
+	})
+}
+
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Span() token.Span {
+	return token.Span{Start: ts.Token.Start, End: endOf(ts.Value, ts.Token.End)}
+}
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+func (ts *ThrowStatement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type  string
+		Span  token.Span
+		Value Expression
+	}{
+		Type:  "ThrowStatement",
+		Span:  ts.Span(),
+		Value: ts.Value,
+	})
+}
+
 type ExpressionStatement struct {
 	Token      token.Token
 	Expression Expression
@@ -116,6 +317,9 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Span() token.Span {
+	return token.Span{Start: startOf(es.Expression, es.Token.Start), End: endOf(es.Expression, es.Token.End)}
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -125,9 +329,11 @@ func (es *ExpressionStatement) String() string {
 func (es *ExpressionStatement) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type       string
+		Span       token.Span
 		Expression Expression
 	}{
 		Type:       "ExpressionStatement",
+		Span:       es.Span(),
 		Expression: es.Expression,
 	})
 }
@@ -135,10 +341,14 @@ func (es *ExpressionStatement) MarshalJSON() ([]byte, error) {
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+	EndToken   token.Token // closing delimiter
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Span() token.Span {
+	return token.Span{Start: bs.Token.Start, End: bs.EndToken.End}
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -151,9 +361,11 @@ func (bs *BlockStatement) String() string {
 func (bs *BlockStatement) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type       string
+		Span       token.Span
 		Statements []Statement
 	}{
 		Type:       "BlockStatement",
+		Span:       bs.Span(),
 		Statements: bs.Statements,
 	})
 }
@@ -164,48 +376,128 @@ type EmptyStatement struct {
 
 func (es *EmptyStatement) statementNode()       {}
 func (es *EmptyStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *EmptyStatement) Span() token.Span     { return es.Token.Span() }
 func (es *EmptyStatement) String() string       { return "" }
 func (es *EmptyStatement) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type string
+		Span token.Span
 	}{
 		Type: "EmptyStatement",
+		Span: es.Span(),
+	})
+}
+
+// BadStatement is a placeholder for a statement that could not be parsed,
+// Source holds the text skipped while recovering from the error
+type BadStatement struct {
+	Token  token.Token // token the error was found at
+	From   token.Position
+	To     token.Position
+	Source string
+}
+
+func (bs *BadStatement) statementNode()       {}
+func (bs *BadStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BadStatement) Span() token.Span     { return token.Span{Start: bs.From, End: bs.To} }
+func (bs *BadStatement) String() string       { return bs.Source }
+func (bs *BadStatement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type   string
+		Span   token.Span
+		Source string
+	}{
+		Type:   "BadStatement",
+		Span:   bs.Span(),
+		Source: bs.Source,
 	})
 }
 
 type Identifier struct {
-	Token token.Token
-	Value string
+	Token   token.Token
+	Value   string
+	Binding *Binding // set by the resolver, nil for names looked up by name
+}
+
+// Binding locates the variable an identifier refers to, Slot of the scope
+// Depth scopes out from the identifier. Top level variables and builtins are
+// not given one, they are looked up by name.
+type Binding struct {
+	Depth int
+	Slot  int
+}
+
+// Scope lists the variables of a function, for loop body or catch block in
+// the order of their slots, it is set by the resolver
+type Scope struct {
+	Names []string
 }
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Span() token.Span     { return i.Token.Span() }
 func (i *Identifier) String() string       { return i.Value }
 func (i *Identifier) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type  string
+		Span  token.Span
 		Value string
 	}{
 		Type:  "Identifier",
+		Span:  i.Span(),
 		Value: i.Value,
 	})
 }
 
+// BadExpression is a placeholder for an expression that could not be parsed,
+// Source holds the text skipped while recovering from the error
+type BadExpression struct {
+	Token  token.Token // token the error was found at
+	From   token.Position
+	To     token.Position
+	Source string
+}
+
+func (be *BadExpression) expressionNode()      {}
+func (be *BadExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BadExpression) Span() token.Span     { return token.Span{Start: be.From, End: be.To} }
+func (be *BadExpression) String() string       { return be.Source }
+func (be *BadExpression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type   string
+		Span   token.Span
+		Source string
+	}{
+		Type:   "BadExpression",
+		Span:   be.Span(),
+		Source: be.Source,
+	})
+}
+
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // value of literals too large for 64 bits, nil otherwise
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Span() token.Span     { return il.Token.Span() }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 func (il *IntegerLiteral) MarshalJSON() ([]byte, error) {
+	var value interface{} = il.Value
+	if il.Big != nil {
+		value = il.Big
+	}
+
 	return json.Marshal(struct {
 		Type  string
-		Value int64
+		Span  token.Span
+		Value interface{}
 	}{
 		Type:  "IntegerLiteral",
-		Value: il.Value,
+		Span:  il.Span(),
+		Value: value,
 	})
 }
 
@@ -216,13 +508,16 @@ type FloatLiteral struct {
 
 func (fl *FloatLiteral) expressionNode()      {}
 func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Span() token.Span     { return fl.Token.Span() }
 func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
 func (fl *FloatLiteral) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type  string
+		Span  token.Span
 		Value float64
 	}{
 		Type:  "FloatLiteral",
+		Span:  fl.Span(),
 		Value: fl.Value,
 	})
 }
@@ -235,6 +530,9 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Span() token.Span {
+	return token.Span{Start: pe.Token.Start, End: endOf(pe.Right, pe.Token.End)}
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -248,10 +546,12 @@ func (pe *PrefixExpression) String() string {
 func (pe *PrefixExpression) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type     string
+		Span     token.Span
 		Operator string
 		Right    Expression
 	}{
 		Type:     "PrefixExpression",
+		Span:     pe.Span(),
 		Operator: pe.Operator,
 		Right:    pe.Right,
 	})
@@ -266,6 +566,9 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Span() token.Span {
+	return token.Span{Start: startOf(ie.Left, ie.Token.Start), End: endOf(ie.Right, ie.Token.End)}
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -282,11 +585,13 @@ func (ie *InfixExpression) String() string {
 func (ie *InfixExpression) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type     string
+		Span     token.Span
 		Left     Expression
 		Operator string
 		Right    Expression
 	}{
 		Type:     "InfixExpression",
+		Span:     ie.Span(),
 		Left:     ie.Left,
 		Operator: ie.Operator,
 		Right:    ie.Right,
@@ -300,13 +605,16 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Span() token.Span     { return b.Token.Span() }
 func (b *Boolean) String() string       { return b.Token.Literal }
 func (b *Boolean) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type  string
+		Span  token.Span
 		Value bool
 	}{
 		Type:  "Boolean",
+		Span:  b.Span(),
 		Value: b.Value,
 	})
 }
@@ -320,6 +628,15 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Span() token.Span {
+	end := endOf(ie.Condition, ie.Token.End)
+	if ie.Alternative != nil {
+		end = ie.Alternative.EndToken.End
+	} else if ie.Consequence != nil {
+		end = ie.Consequence.EndToken.End
+	}
+	return token.Span{Start: ie.Token.Start, End: end}
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -338,11 +655,13 @@ func (ie *IfExpression) String() string {
 func (ie *IfExpression) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type        string
+		Span        token.Span
 		Condition   Expression
 		Consequence *BlockStatement
 		Alternative *BlockStatement
 	}{
 		Type:        "IfExpression",
+		Span:        ie.Span(),
 		Condition:   ie.Condition,
 		Consequence: ie.Consequence,
 		Alternative: ie.Alternative,
@@ -357,6 +676,13 @@ type WhileExpression struct {
 
 func (we *WhileExpression) expressionNode()      {}
 func (we *WhileExpression) TokenLiteral() string { return we.Token.Literal }
+func (we *WhileExpression) Span() token.Span {
+	end := endOf(we.Condition, we.Token.End)
+	if we.Body != nil {
+		end = we.Body.EndToken.End
+	}
+	return token.Span{Start: we.Token.Start, End: end}
+}
 func (we *WhileExpression) String() string {
 	var out bytes.Buffer
 
@@ -370,23 +696,153 @@ func (we *WhileExpression) String() string {
 func (we *WhileExpression) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type      string
+		Span      token.Span
 		Condition Expression
 		Body      *BlockStatement
 	}{
 		Type:      "WhileExpression",
+		Span:      we.Span(),
 		Condition: we.Condition,
 		Body:      we.Body,
 	})
 }
 
+// ForExpression binds Value to each element, character, hash key or number of
+// the range it iterates, when Key is given it gets the index or hash key and
+// Value the element or hash value
+type ForExpression struct {
+	Token    token.Token
+	Key      *Identifier
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+	Scope    *Scope // of the loop variables and the body, each iteration gets its own
+}
+
+func (fe *ForExpression) expressionNode()      {}
+func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForExpression) Span() token.Span {
+	end := endOf(fe.Iterable, fe.Token.End)
+	if fe.Body != nil {
+		end = fe.Body.EndToken.End
+	}
+	return token.Span{Start: fe.Token.Start, End: end}
+}
+func (fe *ForExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for(")
+	if fe.Key != nil {
+		out.WriteString(fe.Key.String())
+		out.WriteString(", ")
+	}
+	out.WriteString(fe.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fe.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fe.Body.String())
+
+	return out.String()
+}
+func (fe *ForExpression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type     string
+		Span     token.Span
+		Key      *Identifier
+		Value    *Identifier
+		Iterable Expression
+		Body     *BlockStatement
+	}{
+		Type:     "ForExpression",
+		Span:     fe.Span(),
+		Key:      fe.Key,
+		Value:    fe.Value,
+		Iterable: fe.Iterable,
+		Body:     fe.Body,
+	})
+}
+
+// TryExpression has a Catch block, a Finally block or both, Parameter is
+// optional and binds the caught error inside Catch
+type TryExpression struct {
+	Token      token.Token
+	Block      *BlockStatement
+	Parameter  *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+	CatchScope *Scope // of the parameter and the catch block
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Span() token.Span {
+	end := te.Token.End
+	for _, block := range []*BlockStatement{te.Block, te.Catch, te.Finally} {
+		if block != nil {
+			end = block.EndToken.End
+		}
+	}
+	return token.Span{Start: te.Token.Start, End: end}
+}
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch")
+		if te.Parameter != nil {
+			out.WriteByte('(')
+			out.WriteString(te.Parameter.String())
+			out.WriteByte(')')
+		}
+		out.WriteByte(' ')
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+func (te *TryExpression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type      string
+		Span      token.Span
+		Block     *BlockStatement
+		Parameter *Identifier
+		Catch     *BlockStatement
+		Finally   *BlockStatement
+	}{
+		Type:      "TryExpression",
+		Span:      te.Span(),
+		Block:     te.Block,
+		Parameter: te.Parameter,
+		Catch:     te.Catch,
+		Finally:   te.Finally,
+	})
+}
+
 type FunctionLiteral struct {
 	Token      token.Token
+	Name       string // name of the let binding the function was defined in, if any
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Scope      *Scope
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Span() token.Span {
+	end := fl.Token.End
+	if fl.Body != nil {
+		end = fl.Body.EndToken.End
+	}
+	return token.Span{Start: fl.Token.Start, End: end}
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -406,10 +862,14 @@ func (fl *FunctionLiteral) String() string {
 func (fl *FunctionLiteral) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type       string
+		Span       token.Span
+		Name       string `json:",omitempty"`
 		Parameters []*Identifier
 		Body       *BlockStatement
 	}{
 		Type:       "FunctionLiteral",
+		Span:       fl.Span(),
+		Name:       fl.Name,
 		Parameters: fl.Parameters,
 		Body:       fl.Body,
 	})
@@ -419,10 +879,14 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	EndToken  token.Token // closing delimiter
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Span() token.Span {
+	return token.Span{Start: startOf(ce.Function, ce.Token.Start), End: ce.EndToken.End}
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -441,10 +905,12 @@ func (ce *CallExpression) String() string {
 func (ce *CallExpression) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type      string
+		Span      token.Span
 		Function  Expression
 		Arguments []Expression
 	}{
 		Type:      "CallExpression",
+		Span:      ce.Span(),
 		Function:  ce.Function,
 		Arguments: ce.Arguments,
 	})
@@ -457,13 +923,16 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Span() token.Span     { return sl.Token.Span() }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 func (sl *StringLiteral) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type  string
+		Span  token.Span
 		Value string
 	}{
 		Type:  "StringLiteral",
+		Span:  sl.Span(),
 		Value: sl.Value,
 	})
 }
@@ -471,10 +940,14 @@ func (sl *StringLiteral) MarshalJSON() ([]byte, error) {
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	EndToken token.Token // closing delimiter
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Span() token.Span {
+	return token.Span{Start: al.Token.Start, End: al.EndToken.End}
+}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -492,21 +965,27 @@ func (al *ArrayLiteral) String() string {
 func (al *ArrayLiteral) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type     string
+		Span     token.Span
 		Elements []Expression
 	}{
 		Type:     "ArrayLiteral",
+		Span:     al.Span(),
 		Elements: al.Elements,
 	})
 }
 
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token
+	Left     Expression
+	Index    Expression
+	EndToken token.Token // closing delimiter
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Span() token.Span {
+	return token.Span{Start: startOf(ie.Left, ie.Token.Start), End: ie.EndToken.End}
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -521,27 +1000,39 @@ func (ie *IndexExpression) String() string {
 func (ie *IndexExpression) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type  string
+		Span  token.Span
 		Left  Expression
 		Index Expression
 	}{
 		Type:  "IndexExpression",
+		Span:  ie.Span(),
 		Left:  ie.Left,
 		Index: ie.Index,
 	})
 }
 
+// HashPair is a key and its value in a HashLiteral
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token    token.Token
+	Pairs    []HashPair  // in source order
+	EndToken token.Token // closing delimiter
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Span() token.Span {
+	return token.Span{Start: hl.Token.Start, End: hl.EndToken.End}
+}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 	out.WriteByte('{')
 	out.WriteString(strings.Join(pairs, ", "))
@@ -549,26 +1040,18 @@ func (hl *HashLiteral) String() string {
 	return out.String()
 }
 func (hl *HashLiteral) MarshalJSON() ([]byte, error) {
-
-	pairs := []struct {
-		Key   Expression
-		Value Expression
-	}{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, struct {
-			Key   Expression
-			Value Expression
-		}{Key: key, Value: value})
+	pairs := hl.Pairs
+	if pairs == nil {
+		pairs = []HashPair{}
 	}
 
 	return json.Marshal(struct {
 		Type  string
-		Pairs []struct {
-			Key   Expression
-			Value Expression
-		}
+		Span  token.Span
+		Pairs []HashPair
 	}{
 		Type:  "HashLiteral",
+		Span:  hl.Span(),
 		Pairs: pairs,
 	})
 }
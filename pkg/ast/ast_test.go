@@ -7,6 +7,7 @@ import (
 	"github.com/JasirZaeem/ape/pkg/lexer"
 	"github.com/JasirZaeem/ape/pkg/parser"
 	"github.com/JasirZaeem/ape/pkg/token"
+	"strings"
 	"testing"
 )
 
@@ -62,9 +63,33 @@ func TestJSONMarshalling(t *testing.T) {
   "Statements": [
     {
       "Type": "LetStatement",
+      "Span": {
+        "Start": {
+          "Offset": 0,
+          "Line": 1,
+          "Column": 1
+        },
+        "End": {
+          "Offset": 10,
+          "Line": 1,
+          "Column": 11
+        }
+      },
       "Name": "y",
       "Value": {
         "Type": "IntegerLiteral",
+        "Span": {
+          "Start": {
+            "Offset": 8,
+            "Line": 1,
+            "Column": 9
+          },
+          "End": {
+            "Offset": 10,
+            "Line": 1,
+            "Column": 11
+          }
+        },
         "Value": 10
       }
     }
@@ -76,3 +101,55 @@ func TestJSONMarshalling(t *testing.T) {
 		t.Fatalf("expected json to be %s, got %s", output, result)
 	}
 }
+
+func TestLoopJumpJSONMarshalling(t *testing.T) {
+	tests := []struct {
+		node     ast.Statement
+		expected string
+	}{
+		{
+			&ast.BreakStatement{Token: token.Token{Type: token.BREAK, Literal: "break"}},
+			`{"Type":"BreakStatement","Span":{"Start":{"Offset":0,"Line":0,"Column":0},"End":{"Offset":0,"Line":0,"Column":0}}}`,
+		},
+		{
+			&ast.ContinueStatement{Token: token.Token{Type: token.CONTINUE, Literal: "continue"}},
+			`{"Type":"ContinueStatement","Span":{"Start":{"Offset":0,"Line":0,"Column":0},"End":{"Offset":0,"Line":0,"Column":0}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		result, err := json.Marshal(tt.node)
+		if err != nil {
+			t.Fatalf("error marshalling %T to json: %v", tt.node, err)
+		}
+
+		if string(result) != tt.expected {
+			t.Errorf("expected json to be %s, got %s", tt.expected, result)
+		}
+	}
+}
+
+func TestHashLiteralPairOrder(t *testing.T) {
+	key := func(value string) ast.Expression {
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value}, Value: value}
+	}
+	hash := &ast.HashLiteral{
+		Token: token.Token{Type: token.LBRACE, Literal: "{"},
+		Pairs: []ast.HashPair{
+			{Key: key("z"), Value: key("1")},
+			{Key: key("a"), Value: key("2")},
+		},
+	}
+
+	if hash.String() != "{z:1, a:2}" {
+		t.Errorf("hash.String() wrong. got = %q", hash.String())
+	}
+
+	result, err := json.Marshal(hash)
+	if err != nil {
+		t.Fatalf("error marshalling hash to json: %v", err)
+	}
+	if strings.Index(string(result), `"Value":"z"`) > strings.Index(string(result), `"Value":"a"`) {
+		t.Errorf("pairs out of order in json. got = %s", result)
+	}
+}
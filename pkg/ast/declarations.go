@@ -0,0 +1,84 @@
+package ast
+
+// Declarations lists the identifiers that let, export and import statements
+// declare in the scope of stmts, in the order they appear and with repeats.
+// For loop bodies, catch blocks and functions have scopes of their own so the
+// names declared in them are skipped, other blocks are searched.
+func Declarations(stmts []Statement) []*Identifier {
+	var names []*Identifier
+	for _, stmt := range stmts {
+		names = appendStatementDeclarations(names, stmt)
+	}
+	return names
+}
+
+func appendStatementDeclarations(names []*Identifier, stmt Statement) []*Identifier {
+	switch stmt := stmt.(type) {
+	case *LetStatement:
+		names = appendExpressionDeclarations(names, stmt.Value)
+		return append(names, stmt.Name)
+	case *ExportStatement:
+		return appendStatementDeclarations(names, stmt.Statement)
+	case *ImportStatement:
+		return append(names, stmt.Alias)
+	case *ExpressionStatement:
+		return appendExpressionDeclarations(names, stmt.Expression)
+	case *ReturnStatement:
+		return appendExpressionDeclarations(names, stmt.ReturnValue)
+	case *ThrowStatement:
+		return appendExpressionDeclarations(names, stmt.Value)
+	case *BlockStatement:
+		return appendBlockDeclarations(names, stmt)
+	}
+	return names
+}
+
+func appendBlockDeclarations(names []*Identifier, block *BlockStatement) []*Identifier {
+	if block == nil {
+		return names
+	}
+	for _, stmt := range block.Statements {
+		names = appendStatementDeclarations(names, stmt)
+	}
+	return names
+}
+
+func appendExpressionDeclarations(names []*Identifier, exp Expression) []*Identifier {
+	switch exp := exp.(type) {
+	case *IfExpression:
+		names = appendExpressionDeclarations(names, exp.Condition)
+		names = appendBlockDeclarations(names, exp.Consequence)
+		return appendBlockDeclarations(names, exp.Alternative)
+	case *WhileExpression:
+		names = appendExpressionDeclarations(names, exp.Condition)
+		return appendBlockDeclarations(names, exp.Body)
+	case *ForExpression:
+		return appendExpressionDeclarations(names, exp.Iterable)
+	case *TryExpression:
+		names = appendBlockDeclarations(names, exp.Block)
+		return appendBlockDeclarations(names, exp.Finally)
+	case *PrefixExpression:
+		return appendExpressionDeclarations(names, exp.Right)
+	case *InfixExpression:
+		names = appendExpressionDeclarations(names, exp.Left)
+		return appendExpressionDeclarations(names, exp.Right)
+	case *CallExpression:
+		names = appendExpressionDeclarations(names, exp.Function)
+		for _, arg := range exp.Arguments {
+			names = appendExpressionDeclarations(names, arg)
+		}
+	case *IndexExpression:
+		names = appendExpressionDeclarations(names, exp.Left)
+		return appendExpressionDeclarations(names, exp.Index)
+	case *ArrayLiteral:
+		for _, element := range exp.Elements {
+			names = appendExpressionDeclarations(names, element)
+		}
+	case *HashLiteral:
+		for _, pair := range exp.Pairs {
+			names = appendExpressionDeclarations(names, pair.Key)
+			names = appendExpressionDeclarations(names, pair.Value)
+		}
+	}
+	return names
+}
@@ -0,0 +1,327 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/token"
+)
+
+// Instructions are the bytecode of a function, each instruction is an opcode
+// followed by its operands in big endian
+type Instructions []byte
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpDup
+	OpTrue
+	OpFalse
+	OpNull
+
+	// Binary operators, they pop the right and then the left operand
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpFloorDiv
+	OpPow
+	OpShiftLeft
+	OpShiftRight
+	OpBitAnd
+	OpBitXor
+	OpBitOr
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+
+	// Prefix operators
+	OpNot
+	OpMinus
+	OpPlus
+	OpBitNot
+
+	OpJump
+	OpJumpNotTruthy
+	// OpAnd and OpOr jump keeping the left operand when it decides the
+	// result, otherwise they pop it
+	OpAnd
+	OpOr
+
+	// Set opcodes bind a name, Assign opcodes change a name that must already
+	// be bound
+	OpGetGlobal
+	OpSetGlobal
+	OpAssignGlobal
+	OpGetLocal
+	OpSetLocal
+	OpAssignLocal
+	// Locals captured by closures live in cells, the compiler turns the
+	// Local opcodes of a captured slot into Cell opcodes
+	OpGetCell
+	OpSetCell
+	OpAssignCell
+	OpGetFree
+	OpAssignFree
+	// OpLoadCell and OpLoadFree push a cell itself for OpClosure
+	OpLoadCell
+	OpLoadFree
+	OpGetBuiltin
+	// OpResetLocals gives a loop iteration or catch block fresh locals
+	OpResetLocals
+
+	OpArray
+	OpHash
+	OpHashKey
+	OpIndex
+
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpClosure
+
+	OpIter
+	OpIterNext
+
+	OpTry
+	OpEndTry
+	OpThrow
+	OpRaise
+
+	OpImport
+	OpExport
+)
+
+// InfixOperators maps binary operators to their opcodes, && and || are
+// compiled to jumps instead
+var InfixOperators = map[string]Opcode{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"%":  OpMod,
+	"//": OpFloorDiv,
+	"**": OpPow,
+	"<<": OpShiftLeft,
+	">>": OpShiftRight,
+	"&":  OpBitAnd,
+	"^":  OpBitXor,
+	"|":  OpBitOr,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<":  OpLess,
+	"<=": OpLessEqual,
+	">":  OpGreater,
+	">=": OpGreaterEqual,
+}
+
+var PrefixOperators = map[string]Opcode{
+	"!": OpNot,
+	"-": OpMinus,
+	"+": OpPlus,
+	"~": OpBitNot,
+}
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+	OpNull:     {"OpNull", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpFloorDiv:     {"OpFloorDiv", []int{}},
+	OpPow:          {"OpPow", []int{}},
+	OpShiftLeft:    {"OpShiftLeft", []int{}},
+	OpShiftRight:   {"OpShiftRight", []int{}},
+	OpBitAnd:       {"OpBitAnd", []int{}},
+	OpBitXor:       {"OpBitXor", []int{}},
+	OpBitOr:        {"OpBitOr", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLess:         {"OpLess", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreater:      {"OpGreater", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+
+	OpNot:    {"OpNot", []int{}},
+	OpMinus:  {"OpMinus", []int{}},
+	OpPlus:   {"OpPlus", []int{}},
+	OpBitNot: {"OpBitNot", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpAnd:           {"OpAnd", []int{2}},
+	OpOr:            {"OpOr", []int{2}},
+
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpAssignGlobal: {"OpAssignGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{2}},
+	OpSetLocal:     {"OpSetLocal", []int{2}},
+	OpAssignLocal:  {"OpAssignLocal", []int{2}},
+	OpGetCell:      {"OpGetCell", []int{2}},
+	OpSetCell:      {"OpSetCell", []int{2}},
+	OpAssignCell:   {"OpAssignCell", []int{2}},
+	OpGetFree:      {"OpGetFree", []int{1}},
+	OpAssignFree:   {"OpAssignFree", []int{1}},
+	OpLoadCell:     {"OpLoadCell", []int{2}},
+	OpLoadFree:     {"OpLoadFree", []int{1}},
+	OpGetBuiltin:   {"OpGetBuiltin", []int{1}},
+	OpResetLocals:  {"OpResetLocals", []int{2, 2}},
+
+	OpArray:   {"OpArray", []int{2}},
+	OpHash:    {"OpHash", []int{2}},
+	OpHashKey: {"OpHashKey", []int{}},
+	OpIndex:   {"OpIndex", []int{}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpClosure:     {"OpClosure", []int{2, 1}},
+
+	// OpIterNext reads the iterator in a local slot and pushes 1 or 2 values,
+	// it jumps once the iterator is done
+	OpIter:     {"OpIter", []int{}},
+	OpIterNext: {"OpIterNext", []int{2, 1, 2}},
+
+	// OpTry installs a handler that catch blocks start at, with the caught
+	// error pushed
+	OpTry:    {"OpTry", []int{2}},
+	OpEndTry: {"OpEndTry", []int{}},
+	OpThrow:  {"OpThrow", []int{}},
+	OpRaise:  {"OpRaise", []int{2}},
+
+	OpImport: {"OpImport", []int{2}},
+	OpExport: {"OpExport", []int{2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes an instruction, missing operands are 0
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	length := 1
+	for _, width := range def.OperandWidths {
+		length += width
+	}
+
+	instruction := make([]byte, length)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, width := range def.OperandWidths {
+		operand := 0
+		if i < len(operands) {
+			operand = operands[i]
+		}
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(operand))
+		case 1:
+			instruction[offset] = byte(operand)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction of def, it also returns
+// how many bytes they take
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return ins[0]
+}
+
+// String disassembles the instructions, one per line with its offset
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	switch len(def.OperandWidths) {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	case 3:
+		return fmt.Sprintf("%s %d %d %d", def.Name, operands[0], operands[1], operands[2])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operand count for %s", def.Name)
+}
+
+// Position maps the instruction at Offset back to the node it was compiled
+// from, for error messages and tracebacks
+type Position struct {
+	Offset int
+	Span   token.Span
+	Callee string // name the function is called by, for OpCall
+}
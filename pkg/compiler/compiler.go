@@ -0,0 +1,713 @@
+package compiler
+
+import (
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/code"
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/token"
+)
+
+// Bytecode is a compiled program, Main runs its top level statements
+type Bytecode struct {
+	Main    *object.CompiledFunction
+	Globals []string // names of the global slots, including the ones of earlier programs
+}
+
+// Compiler compiles programs for the virtual machine. Top level names are
+// globals that stay defined for the programs compiled after, like in the REPL.
+//
+// Programs compile to code that fails the same way the evaluator does, so a
+// compiler never reports errors itself
+type Compiler struct {
+	globals     *scope
+	globalNames []string
+
+	fn    *function
+	scope *scope
+}
+
+// function is the state of a function being compiled
+type function struct {
+	instructions code.Instructions
+	constants    []object.Object
+	positions    []code.Position
+	numLocals    int
+	localNames   []string
+	cells        []bool
+	free         []freeVariable
+	outer        *function
+
+	loops   []*loop
+	regions []*tryRegion // try expressions the code being compiled is in
+	temps   int          // values pushed by enclosing expressions, a jump out of a loop pops them
+}
+
+type loop struct {
+	temps     int
+	regions   int // try expressions outside the loop
+	breaks    []int
+	continues []int
+}
+
+type tryRegion struct {
+	finally *ast.BlockStatement // nil without finally
+}
+
+var builtinIndexes = map[string]int{}
+
+func init() {
+	for i, name := range evaluator.BuiltinNames() {
+		builtinIndexes[name] = i
+	}
+}
+
+func New() *Compiler {
+	globals := newScope(nil, nil)
+	return &Compiler{globals: globals}
+}
+
+// DefineGlobal returns the slot of the global name, creating it if needed
+func (c *Compiler) DefineGlobal(name string) int {
+	if sym, ok := c.globals.symbols[name]; ok {
+		return sym.index
+	}
+
+	sym := &symbol{name: name, index: len(c.globalNames), defined: true}
+	c.globals.symbols[name] = sym
+	c.globalNames = append(c.globalNames, name)
+	return sym.index
+}
+
+// Globals returns the names of the global slots defined so far
+func (c *Compiler) Globals() []string {
+	return append([]string{}, c.globalNames...)
+}
+
+func (c *Compiler) Compile(program *ast.Program) *Bytecode {
+	c.fn = &function{}
+	c.globals.fn = c.fn
+	c.scope = c.globals
+
+	for _, name := range declaredNames(program.Statements) {
+		c.DefineGlobal(name)
+	}
+
+	returned := false
+	for i, stmt := range program.Statements {
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && i == len(program.Statements)-1 {
+			c.compileExpression(es.Expression)
+			c.emit(code.OpReturnValue)
+			returned = true
+			break
+		}
+		c.compileStatement(stmt)
+	}
+	// Like Eval the result of a program that does not end in an expression is nil
+	if !returned {
+		c.emit(code.OpReturn)
+	}
+
+	main := c.finish(c.fn, "", nil)
+	c.fn = nil
+	c.scope = nil
+
+	return &Bytecode{Main: main, Globals: c.Globals()}
+}
+
+func (c *Compiler) compileStatement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		c.compileExpression(stmt.Expression)
+		c.emit(code.OpPop)
+	case *ast.LetStatement:
+		c.compileExpression(stmt.Value)
+		c.bind(stmt.Name.Value)
+	case *ast.ReturnStatement:
+		c.compileExpression(stmt.ReturnValue)
+		c.fn.temps++
+		c.leaveRegions(0)
+		c.fn.temps--
+		c.emit(code.OpReturnValue)
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		c.compileLoopJump(stmt)
+	case *ast.ThrowStatement:
+		c.compileExpression(stmt.Value)
+		c.emitAt(stmt.Span(), code.OpThrow)
+	case *ast.ImportStatement:
+		c.emitAt(stmt.Span(), code.OpImport, c.addConstant(&object.String{Value: stmt.Path.Value}))
+		c.bind(stmt.Alias.Value)
+	case *ast.ExportStatement:
+		c.compileStatement(stmt.Statement)
+		c.emit(code.OpExport, c.addConstant(&object.String{Value: stmt.Statement.Name.Value}))
+	case *ast.BadStatement:
+		c.raise(stmt.Span(), object.SYNTAX_ERROR, fmt.Sprintf("invalid syntax: %s", stmt.Source))
+	}
+}
+
+// compileBlock compiles stmts leaving the value of the last one, null if it
+// is not an expression
+func (c *Compiler) compileBlock(block *ast.BlockStatement) {
+	if block == nil || len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return
+	}
+
+	last := len(block.Statements) - 1
+	for _, stmt := range block.Statements[:last] {
+		c.compileStatement(stmt)
+	}
+	if es, ok := block.Statements[last].(*ast.ExpressionStatement); ok {
+		c.compileExpression(es.Expression)
+		return
+	}
+	c.compileStatement(block.Statements[last])
+	c.emit(code.OpNull)
+}
+
+// compileEffect compiles block for its effects only, like finally blocks
+func (c *Compiler) compileEffect(block *ast.BlockStatement) {
+	for _, stmt := range block.Statements {
+		c.compileStatement(stmt)
+	}
+}
+
+func (c *Compiler) compileExpression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Big != nil {
+			c.emit(code.OpConstant, c.addConstant(&object.BigInt{Value: exp.Big}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: exp.Value}))
+		}
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: exp.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: exp.Value}))
+	case *ast.Boolean:
+		if exp.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.Identifier:
+		c.compileIdentifier(exp)
+	case *ast.PrefixExpression:
+		c.compileExpression(exp.Right)
+		op, ok := code.PrefixOperators[exp.Operator]
+		if !ok {
+			c.emit(code.OpPop)
+			c.raise(exp.Span(), object.TYPE_ERROR, fmt.Sprintf("unknown operator: %s", exp.Operator))
+			return
+		}
+		c.emitAt(exp.Span(), op)
+	case *ast.InfixExpression:
+		c.compileInfix(exp)
+	case *ast.IfExpression:
+		c.compileExpression(exp.Condition)
+		jumpElse := c.emit(code.OpJumpNotTruthy, 0)
+		c.compileBlock(exp.Consequence)
+		jumpEnd := c.emit(code.OpJump, 0)
+		c.patchJump(jumpElse)
+		// Without an else the value is null, like the empty block
+		c.compileBlock(exp.Alternative)
+		c.patchJump(jumpEnd)
+	case *ast.WhileExpression:
+		c.compileWhile(exp)
+	case *ast.ForExpression:
+		c.compileFor(exp)
+	case *ast.TryExpression:
+		c.compileTry(exp)
+	case *ast.FunctionLiteral:
+		c.compileFunction(exp)
+	case *ast.CallExpression:
+		c.compileExpression(exp.Function)
+		c.fn.temps++
+		for _, arg := range exp.Arguments {
+			c.compileExpression(arg)
+			c.fn.temps++
+		}
+		c.fn.temps -= len(exp.Arguments) + 1
+
+		pos := c.emit(code.OpCall, len(exp.Arguments))
+		callee := ""
+		if ident, ok := exp.Function.(*ast.Identifier); ok {
+			callee = ident.Value
+		}
+		c.addPosition(code.Position{Offset: pos, Span: exp.Span(), Callee: callee})
+	case *ast.ArrayLiteral:
+		for _, element := range exp.Elements {
+			c.compileExpression(element)
+			c.fn.temps++
+		}
+		c.fn.temps -= len(exp.Elements)
+		c.emit(code.OpArray, len(exp.Elements))
+	case *ast.HashLiteral:
+		for _, pair := range exp.Pairs {
+			c.compileExpression(pair.Key)
+			c.emitAt(exp.Span(), code.OpHashKey)
+			c.fn.temps++
+			c.compileExpression(pair.Value)
+			c.fn.temps++
+		}
+		c.fn.temps -= 2 * len(exp.Pairs)
+		c.emit(code.OpHash, len(exp.Pairs))
+	case *ast.IndexExpression:
+		c.compileExpression(exp.Left)
+		c.fn.temps++
+		c.compileExpression(exp.Index)
+		c.fn.temps--
+		c.emitAt(exp.Span(), code.OpIndex)
+	case *ast.BadExpression:
+		c.raise(exp.Span(), object.SYNTAX_ERROR, fmt.Sprintf("invalid syntax: %s", exp.Source))
+		c.emit(code.OpNull)
+	default:
+		c.emit(code.OpNull)
+	}
+}
+
+func (c *Compiler) compileInfix(exp *ast.InfixExpression) {
+	switch exp.Operator {
+	case "=":
+		c.compileExpression(exp.Right)
+		ident, ok := exp.Left.(*ast.Identifier)
+		if !ok {
+			c.raise(exp.Span(), object.SYNTAX_ERROR, "invalid assignment target")
+			return
+		}
+		c.compileAssignment(ident, exp.Span())
+		return
+	case "&&", "||":
+		c.compileExpression(exp.Left)
+		op := code.OpAnd
+		if exp.Operator == "||" {
+			op = code.OpOr
+		}
+		jump := c.emit(op, 0)
+		c.compileExpression(exp.Right)
+		c.patchJump(jump)
+		return
+	}
+
+	c.compileExpression(exp.Left)
+	c.fn.temps++
+	c.compileExpression(exp.Right)
+	c.fn.temps--
+
+	op, ok := code.InfixOperators[exp.Operator]
+	if !ok {
+		c.emit(code.OpPop)
+		c.emit(code.OpPop)
+		c.raise(exp.Span(), object.TYPE_ERROR, fmt.Sprintf("unknown operator: %s", exp.Operator))
+		return
+	}
+	c.emitAt(exp.Span(), op)
+}
+
+// resolution is where the value of a name is found
+type resolution int
+
+const (
+	GLOBAL resolution = iota
+	LOCAL
+	FREE
+	BUILTIN
+)
+
+// resolve finds the variable name refers to in the code being compiled, names
+// no scope declares are globals that may be defined later, or builtins
+func (c *Compiler) resolve(name string) (resolution, int) {
+	for s := c.scope; s != nil; s = s.outer {
+		sym, ok := s.symbols[name]
+		if !ok {
+			continue
+		}
+		if s == c.globals {
+			return GLOBAL, sym.index
+		}
+		if s.fn == c.fn {
+			if !sym.defined {
+				continue
+			}
+			return LOCAL, sym.index
+		}
+		return FREE, c.capture(c.fn, s.fn, sym)
+	}
+
+	if index, ok := builtinIndexes[name]; ok {
+		return BUILTIN, index
+	}
+	return GLOBAL, c.DefineGlobal(name)
+}
+
+// capture makes sym, a local of owner, a free variable of fn and of the
+// functions between them
+func (c *Compiler) capture(fn, owner *function, sym *symbol) int {
+	for i, free := range fn.free {
+		if free.symbol == sym {
+			return i
+		}
+	}
+
+	free := freeVariable{symbol: sym}
+	if fn.outer == owner {
+		owner.cells[sym.index] = true
+		free.local = true
+		free.index = sym.index
+	} else {
+		free.index = c.capture(fn.outer, owner, sym)
+	}
+
+	fn.free = append(fn.free, free)
+	return len(fn.free) - 1
+}
+
+func (c *Compiler) compileIdentifier(ident *ast.Identifier) {
+	kind, index := c.resolve(ident.Value)
+	switch kind {
+	case GLOBAL:
+		c.emitAt(ident.Span(), code.OpGetGlobal, index)
+	case LOCAL:
+		c.emitAt(ident.Span(), code.OpGetLocal, index)
+	case FREE:
+		c.emitAt(ident.Span(), code.OpGetFree, index)
+	case BUILTIN:
+		c.emit(code.OpGetBuiltin, index)
+	}
+}
+
+func (c *Compiler) compileAssignment(ident *ast.Identifier, span token.Span) {
+	kind, index := c.resolve(ident.Value)
+	switch kind {
+	case GLOBAL:
+		c.emitAt(span, code.OpAssignGlobal, index)
+	case LOCAL:
+		c.emitAt(span, code.OpAssignLocal, index)
+	case FREE:
+		c.emitAt(span, code.OpAssignFree, index)
+	case BUILTIN:
+		// Builtins are not variables, the global is never bound unless a
+		// later program defines it
+		c.emitAt(span, code.OpAssignGlobal, c.DefineGlobal(ident.Value))
+	}
+}
+
+// bind pops the value on top of the stack into the variable name of the
+// current scope, like let
+func (c *Compiler) bind(name string) {
+	if c.scope == c.globals {
+		c.emit(code.OpSetGlobal, c.DefineGlobal(name))
+		return
+	}
+
+	sym, ok := c.scope.symbols[name]
+	if !ok {
+		c.scope.declareLocals([]string{name}, true)
+		sym = c.scope.symbols[name]
+	}
+	sym.defined = true
+	c.emit(code.OpSetLocal, sym.index)
+}
+
+func (c *Compiler) compileWhile(we *ast.WhileExpression) {
+	result := c.fn.allocate("")
+	c.emit(code.OpNull)
+	c.emit(code.OpSetLocal, result)
+
+	start := len(c.fn.instructions)
+	c.compileExpression(we.Condition)
+	exit := c.emit(code.OpJumpNotTruthy, 0)
+
+	l := c.enterLoop()
+	c.compileBlock(we.Body)
+	c.emit(code.OpSetLocal, result)
+	c.leaveLoop(l, start)
+
+	c.emit(code.OpJump, start)
+	c.patchJump(exit)
+	c.patchJumps(l.breaks)
+	c.emit(code.OpGetLocal, result)
+}
+
+func (c *Compiler) compileFor(fe *ast.ForExpression) {
+	c.compileExpression(fe.Iterable)
+	c.emitAt(fe.Iterable.Span(), code.OpIter)
+	iterator := c.fn.allocate("")
+	c.emit(code.OpSetLocal, iterator)
+	result := c.fn.allocate("")
+	c.emit(code.OpNull)
+	c.emit(code.OpSetLocal, result)
+
+	// Every iteration gets fresh locals so closures capture that iteration's values
+	c.scope = newScope(c.fn, c.scope)
+	vars := []string{fe.Value.Value}
+	if fe.Key != nil {
+		vars = []string{fe.Key.Value, fe.Value.Value}
+	}
+	c.scope.declareLocals(vars, true)
+	c.scope.declareLocals(blockNames(fe.Body), false)
+
+	start := len(c.fn.instructions)
+	c.resetLocals(c.scope)
+	next := c.emit(code.OpIterNext, iterator, len(vars), 0)
+	for i := len(vars) - 1; i >= 0; i-- {
+		c.emit(code.OpSetLocal, c.scope.symbols[vars[i]].index)
+	}
+
+	l := c.enterLoop()
+	c.compileBlock(fe.Body)
+	c.emit(code.OpSetLocal, result)
+	c.leaveLoop(l, start)
+	c.scope = c.scope.outer
+
+	c.emit(code.OpJump, start)
+	c.patchOperand(next, 2, len(c.fn.instructions))
+	c.patchJumps(l.breaks)
+	c.emit(code.OpGetLocal, result)
+}
+
+func (c *Compiler) enterLoop() *loop {
+	l := &loop{temps: c.fn.temps, regions: len(c.fn.regions)}
+	c.fn.loops = append(c.fn.loops, l)
+	return l
+}
+
+func (c *Compiler) leaveLoop(l *loop, continueAt int) {
+	c.fn.loops = c.fn.loops[:len(c.fn.loops)-1]
+	for _, pos := range l.continues {
+		c.patchOperand(pos, 0, continueAt)
+	}
+}
+
+func (c *Compiler) compileLoopJump(stmt ast.Statement) {
+	if len(c.fn.loops) == 0 {
+		c.raise(stmt.Span(), object.SYNTAX_ERROR, fmt.Sprintf("invalid syntax: %s outside of a loop", stmt.TokenLiteral()))
+		return
+	}
+
+	l := c.fn.loops[len(c.fn.loops)-1]
+	for i := l.temps; i < c.fn.temps; i++ {
+		c.emit(code.OpPop)
+	}
+	temps := c.fn.temps
+	c.fn.temps = l.temps
+	c.leaveRegions(l.regions)
+	c.fn.temps = temps
+
+	pos := c.emit(code.OpJump, 0)
+	if _, ok := stmt.(*ast.BreakStatement); ok {
+		l.breaks = append(l.breaks, pos)
+	} else {
+		l.continues = append(l.continues, pos)
+	}
+}
+
+// leaveRegions ends the try expressions a jump leaves, innermost first,
+// running their finally blocks
+func (c *Compiler) leaveRegions(outer int) {
+	regions := c.fn.regions
+	for i := len(regions) - 1; i >= outer; i-- {
+		c.emit(code.OpEndTry)
+		if regions[i].finally != nil {
+			// A jump in the finally block only leaves the regions outside it
+			c.fn.regions = regions[:i]
+			c.compileEffect(regions[i].finally)
+		}
+	}
+	c.fn.regions = regions
+}
+
+func (c *Compiler) compileTry(te *ast.TryExpression) {
+	region := &tryRegion{finally: te.Finally}
+
+	handler := c.emit(code.OpTry, 0)
+	c.fn.regions = append(c.fn.regions, region)
+	c.compileBlock(te.Block)
+	c.fn.regions = c.fn.regions[:len(c.fn.regions)-1]
+	c.emit(code.OpEndTry)
+	c.compileFinally(te.Finally)
+	end := []int{c.emit(code.OpJump, 0)}
+
+	// The handler starts here with the caught error on the stack
+	c.patchJump(handler)
+	if te.Catch != nil {
+		c.scope = newScope(c.fn, c.scope)
+		if te.Parameter != nil {
+			c.scope.declareLocals([]string{te.Parameter.Value}, true)
+		}
+		c.scope.declareLocals(blockNames(te.Catch), false)
+		c.resetLocals(c.scope)
+		if te.Parameter != nil {
+			c.emit(code.OpSetLocal, c.scope.symbols[te.Parameter.Value].index)
+		} else {
+			c.emit(code.OpPop)
+		}
+
+		if te.Finally == nil {
+			c.compileBlock(te.Catch)
+		} else {
+			// Errors in the catch block still run finally before going on
+			rethrow := c.emit(code.OpTry, 0)
+			c.fn.regions = append(c.fn.regions, region)
+			c.compileBlock(te.Catch)
+			c.fn.regions = c.fn.regions[:len(c.fn.regions)-1]
+			c.emit(code.OpEndTry)
+			c.compileFinally(te.Finally)
+			end = append(end, c.emit(code.OpJump, 0))
+			c.patchJump(rethrow)
+			c.compileFinally(te.Finally)
+			c.emit(code.OpThrow)
+		}
+		c.scope = c.scope.outer
+	} else {
+		c.compileFinally(te.Finally)
+		c.emit(code.OpThrow)
+	}
+
+	c.patchJumps(end)
+}
+
+// compileFinally compiles a finally block run with a value on the stack
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) {
+	if finally == nil {
+		return
+	}
+	c.fn.temps++
+	c.compileEffect(finally)
+	c.fn.temps--
+}
+
+func (c *Compiler) resetLocals(s *scope) {
+	if s.count > 0 {
+		c.emit(code.OpResetLocals, s.first, s.count)
+	}
+}
+
+func (c *Compiler) compileFunction(fl *ast.FunctionLiteral) {
+	fn := &function{outer: c.fn}
+	c.fn = fn
+	c.scope = newScope(fn, c.scope)
+
+	params := make([]string, len(fl.Parameters))
+	for i, param := range fl.Parameters {
+		params[i] = param.Value
+	}
+	c.scope.declareLocals(params, true)
+	c.scope.declareLocals(blockNames(fl.Body), false)
+
+	c.compileBlock(fl.Body)
+	c.emit(code.OpReturnValue)
+
+	c.scope = c.scope.outer
+	c.fn = fn.outer
+
+	compiled := c.finish(fn, fl.Name, fl)
+	compiled.NumParameters = len(fl.Parameters)
+
+	for _, free := range fn.free {
+		if free.local {
+			c.emit(code.OpLoadCell, free.index)
+		} else {
+			c.emit(code.OpLoadFree, free.index)
+		}
+	}
+	c.emit(code.OpClosure, c.addConstant(compiled), len(fn.free))
+}
+
+// finish creates the CompiledFunction of fn once all the functions in it are
+// compiled, which is when it is known which of its locals are captured
+func (c *Compiler) finish(fn *function, name string, literal *ast.FunctionLiteral) *object.CompiledFunction {
+	ins := fn.instructions
+	for i := 0; i < len(ins); {
+		def, _ := code.Lookup(ins[i])
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		if len(operands) > 0 && operands[0] < len(fn.cells) && fn.cells[operands[0]] {
+			switch code.Opcode(ins[i]) {
+			case code.OpGetLocal:
+				ins[i] = byte(code.OpGetCell)
+			case code.OpSetLocal:
+				ins[i] = byte(code.OpSetCell)
+			case code.OpAssignLocal:
+				ins[i] = byte(code.OpAssignCell)
+			}
+		}
+
+		i += 1 + read
+	}
+
+	freeNames := make([]string, len(fn.free))
+	for i, free := range fn.free {
+		freeNames[i] = free.symbol.name
+	}
+
+	return &object.CompiledFunction{
+		Instructions: ins,
+		Constants:    fn.constants,
+		Positions:    fn.positions,
+		NumLocals:    fn.numLocals,
+		Cells:        fn.cells,
+		LocalNames:   fn.localNames,
+		FreeNames:    freeNames,
+		Name:         name,
+		Literal:      literal,
+	}
+}
+
+// allocate gives a new local slot to name, an empty name is a slot used by
+// the compiler itself
+func (fn *function) allocate(name string) int {
+	fn.numLocals++
+	fn.localNames = append(fn.localNames, name)
+	fn.cells = append(fn.cells, false)
+	return fn.numLocals - 1
+}
+
+// raise compiles code that fails with an error of kind when it runs
+func (c *Compiler) raise(span token.Span, kind, message string) {
+	c.emitAt(span, code.OpRaise, c.addConstant(&object.Error{Kind: kind, Message: message}))
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.fn.constants = append(c.fn.constants, obj)
+	return len(c.fn.constants) - 1
+}
+
+// emit appends an instruction and returns its offset
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	pos := len(c.fn.instructions)
+	c.fn.instructions = append(c.fn.instructions, code.Make(op, operands...)...)
+	return pos
+}
+
+// emitAt is emit for instructions that can fail, errors are reported at span
+func (c *Compiler) emitAt(span token.Span, op code.Opcode, operands ...int) int {
+	pos := c.emit(op, operands...)
+	c.addPosition(code.Position{Offset: pos, Span: span})
+	return pos
+}
+
+func (c *Compiler) addPosition(position code.Position) {
+	c.fn.positions = append(c.fn.positions, position)
+}
+
+// patchJump points the jump at pos to the next instruction
+func (c *Compiler) patchJump(pos int) {
+	c.patchOperand(pos, 0, len(c.fn.instructions))
+}
+
+func (c *Compiler) patchJumps(positions []int) {
+	for _, pos := range positions {
+		c.patchJump(pos)
+	}
+}
+
+// patchOperand changes the operand n of the instruction at pos
+func (c *Compiler) patchOperand(pos, n, operand int) {
+	ins := c.fn.instructions
+	def, _ := code.Lookup(ins[pos])
+	operands, _ := code.ReadOperands(def, ins[pos+1:])
+	operands[n] = operand
+	copy(ins[pos:], code.Make(code.Opcode(ins[pos]), operands...))
+}
@@ -0,0 +1,164 @@
+package compiler_test
+
+import (
+	"github.com/JasirZaeem/ape/pkg/code"
+	"github.com/JasirZaeem/ape/pkg/compiler"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"testing"
+)
+
+func compile(input string) *compiler.Bytecode {
+	program := parser.New(lexer.New(input)).ParseProgram()
+	return compiler.New().Compile(program)
+}
+
+func concat(instructions ...[]byte) code.Instructions {
+	var out code.Instructions
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func testInstructions(t *testing.T, input string, expected, got code.Instructions) {
+	t.Helper()
+	if got.String() != expected.String() {
+		t.Errorf("wrong instructions for %q.\nexpected =\n%s\ngot =\n%s", input, expected, got)
+	}
+}
+
+func TestCompileMain(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected code.Instructions
+	}{
+		{
+			"1 + 2",
+			concat(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			),
+		},
+		{
+			"let x = 1; x",
+			concat(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpReturnValue),
+			),
+		},
+		{
+			"let x = 1;",
+			concat(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpReturn),
+			),
+		},
+		{
+			"if (true) { 10 }; 3",
+			concat(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			),
+		},
+		{
+			"a && b",
+			concat(
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpAnd, 9),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpReturnValue),
+			),
+		},
+		{
+			"for (x in [1]) { x }",
+			concat(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpIter),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpSetLocal, 1),
+				code.Make(code.OpResetLocals, 2, 1),
+				code.Make(code.OpIterNext, 0, 1, 37),
+				code.Make(code.OpSetLocal, 2),
+				code.Make(code.OpGetLocal, 2),
+				code.Make(code.OpSetLocal, 1),
+				code.Make(code.OpJump, 14),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpReturnValue),
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		testInstructions(t, tt.input, tt.expected, compile(tt.input).Main.Instructions)
+	}
+}
+
+func TestCompileClosures(t *testing.T) {
+	input := "fn(a) { let g = fn() { a }; a }"
+	main := compile(input).Main
+
+	outer, ok := main.Constants[0].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant is not a CompiledFunction. got = %T", main.Constants[0])
+	}
+
+	// a is captured by g so the outer function keeps it in a cell
+	testInstructions(t, input, concat(
+		code.Make(code.OpLoadCell, 0),
+		code.Make(code.OpClosure, 0, 1),
+		code.Make(code.OpSetLocal, 1),
+		code.Make(code.OpGetCell, 0),
+		code.Make(code.OpReturnValue),
+	), outer.Instructions)
+
+	if outer.NumLocals != 2 || outer.NumParameters != 1 || !outer.Cells[0] || outer.Cells[1] {
+		t.Errorf("wrong locals. got NumLocals = %d, NumParameters = %d, Cells = %v",
+			outer.NumLocals, outer.NumParameters, outer.Cells)
+	}
+
+	inner := outer.Constants[0].(*object.CompiledFunction)
+	testInstructions(t, input, concat(
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpReturnValue),
+	), inner.Instructions)
+}
+
+func TestCompileKeepsGlobals(t *testing.T) {
+	c := compiler.New()
+	c.Compile(parser.New(lexer.New("let a = 1; let b = 2")).ParseProgram())
+	bytecode := c.Compile(parser.New(lexer.New("b")).ParseProgram())
+
+	testInstructions(t, "b", concat(
+		code.Make(code.OpGetGlobal, 1),
+		code.Make(code.OpReturnValue),
+	), bytecode.Main.Instructions)
+
+	if len(bytecode.Globals) != 2 || bytecode.Globals[0] != "a" || bytecode.Globals[1] != "b" {
+		t.Errorf("wrong globals. got = %v", bytecode.Globals)
+	}
+}
+
+func TestCompilePositions(t *testing.T) {
+	main := compile("let x = 1;\nx + true").Main
+
+	// The OpAdd at 10 is reported at the whole infix expression
+	position := main.Position(10)
+	if position.Span.Start.Line != 2 || position.Span.Start.Column != 1 || position.Span.End.Column != 9 {
+		t.Errorf("wrong position. got = %+v", position.Span)
+	}
+}
@@ -0,0 +1,67 @@
+package compiler
+
+import (
+	"github.com/JasirZaeem/ape/pkg/ast"
+)
+
+// Like the evaluator's environments only functions, for loop bodies and catch
+// blocks have a scope of their own, other blocks bind names in the enclosing
+// one
+type scope struct {
+	symbols map[string]*symbol
+	fn      *function // the function the scope's locals belong to
+	outer   *scope
+	first   int // slots of the locals declared by the scope
+	count   int
+}
+
+type symbol struct {
+	name  string
+	index int // slot of a local or of a global
+	// A local is only seen by code of its own function after its let, like in
+	// the evaluator where it is not bound before. Functions defined in the
+	// scope see it from the start since they may be called after the let.
+	defined bool
+}
+
+type freeVariable struct {
+	symbol *symbol
+	local  bool // a local of the enclosing function, otherwise one of its free variables
+	index  int
+}
+
+func newScope(fn *function, outer *scope) *scope {
+	return &scope{symbols: map[string]*symbol{}, fn: fn, outer: outer}
+}
+
+// declareLocals gives names their slots in s, names already declared keep theirs
+func (s *scope) declareLocals(names []string, defined bool) {
+	if s.count == 0 {
+		s.first = s.fn.numLocals
+	}
+	for _, name := range names {
+		if _, ok := s.symbols[name]; ok {
+			continue
+		}
+		s.symbols[name] = &symbol{name: name, index: s.fn.allocate(name), defined: defined}
+		s.count++
+	}
+}
+
+// declaredNames lists the names declared in the scope of stmts, see
+// ast.Declarations
+func declaredNames(stmts []ast.Statement) []string {
+	var names []string
+	for _, name := range ast.Declarations(stmts) {
+		names = append(names, name.Value)
+	}
+	return names
+}
+
+// blockNames is declaredNames for the statements of a block that may be nil
+func blockNames(block *ast.BlockStatement) []string {
+	if block == nil {
+		return nil
+	}
+	return declaredNames(block.Statements)
+}
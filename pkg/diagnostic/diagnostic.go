@@ -0,0 +1,132 @@
+package diagnostic
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/token"
+	"sort"
+	"strings"
+)
+
+type Severity int
+
+const (
+	ERROR Severity = iota
+	WARNING
+	INFO
+)
+
+func (s Severity) String() string {
+	switch s {
+	case ERROR:
+		return "error"
+	case WARNING:
+		return "warning"
+	case INFO:
+		return "info"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Code identifies the kind of problem, codes are stable so tools can filter on them
+type Code string
+
+type Diagnostic struct {
+	Severity Severity
+	Span     token.Span
+	Code     Code
+	Message  string
+	Hint     string
+}
+
+// String renders the diagnostic as "line:column: severity[code]: message"
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s[%s]: %s",
+		d.Span.Start.Line, d.Span.Start.Column, d.Severity, d.Code, d.Message)
+}
+
+// Render formats the diagnostic for a terminal, with the file name, the source
+// line it points at and its hint
+func Render(name, source string, d Diagnostic) string {
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "%s:%s\n", name, d)
+	out.WriteString(Snippet(source, d.Span))
+	if d.Hint != "" {
+		fmt.Fprintf(&out, "  hint: %s\n", d.Hint)
+	}
+
+	return out.String()
+}
+
+// Snippet returns the source line span starts on with the span underlined,
+// empty if the line is not in source
+func Snippet(source string, span token.Span) string {
+	lines := strings.Split(source, "\n")
+	if span.Start.Line < 1 || span.Start.Line > len(lines) {
+		return ""
+	}
+
+	// Columns count characters so the line is handled as runes
+	line := []rune(strings.TrimRight(lines[span.Start.Line-1], "\r"))
+	start := span.Start.Column - 1
+	if start < 0 || start > len(line) {
+		return ""
+	}
+
+	width := 1
+	if span.End.Line == span.Start.Line && span.End.Column-span.Start.Column > 1 {
+		width = span.End.Column - span.Start.Column
+	}
+	if start+width > len(line) && start < len(line) {
+		width = len(line) - start
+	}
+
+	// Tabs are kept so the underline lines up with the source
+	indent := make([]rune, start)
+	for i, ch := range line[:start] {
+		indent[i] = ' '
+		if ch == '\t' {
+			indent[i] = '\t'
+		}
+	}
+
+	number := fmt.Sprint(span.Start.Line)
+	gutter := strings.Repeat(" ", len(number))
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, " %s | %s\n", number, string(line))
+	fmt.Fprintf(&out, " %s | %s%s\n", gutter, string(indent), strings.Repeat("^", width))
+
+	return out.String()
+}
+
+// Sort orders diagnostics by where they start in the source, then by code
+func Sort(diagnostics []Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		if a.Span.Start.Offset != b.Span.Start.Offset {
+			return a.Span.Start.Offset < b.Span.Start.Offset
+		}
+		return a.Code < b.Code
+	})
+}
+
+// Filter returns the diagnostics that have one of the given codes
+func Filter(diagnostics []Diagnostic, codes ...Code) []Diagnostic {
+	var filtered []Diagnostic
+	for _, d := range diagnostics {
+		for _, code := range codes {
+			if d.Code == code {
+				filtered = append(filtered, d)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,118 @@
+package diagnostic_test
+
+import (
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
+	"github.com/JasirZaeem/ape/pkg/token"
+	"testing"
+)
+
+func at(offset int, code diagnostic.Code) diagnostic.Diagnostic {
+	return diagnostic.Diagnostic{
+		Code: code,
+		Span: token.Span{Start: token.Position{Offset: offset, Line: 1, Column: offset + 1}},
+	}
+}
+
+func TestSortAndFilter(t *testing.T) {
+	diagnostics := []diagnostic.Diagnostic{at(8, "P002"), at(2, "P001"), at(8, "P001")}
+
+	diagnostic.Sort(diagnostics)
+
+	expected := []diagnostic.Diagnostic{at(2, "P001"), at(8, "P001"), at(8, "P002")}
+	for i, d := range diagnostics {
+		if d != expected[i] {
+			t.Errorf("diagnostics[%d] wrong. expected = %+v, got = %+v", i, expected[i], d)
+		}
+	}
+
+	filtered := diagnostic.Filter(diagnostics, "P002")
+	if len(filtered) != 1 || filtered[0].Code != "P002" {
+		t.Errorf("filter by code wrong. got = %+v", filtered)
+	}
+}
+
+func TestString(t *testing.T) {
+	d := diagnostic.Diagnostic{
+		Severity: diagnostic.ERROR,
+		Span:     token.Span{Start: token.Position{Offset: 12, Line: 2, Column: 3}},
+		Code:     "P002",
+		Message:  "no prefix parser function for * found",
+	}
+
+	if d.String() != "2:3: error[P002]: no prefix parser function for * found" {
+		t.Errorf("d.String() wrong. got = %q", d.String())
+	}
+}
+
+func TestRender(t *testing.T) {
+	source := "let x = 1;\n\tlet y = (x;"
+	d := diagnostic.Diagnostic{
+		Severity: diagnostic.ERROR,
+		Span: token.Span{
+			Start: token.Position{Offset: 21, Line: 2, Column: 11},
+			End:   token.Position{Offset: 22, Line: 2, Column: 12},
+		},
+		Code:    "P001",
+		Message: "expected next token to be ), got ;",
+		Hint:    "check for a missing )",
+	}
+
+	expected := "main.ape:2:11: error[P001]: expected next token to be ), got ;\n" +
+		" 2 | \tlet y = (x;\n" +
+		"   | \t         ^\n" +
+		"  hint: check for a missing )\n"
+
+	if rendered := diagnostic.Render("main.ape", source, d); rendered != expected {
+		t.Errorf("Render wrong. expected = %q, got = %q", expected, rendered)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	source := "let total = price * count;"
+
+	tests := []struct {
+		span     token.Span
+		expected string
+	}{
+		{
+			token.Span{Start: token.Position{Line: 1, Column: 13}, End: token.Position{Line: 1, Column: 26}},
+			" 1 | let total = price * count;\n   |             ^^^^^^^^^^^^^\n",
+		},
+		{
+			token.Span{Start: token.Position{Line: 1, Column: 27}, End: token.Position{Line: 1, Column: 28}},
+			" 1 | let total = price * count;\n   |                           ^\n",
+		},
+		{token.Span{Start: token.Position{Line: 3, Column: 1}}, ""},
+		{token.Span{}, ""},
+	}
+
+	for _, tt := range tests {
+		if snippet := diagnostic.Snippet(source, tt.span); snippet != tt.expected {
+			t.Errorf("Snippet wrong. expected = %q, got = %q", tt.expected, snippet)
+		}
+	}
+}
+
+func TestSnippetUnicode(t *testing.T) {
+	source := "let 名前 = \"日本\" + nope;"
+
+	tests := []struct {
+		span     token.Span
+		expected string
+	}{
+		{
+			token.Span{Start: token.Position{Line: 1, Column: 5}, End: token.Position{Line: 1, Column: 7}},
+			" 1 | let 名前 = \"日本\" + nope;\n   |     ^^\n",
+		},
+		{
+			token.Span{Start: token.Position{Line: 1, Column: 17}, End: token.Position{Line: 1, Column: 21}},
+			" 1 | let 名前 = \"日本\" + nope;\n   |                 ^^^^\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if snippet := diagnostic.Snippet(source, tt.span); snippet != tt.expected {
+			t.Errorf("Snippet wrong. expected = %q, got = %q", tt.expected, snippet)
+		}
+	}
+}
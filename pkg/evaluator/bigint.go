@@ -0,0 +1,98 @@
+package evaluator
+
+import (
+	"github.com/JasirZaeem/ape/pkg/object"
+	"math/big"
+)
+
+// Results of ** and << with more bits than this are refused, they would take
+// too long to compute and too much memory to keep
+const MAX_INTEGER_BITS = 1 << 24
+
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIGINT_OBJ
+}
+
+// evalBigIntInfixExpression evaluates operators on integers of any size, the
+// result is demoted to an Integer when it fits in 64 bits
+func evalBigIntInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal, _ := object.ToBig(left)
+	rightVal, _ := object.ToBig(right)
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		return object.NewInteger(result.Add(leftVal, rightVal))
+	case "-":
+		return object.NewInteger(result.Sub(leftVal, rightVal))
+	case "*":
+		return object.NewInteger(result.Mul(leftVal, rightVal))
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		return object.NewInteger(result.Quo(leftVal, rightVal))
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "modulo by zero")
+		}
+		return object.NewInteger(result.Rem(leftVal, rightVal))
+	case "//":
+		if rightVal.Sign() == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		remainder := new(big.Int)
+		result.QuoRem(leftVal, rightVal, remainder)
+		if remainder.Sign() != 0 && remainder.Sign() != rightVal.Sign() {
+			result.Sub(result, big.NewInt(1))
+		}
+		return object.NewInteger(result)
+	case "**":
+		if rightVal.Sign() < 0 {
+			return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
+		}
+		// Powers of -1, 0 and 1 stay small, the result of others has at least
+		// (bits of left - 1) * right bits. Dividing instead of multiplying
+		// keeps huge powers from overflowing the check.
+		if leftVal.CmpAbs(big.NewInt(1)) > 0 &&
+			(!rightVal.IsInt64() || rightVal.Int64() > MAX_INTEGER_BITS/int64(leftVal.BitLen()-1)) {
+			return newError(object.VALUE_ERROR, "integer too large: %s ** %s", left.Inspect(), rightVal)
+		}
+		return object.NewInteger(result.Exp(leftVal, rightVal, nil))
+	case "<<", ">>":
+		if rightVal.Sign() < 0 {
+			return newError(object.VALUE_ERROR, "negative shift count: %s", rightVal)
+		}
+		if operator == ">>" {
+			if !rightVal.IsUint64() || rightVal.Uint64() > uint64(leftVal.BitLen()) {
+				// Everything is shifted out, leaving the sign
+				return object.NewInteger(result.Rsh(leftVal, uint(leftVal.BitLen())+1))
+			}
+			return object.NewInteger(result.Rsh(leftVal, uint(rightVal.Uint64())))
+		}
+		if !rightVal.IsInt64() || rightVal.Int64() > MAX_INTEGER_BITS {
+			return newError(object.VALUE_ERROR, "integer too large: %s << %s", left.Inspect(), rightVal)
+		}
+		return object.NewInteger(result.Lsh(leftVal, uint(rightVal.Int64())))
+	case "&":
+		return object.NewInteger(result.And(leftVal, rightVal))
+	case "^":
+		return object.NewInteger(result.Xor(leftVal, rightVal))
+	case "|":
+		return object.NewInteger(result.Or(leftVal, rightVal))
+	case "<":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
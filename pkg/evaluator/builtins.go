@@ -1,71 +1,180 @@
 package evaluator
 
 import (
-	"fmt"
 	"github.com/JasirZaeem/ape/pkg/object"
+	"io"
+	"math"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
+// Arrays made from ranges longer than this are refused, they could not be
+// allocated. Only ARRAY_PREALLOCATION elements are allocated up front, longer
+// arrays grow as they are filled.
+const (
+	MAX_ARRAY_LENGTH    = 1 << 32
+	ARRAY_PREALLOCATION = 1 << 16
+)
+
+// BuiltinNames returns the names of the builtin functions in sorted order
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 var builtins = map[string]*object.Builtin{
 	"len": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
 			switch arg := args[0].(type) {
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 			case *object.Array:
 				return &object.Integer{Value: int64(len(arg.Elements))}
 			case *object.Hash:
-				return &object.Integer{Value: int64(len(arg.Pairs))}
+				return &object.Integer{Value: int64(arg.Len())}
+			case *object.Range:
+				return object.NewInteger(new(big.Int).SetUint64(arg.Len()))
 			default:
-				return newError("argument to `len` not supported, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `len` not supported, got %s", args[0].Type())
 			}
 		},
 	},
+	// Output and input, through the IO of the program
 	"print": {
-		Fn: func(args ...object.Object) object.Object {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			var out strings.Builder
 			for _, arg := range args {
-				fmt.Print(arg.Inspect())
+				out.WriteString(arg.Inspect())
+			}
+			out.WriteString("\n")
+			io.WriteString(rt.Streams().Stdout, out.String())
+			return NULL
+		},
+	},
+	"println": {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			parts := make([]string, len(args))
+			for i, arg := range args {
+				parts[i] = arg.Inspect()
+			}
+			io.WriteString(rt.Streams().Stdout, strings.Join(parts, " ")+"\n")
+			return NULL
+		},
+	},
+	"printf": {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1 or more", len(args))
+			}
+			format, ok := args[0].(*object.String)
+			if !ok {
+				return newError(object.TYPE_ERROR, "first argument to `printf` must be STRING, got %s", args[0].Type())
 			}
-			fmt.Println()
+
+			out, err := sprintf(format.Value, args[1:])
+			if err != nil {
+				return err
+			}
+			io.WriteString(rt.Streams().Stdout, out)
 			return NULL
 		},
 	},
+	"input": {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			if len(args) > 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 0 or 1", len(args))
+			}
+
+			prompt := ""
+			if len(args) == 1 {
+				prompt = args[0].Inspect()
+			}
+			return readLine(rt.Streams(), prompt)
+		},
+	},
+	"read_line": {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 0 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 0", len(args))
+			}
+			return readLine(rt.Streams(), "")
+		},
+	},
 	// Type utilities
 	"type": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			return &object.String{Value: string(args[0].Type())}
 		},
 	},
+	"same": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
+			}
+			return nativeBoolToBooleanObject(isSame(args[0], args[1]))
+		},
+	},
 	"is_int": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
-			return nativeBoolToBooleanObject(args[0].Type() == object.INTEGER_OBJ)
+			return nativeBoolToBooleanObject(isInteger(args[0]))
 		},
 	},
 	"is_float": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
 			return nativeBoolToBooleanObject(args[0].Type() == object.FLOAT_OBJ)
 		},
 	},
+	"is_nan": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
+			}
+			if !isNumber(args[0]) {
+				return newError(object.TYPE_ERROR, "argument to `is_nan` must be a number, got %s", args[0].Type())
+			}
+
+			return nativeBoolToBooleanObject(args[0].Type() == object.FLOAT_OBJ && math.IsNaN(args[0].(*object.Float).Value))
+		},
+	},
+	"is_inf": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
+			}
+			if !isNumber(args[0]) {
+				return newError(object.TYPE_ERROR, "argument to `is_inf` must be a number, got %s", args[0].Type())
+			}
+
+			// Big integers too large for a float are still finite
+			return nativeBoolToBooleanObject(args[0].Type() == object.FLOAT_OBJ && math.IsInf(args[0].(*object.Float).Value, 0))
+		},
+	},
 	"is_bool": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
 			return nativeBoolToBooleanObject(args[0].Type() == object.BOOLEAN_OBJ)
@@ -74,7 +183,7 @@ var builtins = map[string]*object.Builtin{
 	"is_string": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
 			return nativeBoolToBooleanObject(args[0].Type() == object.STRING_OBJ)
@@ -83,7 +192,7 @@ var builtins = map[string]*object.Builtin{
 	"is_array": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
 			return nativeBoolToBooleanObject(args[0].Type() == object.ARRAY_OBJ)
@@ -92,7 +201,7 @@ var builtins = map[string]*object.Builtin{
 	"is_hash": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
 			return nativeBoolToBooleanObject(args[0].Type() == object.HASH_OBJ)
@@ -101,16 +210,25 @@ var builtins = map[string]*object.Builtin{
 	"is_null": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
 			return nativeBoolToBooleanObject(args[0] == NULL)
 		},
 	},
+	"is_error": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
+			}
+
+			return nativeBoolToBooleanObject(args[0].Type() == object.ERROR_VALUE_OBJ)
+		},
+	},
 	"is_function": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got %d, want = 1", len(args))
 			}
 
 			return nativeBoolToBooleanObject(args[0].Type() == object.FUNCTION_OBJ)
@@ -120,13 +238,20 @@ var builtins = map[string]*object.Builtin{
 	"int": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			switch arg := args[0].(type) {
-			case *object.Integer:
+			case *object.Integer, *object.BigInt:
 				return arg
 			case *object.Float:
-				return &object.Integer{Value: int64(arg.Value)}
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newError(object.VALUE_ERROR, "could not convert %s to integer", arg.Inspect())
+				}
+				if arg.Value >= math.MinInt64 && arg.Value < math.MaxInt64 {
+					return &object.Integer{Value: int64(arg.Value)}
+				}
+				integer, _ := big.NewFloat(arg.Value).Int(nil)
+				return object.NewInteger(integer)
 			case *object.Boolean:
 				if arg.Value {
 					return &object.Integer{Value: 1}
@@ -135,23 +260,29 @@ var builtins = map[string]*object.Builtin{
 				}
 			case *object.String:
 				integer, err := strconv.ParseInt(arg.Value, 0, 64)
-				if err != nil {
-					return newError("could not convert %q to integer", arg.Value)
+				if err == nil {
+					return &object.Integer{Value: integer}
+				}
+				// Too large for 64 bits
+				if big, ok := new(big.Int).SetString(arg.Value, 0); ok {
+					return object.NewInteger(big)
 				}
-				return &object.Integer{Value: integer}
+				return newError(object.VALUE_ERROR, "could not convert %q to integer", arg.Value)
 			default:
-				return newError("argument to `int` not supported, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `int` not supported, got %s", args[0].Type())
 			}
 		},
 	},
 	"float": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			switch arg := args[0].(type) {
 			case *object.Integer:
 				return &object.Float{Value: float64(arg.Value)}
+			case *object.BigInt:
+				return toFloat(arg)
 			case *object.Float:
 				return arg
 			case *object.Boolean:
@@ -163,45 +294,71 @@ var builtins = map[string]*object.Builtin{
 			case *object.String:
 				float, err := strconv.ParseFloat(arg.Value, 64)
 				if err != nil {
-					return newError("could not convert %q to float", arg.Value)
+					return newError(object.VALUE_ERROR, "could not convert %q to float", arg.Value)
 				}
 				return &object.Float{Value: float}
 			default:
-				return newError("argument to `float` not supported, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `float` not supported, got %s", args[0].Type())
 			}
 		},
 	},
 	"string": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			return &object.String{Value: args[0].Inspect()}
 		},
 	},
 	"array": {
-		Fn: func(args ...object.Object) object.Object {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			switch arg := args[0].(type) {
 			case *object.Array:
 				return arg
 			case *object.String:
-				elements := make([]object.Object, len(arg.Value))
-				for i, ch := range arg.Value {
+				if err := reserve(rt, 24+16*int64(utf8.RuneCountInString(arg.Value))); err != nil {
+					return err
+				}
+				runes := []rune(arg.Value)
+				elements := make([]object.Object, len(runes))
+				for i, ch := range runes {
 					elements[i] = &object.String{Value: string(ch)}
 				}
 				return &object.Array{Elements: elements}
+			case *object.Range:
+				length := arg.Len()
+				if length > MAX_ARRAY_LENGTH {
+					return newError(object.VALUE_ERROR, "range too long for an array: %d integers", length)
+				}
+				if err := reserve(rt, 24+16*int64(length)); err != nil {
+					return err
+				}
+				capacity := length
+				if capacity > ARRAY_PREALLOCATION {
+					capacity = ARRAY_PREALLOCATION
+				}
+				elements := make([]object.Object, 0, capacity)
+				for i := uint64(0); i < length; i++ {
+					if i%contextCheckInterval == 0 {
+						if err := interrupted(rt); err != nil {
+							return err
+						}
+					}
+					elements = append(elements, &object.Integer{Value: arg.At(i)})
+				}
+				return &object.Array{Elements: elements}
 			default:
-				return newError("argument to `array` not supported, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `array` not supported, got %s", args[0].Type())
 			}
 		},
 	},
 	"bool": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 
 			return nativeBoolToBooleanObject(isTruthy(args[0]))
@@ -211,7 +368,7 @@ var builtins = map[string]*object.Builtin{
 	"first": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrFirst(args[0].(*object.Array))
@@ -219,13 +376,13 @@ var builtins = map[string]*object.Builtin{
 				return strFirst(args[0].(*object.String))
 			}
 
-			return newError("argument to `first` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `first` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"last": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrLast(args[0].(*object.Array))
@@ -233,13 +390,13 @@ var builtins = map[string]*object.Builtin{
 				return strLast(args[0].(*object.String))
 			}
 
-			return newError("argument to `last` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `last` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"rest": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrRest(args[0].(*object.Array))
@@ -247,13 +404,13 @@ var builtins = map[string]*object.Builtin{
 				return strRest(args[0].(*object.String))
 			}
 
-			return newError("argument to `rest` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `rest` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"init": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrInit(args[0].(*object.Array))
@@ -261,53 +418,53 @@ var builtins = map[string]*object.Builtin{
 				return strInit(args[0].(*object.String))
 			}
 
-			return newError("argument to `init` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `init` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"at": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				if args[1].Type() != object.INTEGER_OBJ {
-					return newError("index to `at` must be INTEGER, got %s", args[1].Type())
+					return newError(object.TYPE_ERROR, "index to `at` must be INTEGER, got %s", args[1].Type())
 				}
 				return arrAt(args[0].(*object.Array), args[1].(*object.Integer).Value)
 			} else if args[0].Type() == object.STRING_OBJ {
 				if args[1].Type() != object.INTEGER_OBJ {
-					return newError("index to `at` must be INTEGER, got %s", args[1].Type())
+					return newError(object.TYPE_ERROR, "index to `at` must be INTEGER, got %s", args[1].Type())
 				}
 				return strAt(args[0].(*object.String), args[1].(*object.Integer).Value)
 			}
 
-			return newError("argument to `at` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `at` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"set_at": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 3 {
-				return newError("wrong number of arguments. got = %d, want = 3", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 3", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				if args[1].Type() != object.INTEGER_OBJ {
-					return newError("index to `set_at` must be INTEGER, got %s", args[1].Type())
+					return newError(object.TYPE_ERROR, "index to `set_at` must be INTEGER, got %s", args[1].Type())
 				}
 				return arrSetAt(args[0].(*object.Array), args[1].(*object.Integer).Value, args[2])
 			} else if args[0].Type() == object.STRING_OBJ {
 				if args[1].Type() != object.INTEGER_OBJ {
-					return newError("index to `set_at` must be INTEGER, got %s", args[1].Type())
+					return newError(object.TYPE_ERROR, "index to `set_at` must be INTEGER, got %s", args[1].Type())
 				}
 				return strSetAt(args[0].(*object.String), args[1].(*object.Integer).Value, args[2])
 			}
 
-			return newError("argument to `set_at` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `set_at` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"push": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrPush(args[0].(*object.Array), args[1])
@@ -315,13 +472,13 @@ var builtins = map[string]*object.Builtin{
 				return strPush(args[0].(*object.String), args[1])
 			}
 
-			return newError("argument to `push` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `push` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"pop": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrPop(args[0].(*object.Array))
@@ -329,13 +486,13 @@ var builtins = map[string]*object.Builtin{
 				return strPop(args[0].(*object.String))
 			}
 
-			return newError("argument to `pop` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `pop` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"push_front": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrPushFront(args[0].(*object.Array), args[1])
@@ -343,13 +500,13 @@ var builtins = map[string]*object.Builtin{
 				return strPushFront(args[0].(*object.String), args[1])
 			}
 
-			return newError("argument to `push_front` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `push_front` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"pop_front": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrPopFront(args[0].(*object.Array))
@@ -357,53 +514,53 @@ var builtins = map[string]*object.Builtin{
 				return strPopFront(args[0].(*object.String))
 			}
 
-			return newError("argument to `pop_front` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `pop_front` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"insert": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 3 {
-				return newError("wrong number of arguments. got = %d, want = 3", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 3", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				if args[1].Type() != object.INTEGER_OBJ {
-					return newError("index to `insert` must be INTEGER, got %s", args[1].Type())
+					return newError(object.TYPE_ERROR, "index to `insert` must be INTEGER, got %s", args[1].Type())
 				}
 				return arrInsert(args[0].(*object.Array), args[1].(*object.Integer).Value, args[2])
 			} else if args[0].Type() == object.STRING_OBJ {
 				if args[1].Type() != object.INTEGER_OBJ {
-					return newError("index to `insert` must be INTEGER, got %s", args[1].Type())
+					return newError(object.TYPE_ERROR, "index to `insert` must be INTEGER, got %s", args[1].Type())
 				}
 				return strInsert(args[0].(*object.String), args[1].(*object.Integer).Value, args[2])
 			}
 
-			return newError("argument to `insert` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `insert` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"remove": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				if args[1].Type() != object.INTEGER_OBJ {
-					return newError("index to `remove` must be INTEGER, got %s", args[1].Type())
+					return newError(object.TYPE_ERROR, "index to `remove` must be INTEGER, got %s", args[1].Type())
 				}
 				return arrRemove(args[0].(*object.Array), args[1].(*object.Integer).Value)
 			} else if args[0].Type() == object.STRING_OBJ {
 				if args[1].Type() != object.INTEGER_OBJ {
-					return newError("index to `remove` must be INTEGER, got %s", args[1].Type())
+					return newError(object.TYPE_ERROR, "index to `remove` must be INTEGER, got %s", args[1].Type())
 				}
 				return strRemove(args[0].(*object.String), args[1].(*object.Integer).Value)
 			}
 
-			return newError("argument to `remove` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `remove` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	"reverse": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() == object.ARRAY_OBJ {
 				return arrReverse(args[0].(*object.Array))
@@ -411,21 +568,21 @@ var builtins = map[string]*object.Builtin{
 				return strReverse(args[0].(*object.String))
 			}
 
-			return newError("argument to `reverse` must be ARRAY or STRING, got %s", args[0].Type())
+			return newError(object.TYPE_ERROR, "argument to `reverse` must be ARRAY or STRING, got %s", args[0].Type())
 		},
 	},
 	// Hash functions
 	"keys": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() != object.HASH_OBJ {
-				return newError("argument to `keys` must be HASH, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `keys` must be HASH, got %s", args[0].Type())
 			}
 			hash := args[0].(*object.Hash)
-			keys := make([]object.Object, 0, len(hash.Pairs))
-			for _, pair := range hash.Pairs {
+			keys := make([]object.Object, 0, hash.Len())
+			for _, pair := range hash.OrderedPairs() {
 				keys = append(keys, object.DeepCopy(pair.Key))
 			}
 			return &object.Array{Elements: keys}
@@ -434,14 +591,14 @@ var builtins = map[string]*object.Builtin{
 	"values": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() != object.HASH_OBJ {
-				return newError("argument to `values` must be HASH, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `values` must be HASH, got %s", args[0].Type())
 			}
 			hash := args[0].(*object.Hash)
-			values := make([]object.Object, 0, len(hash.Pairs))
-			for _, pair := range hash.Pairs {
+			values := make([]object.Object, 0, hash.Len())
+			for _, pair := range hash.OrderedPairs() {
 				values = append(values, object.DeepCopy(pair.Value))
 			}
 			return &object.Array{Elements: values}
@@ -450,14 +607,14 @@ var builtins = map[string]*object.Builtin{
 	"entries": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() != object.HASH_OBJ {
-				return newError("argument to `entries` must be HASH, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `entries` must be HASH, got %s", args[0].Type())
 			}
 			hash := args[0].(*object.Hash)
-			entries := make([]object.Object, 0, len(hash.Pairs))
-			for _, pair := range hash.Pairs {
+			entries := make([]object.Object, 0, hash.Len())
+			for _, pair := range hash.OrderedPairs() {
 				entries = append(entries, &object.Array{Elements: []object.Object{object.DeepCopy(pair.Key), object.DeepCopy(pair.Value)}})
 			}
 			return &object.Array{Elements: entries}
@@ -466,56 +623,56 @@ var builtins = map[string]*object.Builtin{
 	"has_key": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() != object.HASH_OBJ {
-				return newError("first argument to `has_key` must be HASH, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "first argument to `has_key` must be HASH, got %s", args[0].Type())
 			}
 			hashKey, ok := args[1].(object.Hashable)
 			if !ok {
-				return newError("second argument to `has_key` must be HASHABLE, got %s", args[1].Type())
+				return newError(object.TYPE_ERROR, "second argument to `has_key` must be HASHABLE, got %s", args[1].Type())
 			}
 
 			hash := args[0].(*object.Hash)
-			_, ok = hash.Pairs[hashKey.HashKey()]
+			_, ok = hash.Get(hashKey)
 			return nativeBoolToBooleanObject(ok)
 		},
 	},
 	"set": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 3 {
-				return newError("wrong number of arguments. got = %d, want = 3", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 3", len(args))
 			}
 			if args[0].Type() != object.HASH_OBJ {
-				return newError("first argument to `set` must be HASH, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "first argument to `set` must be HASH, got %s", args[0].Type())
 			}
 			hashKey, ok := args[1].(object.Hashable)
 			if !ok {
-				return newError("second argument to `set` must be HASHABLE, got %s", args[1].Type())
+				return newError(object.TYPE_ERROR, "second argument to `set` must be HASHABLE, got %s", args[1].Type())
 			}
 
 			hash := args[0].(*object.Hash)
 			retHash := object.DeepCopyHash(hash)
-			retHash.Pairs[hashKey.HashKey()] = object.HashPair{Key: args[1], Value: args[2]}
+			retHash.Set(hashKey, args[2])
 			return retHash
 		},
 	},
 	"delete": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() != object.HASH_OBJ {
-				return newError("first argument to `delete` must be HASH, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "first argument to `delete` must be HASH, got %s", args[0].Type())
 			}
 			hashKey, ok := args[1].(object.Hashable)
 			if !ok {
-				return newError("second argument to `delete` must be HASHABLE, got %s", args[1].Type())
+				return newError(object.TYPE_ERROR, "second argument to `delete` must be HASHABLE, got %s", args[1].Type())
 			}
 
 			hash := args[0].(*object.Hash)
 			retHash := object.DeepCopyHash(hash)
-			delete(retHash.Pairs, hashKey.HashKey())
+			retHash.Delete(hashKey)
 			return retHash
 		},
 	},
@@ -523,54 +680,112 @@ var builtins = map[string]*object.Builtin{
 	"char": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() != object.INTEGER_OBJ {
-				return newError("argument to `char` must be INTEGER, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `char` must be INTEGER, got %s", args[0].Type())
 			}
 
 			integer := args[0].(*object.Integer).Value
 
-			if integer < 0 || integer > 255 {
-				return newError("argument to `char` must be between 0 and 255, got %d", integer)
+			if integer > utf8.MaxRune || !utf8.ValidRune(rune(integer)) {
+				return newError(object.VALUE_ERROR, "argument to `char` must be a valid code point, got %d", integer)
 			}
 
-			return &object.String{Value: string([]byte{byte(integer)})}
+			return &object.String{Value: string(rune(integer))}
 		},
 	},
 	"ascii": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got = %d, want = 1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
 			}
 			if args[0].Type() != object.STRING_OBJ {
-				return newError("argument to `ascii` must be STRING, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `ascii` must be STRING, got %s", args[0].Type())
 			}
 
-			str := args[0].(*object.String).Value
+			str := []rune(args[0].(*object.String).Value)
 
 			if len(str) != 1 {
-				return newError("argument to `ascii` must be a single character, got %s", str)
+				return newError(object.VALUE_ERROR, "argument to `ascii` must be a single character, got %s", string(str))
 			}
 
 			return &object.Integer{Value: int64(str[0])}
 		},
 	},
-	"split": {
+	"bytes": {
 		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
+			}
+			if args[0].Type() != object.STRING_OBJ {
+				return newError(object.TYPE_ERROR, "argument to `bytes` must be STRING, got %s", args[0].Type())
+			}
+
+			str := args[0].(*object.String).Value
+			elements := make([]object.Object, len(str))
+			for i := 0; i < len(str); i++ {
+				elements[i] = &object.Integer{Value: int64(str[i])}
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+	"from_bytes": {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1", len(args))
+			}
+			if args[0].Type() != object.ARRAY_OBJ {
+				return newError(object.TYPE_ERROR, "argument to `from_bytes` must be ARRAY, got %s", args[0].Type())
+			}
+
+			elements := args[0].(*object.Array).Elements
+			if err := reserve(rt, 16+int64(len(elements))); err != nil {
+				return err
+			}
+			str := make([]byte, len(elements))
+			for i, element := range elements {
+				if i%contextCheckInterval == 0 {
+					if err := interrupted(rt); err != nil {
+						return err
+					}
+				}
+				integer, ok := element.(*object.Integer)
+				if !ok {
+					return newError(object.TYPE_ERROR, "elements of `from_bytes` must be INTEGER between 0 and 255, got %s", element.Inspect())
+				}
+				if integer.Value < 0 || integer.Value > 255 {
+					return newError(object.VALUE_ERROR, "elements of `from_bytes` must be INTEGER between 0 and 255, got %s", element.Inspect())
+				}
+				str[i] = byte(integer.Value)
+			}
+			return &object.String{Value: string(str)}
+		},
+	},
+	"split": {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() != object.STRING_OBJ {
-				return newError("first argument to `split` must be STRING, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "first argument to `split` must be STRING, got %s", args[0].Type())
 			}
 			if args[1].Type() != object.STRING_OBJ {
-				return newError("second argument to `split` must be STRING, got %s", args[1].Type())
+				return newError(object.TYPE_ERROR, "second argument to `split` must be STRING, got %s", args[1].Type())
 			}
 
 			str := args[0].(*object.String).Value
 			sep := args[1].(*object.String).Value
 
+			// An empty separator splits after every character
+			count := utf8.RuneCountInString(str)
+			if sep != "" {
+				count = strings.Count(str, sep) + 1
+			}
+			if err := reserve(rt, 24+16*int64(count)); err != nil {
+				return err
+			}
+
 			var parts []object.Object
 			for _, part := range strings.Split(str, sep) {
 				parts = append(parts, &object.String{Value: part})
@@ -581,13 +796,13 @@ var builtins = map[string]*object.Builtin{
 	"split_once": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() != object.STRING_OBJ {
-				return newError("first argument to `split_once` must be STRING, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "first argument to `split_once` must be STRING, got %s", args[0].Type())
 			}
 			if args[1].Type() != object.STRING_OBJ {
-				return newError("second argument to `split_once` must be STRING, got %s", args[1].Type())
+				return newError(object.TYPE_ERROR, "second argument to `split_once` must be STRING, got %s", args[1].Type())
 			}
 
 			str := args[0].(*object.String).Value
@@ -602,30 +817,63 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"join": {
-		Fn: func(args ...object.Object) object.Object {
+		RuntimeFn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got = %d, want = 2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 2", len(args))
 			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("first argument to `join` must be ARRAY, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "first argument to `join` must be ARRAY, got %s", args[0].Type())
 			}
 			if args[1].Type() != object.STRING_OBJ {
-				return newError("second argument to `join` must be STRING, got %s", args[1].Type())
+				return newError(object.TYPE_ERROR, "second argument to `join` must be STRING, got %s", args[1].Type())
 			}
 
 			arr := args[0].(*object.Array)
 			sep := args[1].(*object.String).Value
 
 			var parts []string
+			size := int64(16)
 			for _, part := range arr.Elements {
 				if part.Type() != object.STRING_OBJ {
-					return newError("elements of array passed to `join` must be STRING, got %s", part.Type())
+					return newError(object.TYPE_ERROR, "elements of array passed to `join` must be STRING, got %s", part.Type())
 				}
 				parts = append(parts, part.(*object.String).Value)
+				size += int64(len(sep) + len(part.(*object.String).Value))
+			}
+			if err := reserve(rt, size); err != nil {
+				return err
 			}
 			return &object.String{Value: strings.Join(parts, sep)}
 		},
 	},
+	// Range functions
+	"range": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = 1, 2 or 3", len(args))
+			}
+
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				if arg.Type() != object.INTEGER_OBJ {
+					return newError(object.TYPE_ERROR, "argument to `range` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = arg.(*object.Integer).Value
+			}
+
+			switch len(bounds) {
+			case 1:
+				return &object.Range{Start: 0, Stop: bounds[0], Step: 1}
+			case 2:
+				return &object.Range{Start: bounds[0], Stop: bounds[1], Step: 1}
+			default:
+				if bounds[2] == 0 {
+					return newError(object.VALUE_ERROR, "step of `range` cannot be zero")
+				}
+				return &object.Range{Start: bounds[0], Stop: bounds[1], Step: bounds[2]}
+			}
+		},
+	},
 }
 
 // Array function implementations
@@ -684,7 +932,7 @@ func arrSetAt(arr *object.Array, index int64, val object.Object) object.Object {
 	}
 
 	if index < 0 || index > int64(length-1) {
-		return newError("index out of range: %d", index)
+		return newError(object.INDEX_ERROR, "index out of range: %d", index)
 	}
 
 	retArr := &object.Array{Elements: make([]object.Object, length, length)}
@@ -737,7 +985,7 @@ func arrInsert(arr *object.Array, index int64, val object.Object) object.Object
 	}
 
 	if index < 0 || index > int64(length) {
-		return newError("index out of range: %d", index)
+		return newError(object.INDEX_ERROR, "index out of range: %d", index)
 	}
 
 	newElements := make([]object.Object, length+1, length+1)
@@ -755,7 +1003,7 @@ func arrRemove(arr *object.Array, index int64) object.Object {
 	}
 
 	if index < 0 || index > int64(length-1) {
-		return newError("index out of range: %d", index)
+		return newError(object.INDEX_ERROR, "index out of range: %d", index)
 	}
 
 	newElements := make([]object.Object, length-1, length-1)
@@ -773,141 +1021,153 @@ func arrReverse(arr *object.Array) object.Object {
 	return &object.Array{Elements: newElements}
 }
 
-// String function implementations
+// String function implementations, strings are indexed by character (rune)
+// rather than byte
 func strFirst(str *object.String) object.Object {
-	if len(str.Value) > 0 {
-		return &object.String{Value: string(str.Value[0])}
+	runes := []rune(str.Value)
+	if len(runes) > 0 {
+		return &object.String{Value: string(runes[0])}
 	}
 	return NULL
 }
 
 func strLast(str *object.String) object.Object {
-	length := len(str.Value)
+	runes := []rune(str.Value)
+	length := len(runes)
 	if length > 0 {
-		return &object.String{Value: string(str.Value[length-1])}
+		return &object.String{Value: string(runes[length-1])}
 	}
 	return NULL
 }
 
 func strRest(str *object.String) object.Object {
-	length := len(str.Value)
+	runes := []rune(str.Value)
+	length := len(runes)
 	if length > 0 {
-		return &object.String{Value: string(str.Value[1:length])}
+		return &object.String{Value: string(runes[1:length])}
 	}
 	return NULL
 }
 
 func strInit(str *object.String) object.Object {
-	length := len(str.Value)
+	runes := []rune(str.Value)
+	length := len(runes)
 	if length > 0 {
-		return &object.String{Value: string(str.Value[0 : length-1])}
+		return &object.String{Value: string(runes[0 : length-1])}
 	}
 	return NULL
 }
 
 func strAt(str *object.String, index int64) object.Object {
+	runes := []rune(str.Value)
 	if index < 0 {
-		index = int64(len(str.Value)) + index
+		index = int64(len(runes)) + index
 	}
 
-	length := len(str.Value)
+	length := len(runes)
 	if index < 0 || index > int64(length-1) {
 		return NULL
 	}
-	return &object.String{Value: string(str.Value[index])}
+	return &object.String{Value: string(runes[index])}
 }
 
 func strSetAt(str *object.String, index int64, val object.Object) object.Object {
 	if val.Type() != object.STRING_OBJ {
-		return newError("argument to `set_at` must be STRING, got %s", val.Type())
+		return newError(object.TYPE_ERROR, "argument to `set_at` must be STRING, got %s", val.Type())
 	}
-	if len(val.(*object.String).Value) != 1 {
-		return newError("argument to `set_at` must be single character, got %d characters", len(val.(*object.String).Value))
+	char := []rune(val.(*object.String).Value)
+	if len(char) != 1 {
+		return newError(object.VALUE_ERROR, "argument to `set_at` must be single character, got %d characters", len(char))
 	}
 
-	length := len(str.Value)
+	runes := []rune(str.Value)
+	length := len(runes)
 	if index < 0 {
 		index = int64(length) + index
 	}
 
 	if index < 0 || index > int64(length-1) {
-		return newError("index out of range: %d", index)
+		return newError(object.INDEX_ERROR, "index out of range: %d", index)
 	}
 
-	s := []byte(str.Value)
-	s[index] = val.(*object.String).Value[0]
-	return &object.String{Value: string(s)}
+	runes[index] = char[0]
+	return &object.String{Value: string(runes)}
 }
 
 func strPush(str *object.String, val object.Object) object.Object {
 	if val.Type() != object.STRING_OBJ {
-		return newError("argument to `push` must be STRING, got %s", val.Type())
+		return newError(object.TYPE_ERROR, "argument to `push` must be STRING, got %s", val.Type())
 	}
 
 	return &object.String{Value: str.Value + val.(*object.String).Value}
 }
 
 func strPop(str *object.String) object.Object {
-	length := len(str.Value)
+	runes := []rune(str.Value)
+	length := len(runes)
 	if length > 0 {
-		return &object.String{Value: str.Value[:length-1]}
+		return &object.String{Value: string(runes[:length-1])}
 	}
 	return NULL
 }
 
 func strPushFront(str *object.String, val object.Object) object.Object {
 	if val.Type() != object.STRING_OBJ {
-		return newError("argument to `push_front` must be STRING, got %s", val.Type())
+		return newError(object.TYPE_ERROR, "argument to `push_front` must be STRING, got %s", val.Type())
 	}
 
 	return &object.String{Value: val.(*object.String).Value + str.Value}
 }
 
 func strPopFront(str *object.String) object.Object {
-	length := len(str.Value)
+	runes := []rune(str.Value)
+	length := len(runes)
 	if length > 0 {
-		return &object.String{Value: str.Value[1:length]}
+		return &object.String{Value: string(runes[1:length])}
 	}
 	return NULL
 }
 
 func strInsert(str *object.String, index int64, val object.Object) object.Object {
 	if val.Type() != object.STRING_OBJ {
-		return newError("argument to `insert` must be STRING, got %s", val.Type())
+		return newError(object.TYPE_ERROR, "argument to `insert` must be STRING, got %s", val.Type())
 	}
 
-	length := len(str.Value)
+	runes := []rune(str.Value)
+	length := len(runes)
 
 	if index < 0 {
 		index = int64(length) + index
 	}
 
 	if index < 0 || index > int64(length) {
-		return newError("index out of range: %d", index)
+		return newError(object.INDEX_ERROR, "index out of range: %d", index)
 	}
 
-	return &object.String{Value: str.Value[:index] + val.(*object.String).Value + str.Value[index:]}
+	return &object.String{Value: string(runes[:index]) + val.(*object.String).Value + string(runes[index:])}
 }
 
 func strRemove(str *object.String, index int64) object.Object {
-	length := len(str.Value)
+	runes := []rune(str.Value)
+	length := len(runes)
 
 	if index < 0 {
 		index = int64(length) + index
 	}
 
 	if index < 0 || index > int64(length-1) {
-		return newError("index out of range: %d", index)
+		return newError(object.INDEX_ERROR, "index out of range: %d", index)
 	}
 
-	return &object.String{Value: str.Value[:index] + str.Value[index+1:]}
+	return &object.String{Value: string(runes[:index]) + string(runes[index+1:])}
 }
 
 func strReverse(str *object.String) object.Object {
-	length := len(str.Value)
-	newStr := make([]byte, length, length)
+	runes := []rune(str.Value)
+	length := len(runes)
+	newStr := make([]rune, length, length)
 	for i, j := 0, length-1; i < length; i, j = i+1, j-1 {
-		newStr[i] = str.Value[j]
+		newStr[i] = runes[j]
 	}
 	return &object.String{Value: string(newStr)}
 }
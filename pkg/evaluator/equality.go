@@ -0,0 +1,100 @@
+package evaluator
+
+import (
+	"github.com/JasirZaeem/ape/pkg/object"
+	"math/big"
+)
+
+// isSame reports whether left and right are the same object, values of
+// immutable scalar types are the same when they are equal
+func isSame(left, right object.Object) bool {
+	if left.Type() != right.Type() {
+		return false
+	}
+
+	switch left := left.(type) {
+	case *object.Integer:
+		return left.Value == right.(*object.Integer).Value
+	case *object.BigInt:
+		return left.Value.Cmp(right.(*object.BigInt).Value) == 0
+	case *object.Float:
+		return left.Value == right.(*object.Float).Value
+	case *object.String:
+		return left.Value == right.(*object.String).Value
+	case *object.Boolean:
+		return left.Value == right.(*object.Boolean).Value
+	}
+
+	return left == right
+}
+
+// evalMixedNumberInfixExpression evaluates operators on an integer and a
+// float, the integer is converted to a float except for comparisons, which
+// are exact
+func evalMixedNumberInfixExpression(operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "+", "-", "*", "/", "%", "//", "**":
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
+	case "<", "<=", ">", ">=", "==", "!=":
+		return evalMixedNumberComparison(operator, left, right)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// toFloat converts a number to a Float, big integers out of the range of
+// floats become infinities
+func toFloat(number object.Object) *object.Float {
+	switch number := number.(type) {
+	case *object.Integer:
+		return &object.Float{Value: float64(number.Value)}
+	case *object.BigInt:
+		value, _ := new(big.Float).SetInt(number.Value).Float64()
+		return &object.Float{Value: value}
+	default:
+		return number.(*object.Float)
+	}
+}
+
+// evalMixedNumberComparison compares an integer with a float
+func evalMixedNumberComparison(operator string, left, right object.Object) object.Object {
+	var cmp int
+	if f, ok := right.(*object.Float); ok {
+		cmp = compareWithFloat(left, f.Value)
+	} else {
+		cmp = compareWithFloat(right, left.(*object.Float).Value)
+		if cmp != 2 {
+			cmp = -cmp
+		}
+	}
+
+	// A NaN is unordered, only != holds
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(cmp == -1)
+	case ">":
+		return nativeBoolToBooleanObject(cmp == 1)
+	case "<=":
+		return nativeBoolToBooleanObject(cmp == -1 || cmp == 0)
+	case ">=":
+		return nativeBoolToBooleanObject(cmp == 1 || cmp == 0)
+	case "==":
+		return nativeBoolToBooleanObject(cmp == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(cmp != 0)
+	default:
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// compareWithFloat compares an Integer or BigInt with f
+func compareWithFloat(integer object.Object, f float64) int {
+	if i, ok := integer.(*object.Integer); ok {
+		return object.CompareIntegerFloat(i.Value, f)
+	}
+	return object.CompareBigFloat(integer.(*object.BigInt).Value, f)
+}
+
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
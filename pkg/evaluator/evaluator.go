@@ -3,23 +3,70 @@ package evaluator
 import (
 	"fmt"
 	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
 	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/resolver"
 	"math"
+	"math/big"
 )
 
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	var result object.Object
+	if rt := env.Runtime(); rt != nil {
+		if err := step(rt); err != nil {
+			result = err
+		}
+	}
+	if result == nil {
+		result = evalNode(node, env)
+	}
+
+	// Errors are tagged with the innermost node that produced them
+	if err, ok := result.(*object.Error); ok && err.Span.Start.Line == 0 {
+		err.Span = node.Span()
+		if module := env.Module(); module != nil {
+			err.Module = module.Name
+		}
+	}
+
+	return result
+}
+
+// Resolve runs the resolver on program before it is evaluated in env, the
+// builtins and the variables bound in env and its enclosing environments are
+// known to it. Eval resolves programs that were not resolved yet, hosts call
+// Resolve first to report what it finds.
+func Resolve(program *ast.Program, env *object.Environment) []diagnostic.Diagnostic {
+	globals := BuiltinNames()
+	for ; env != nil; env = env.Outer() {
+		globals = append(globals, env.Names()...)
+	}
+	return resolver.Resolve(program, globals)
+}
+
+func evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
+		// Programs hosts did not resolve first are resolved once here
+		if !node.Resolved {
+			resolver.Resolve(node, nil)
+		}
 		return evalProgram(node.Statements, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
 	case *ast.FloatLiteral:
 		return &object.Float{Value: node.Value}
@@ -39,13 +86,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			}
 			name, ok := node.Left.(*ast.Identifier)
 			if !ok {
-				return newError("invalid assignment target")
+				return newError(object.SYNTAX_ERROR, "invalid assignment target")
 			}
-			val, ok := env.SetIfNameExists(name.Value, right)
-			if !ok {
-				return newError("assignment target not found: %s", name.Value)
+			if !assign(name, right, env) {
+				return newError(object.NAME_ERROR, "assignment target not found: %s", name.Value)
 			}
-			return val
+			return right
 		}
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -69,13 +115,31 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 
-		return evalInfixOperatorExpression(node.Operator, left, right)
+		rt := env.Runtime()
+		if err := reserveInfix(rt, node.Operator, left, right); err != nil {
+			return err
+		}
+		return allocate(rt, evalInfixOperatorExpression(node.Operator, left, right))
 	case *ast.BlockStatement:
 		return evalBlockStatement(node.Statements, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.WhileExpression:
 		return evalWhileExpression(node, env)
+	case *ast.ForExpression:
+		return evalForExpression(node, env)
+	case *ast.ThrowStatement:
+		return evalThrowStatement(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.ImportStatement:
+		return evalImportStatement(node, env)
+	case *ast.ExportStatement:
+		return evalExportStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
@@ -87,35 +151,27 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		bind(node.Name, val, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		return &object.Function{
+			Name:       node.Name,
 			Parameters: node.Parameters,
 			Body:       node.Body,
+			Scope:      node.Scope,
 			Env:        env,
 		}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
-		}
-
-		return applyFunction(function, args)
+		return evalCallExpression(node, env)
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return allocate(env.Runtime(), &object.String{Value: node.Value})
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(env.Runtime(), &object.Array{Elements: elements})
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -127,7 +183,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return allocate(env.Runtime(), evalHashLiteral(node, env))
+	case *ast.BadStatement:
+		return newError(object.SYNTAX_ERROR, "invalid syntax: %s", node.Source)
+	case *ast.BadExpression:
+		return newError(object.SYNTAX_ERROR, "invalid syntax: %s", node.Source)
 	}
 
 	return nil
@@ -163,7 +223,7 @@ func evalBlockStatement(stmts []ast.Statement, env *object.Environment) object.O
 	for _, stmt := range stmts {
 		result = Eval(stmt, env)
 
-		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
+		if isInterrupt(result) {
 			return result
 		}
 	}
@@ -171,6 +231,20 @@ func evalBlockStatement(stmts []ast.Statement, env *object.Environment) object.O
 	return result
 }
 
+// isInterrupt reports whether obj stops the evaluation of the enclosing block
+func isInterrupt(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+
+	switch obj.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	default:
+		return false
+	}
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -189,7 +263,7 @@ func evalPrefixOperatorExpression(operator string, right object.Object) object.O
 	case "~":
 		return evalBitwiseNotOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -200,96 +274,137 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 func evalMinusOperatorExpression(right object.Object) object.Object {
 	if right.Type() == object.INTEGER_OBJ {
 		value := right.(*object.Integer).Value
+		if value == math.MinInt64 {
+			return object.NewInteger(new(big.Int).Neg(big.NewInt(value)))
+		}
 		return &object.Integer{Value: -value}
+	} else if right.Type() == object.BIGINT_OBJ {
+		value := right.(*object.BigInt).Value
+		return object.NewInteger(new(big.Int).Neg(value))
 	} else if right.Type() == object.FLOAT_OBJ {
 		value := right.(*object.Float).Value
 		return &object.Float{Value: -value}
 	}
-	return newError("unknown operator: -%s", right.Type())
+	return newError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
 }
 
 func evalPlusOperatorExpression(right object.Object) object.Object {
-	if right.Type() == object.INTEGER_OBJ {
+	if right.Type() == object.INTEGER_OBJ || right.Type() == object.BIGINT_OBJ {
 		return right
 	} else if right.Type() == object.FLOAT_OBJ {
 		return right
 	}
-	return newError("unknown operator: +%s", right.Type())
+	return newError(object.TYPE_ERROR, "unknown operator: +%s", right.Type())
 }
 
 func evalBitwiseNotOperatorExpression(right object.Object) object.Object {
 	if right.Type() == object.INTEGER_OBJ {
 		value := right.(*object.Integer).Value
 		return &object.Integer{Value: ^value}
+	} else if right.Type() == object.BIGINT_OBJ {
+		value := right.(*object.BigInt).Value
+		return object.NewInteger(new(big.Int).Not(value))
 	}
-	return newError("unknown operator: ~%s", right.Type())
+	return newError(object.TYPE_ERROR, "unknown operator: ~%s", right.Type())
 }
 
 func evalInfixOperatorExpression(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isInteger(left) && isInteger(right):
+		return evalBigIntInfixExpression(operator, left, right)
 	case left.Type() == object.FLOAT_OBJ && right.Type() == object.FLOAT_OBJ:
 		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		return evalBooleanInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalMixedNumberInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func powInt(x, y int64) int64 {
-	if y == 0 {
-		return 1
-	}
-	if y == 1 {
-		return x
-	}
-	if y&1 == 0 {
-		return powInt(x*x, y/2)
-	}
-	return x * powInt(x*x, y/2)
-}
-
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
+	// Results that overflow are computed again as big integers
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		if sum := leftVal + rightVal; (sum > leftVal) == (rightVal > 0) {
+			return &object.Integer{Value: sum}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		if difference := leftVal - rightVal; (difference < leftVal) == (rightVal > 0) {
+			return &object.Integer{Value: difference}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		if leftVal == 0 || rightVal == 0 {
+			return &object.Integer{Value: 0}
+		}
+		product := leftVal * rightVal
+		if product/rightVal == leftVal && !(leftVal == -1 && rightVal == math.MinInt64) &&
+			!(rightVal == -1 && leftVal == math.MinInt64) {
+			return &object.Integer{Value: product}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
 	case "/":
 		if rightVal == 0 {
-			return newError("division by zero")
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return evalBigIntInfixExpression(operator, left, right)
 		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "%":
 		if rightVal == 0 {
-			return newError("modulo by zero")
+			return newError(object.ZERO_DIVISION_ERROR, "modulo by zero")
 		}
 		return &object.Integer{Value: leftVal % rightVal}
 	case "//":
 		if rightVal == 0 {
-			return newError("division by zero")
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		quotient := leftVal / rightVal
+		if leftVal%rightVal != 0 && (leftVal < 0) != (rightVal < 0) {
+			quotient--
 		}
-		return &object.Integer{Value: int64(math.Floor(float64(leftVal) / float64(rightVal)))}
+		return &object.Integer{Value: quotient}
 	case "**":
-		return &object.Integer{Value: powInt(leftVal, rightVal)}
+		// A negative power is a fraction
+		if rightVal < 0 {
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
 	case "<<":
-		return &object.Integer{Value: leftVal << rightVal}
+		if rightVal < 0 {
+			return newError(object.VALUE_ERROR, "negative shift count: %d", rightVal)
+		}
+		if rightVal < 64 && (leftVal<<rightVal)>>rightVal == leftVal {
+			return &object.Integer{Value: leftVal << rightVal}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
 	case ">>":
+		if rightVal < 0 {
+			return newError(object.VALUE_ERROR, "negative shift count: %d", rightVal)
+		}
+		if rightVal > 63 {
+			rightVal = 63
+		}
 		return &object.Integer{Value: leftVal >> rightVal}
 	case "&":
 		return &object.Integer{Value: leftVal & rightVal}
@@ -310,7 +425,7 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -325,20 +440,12 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 		return &object.Float{Value: leftVal - rightVal}
 	case "*":
 		return &object.Float{Value: leftVal * rightVal}
+	// Following IEEE 754 dividing by zero gives an infinity, or NaN for 0 / 0
 	case "/":
-		if rightVal == 0 {
-			return newError("division by zero")
-		}
 		return &object.Float{Value: leftVal / rightVal}
 	case "%":
-		if rightVal == 0 {
-			return newError("modulo by zero")
-		}
 		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "//":
-		if rightVal == 0 {
-			return newError("division by zero")
-		}
 		return &object.Float{Value: math.Floor(leftVal / rightVal)}
 	case "**":
 		return &object.Float{Value: math.Pow(leftVal, rightVal)}
@@ -355,7 +462,7 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -379,7 +486,7 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	case ">=":
 		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -401,7 +508,7 @@ func evalBooleanInfixExpression(operator string, left, right object.Object) obje
 	case ">=":
 		return nativeBoolToBooleanObject((leftVal && !rightVal) || (leftVal && rightVal))
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -447,20 +554,139 @@ func evalWhileExpression(we *ast.WhileExpression, env *object.Environment) objec
 			return condition
 		}
 
-		if isTruthy(condition) {
-			result = Eval(we.Body, env)
-		} else {
+		if !isTruthy(condition) {
+			break
+		}
+
+		evaluated := Eval(we.Body, env)
+		if evaluated == BREAK {
 			break
 		}
+		if evaluated != CONTINUE {
+			result = evaluated
+		}
+	}
+	return result
+}
+
+func evalForExpression(fe *ast.ForExpression, env *object.Environment) object.Object {
+	iterable := Eval(fe.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	var result object.Object = NULL
+	err := iterate(iterable, func(key, value object.Object) bool {
+		// Every iteration gets its own scope so closures capture that iteration's values
+		loopEnv := newScopeEnvironment(env, fe.Scope)
+		if fe.Key != nil {
+			bind(fe.Key, key, loopEnv)
+			bind(fe.Value, value, loopEnv)
+		} else if iterable.Type() == object.HASH_OBJ {
+			bind(fe.Value, key, loopEnv)
+		} else {
+			bind(fe.Value, value, loopEnv)
+		}
+
+		evaluated := Eval(fe.Body, loopEnv)
+		if evaluated == BREAK {
+			return false
+		}
+		if evaluated == CONTINUE {
+			return true
+		}
+
+		result = evaluated
+		return !isInterrupt(result)
+	})
+	if err != nil {
+		err.Span = fe.Iterable.Span()
+		return err
+	}
+
+	return result
+}
+
+// iterate calls fn with the index or key and the value of each item in
+// iterable until fn returns false
+func iterate(iterable object.Object, fn func(key, value object.Object) bool) *object.Error {
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		for i, element := range iterable.Elements {
+			if !fn(&object.Integer{Value: int64(i)}, element) {
+				break
+			}
+		}
+	case *object.String:
+		for i, ch := range []rune(iterable.Value) {
+			if !fn(&object.Integer{Value: int64(i)}, &object.String{Value: string(ch)}) {
+				break
+			}
+		}
+	case *object.Hash:
+		for _, pair := range iterable.OrderedPairs() {
+			if !fn(pair.Key, pair.Value) {
+				break
+			}
+		}
+	case *object.Range:
+		for i := uint64(0); i < iterable.Len(); i++ {
+			if !fn(&object.Integer{Value: int64(i)}, &object.Integer{Value: iterable.At(i)}) {
+				break
+			}
+		}
+	default:
+		return newError(object.TYPE_ERROR, "cannot iterate over %s", iterable.Type())
+	}
+
+	return nil
+}
+
+func evalThrowStatement(ts *ast.ThrowStatement, env *object.Environment) object.Object {
+	val := Eval(ts.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	return Throw(val)
+}
+
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Block, env)
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil {
+		// The caught error is only bound inside the catch block
+		catchEnv := newScopeEnvironment(env, te.CatchScope)
+		if te.Parameter != nil {
+			bind(te.Parameter, &object.ErrorValue{Error: err}, catchEnv)
+		}
+		result = Eval(te.Catch, catchEnv)
 	}
+
+	if te.Finally != nil {
+		// A return, error or loop jump from finally replaces the outcome of the try
+		finally := Eval(te.Finally, env)
+		if isInterrupt(finally) {
+			return finally
+		}
+	}
+
 	return result
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+func newError(kind, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if b := node.Binding; b != nil {
+		if val := env.GetSlot(b.Depth, b.Slot); val != nil {
+			return val
+		}
+	}
+
+	// A variable that is not bound yet may still be found outside its scope,
+	// like a top level variable is before its let
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
@@ -469,7 +695,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError(object.NAME_ERROR, "identifier not found: "+node.Value)
 }
 
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
@@ -486,32 +712,117 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func evalCallExpression(node *ast.CallExpression, env *object.Environment) object.Object {
+	function := Eval(node.Function, env)
+	if isError(function) {
+		return function
+	}
+
+	args := evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+
+	frame := &object.Frame{
+		Function: functionName(node.Function, function),
+		Call:     node.Span(),
+		Args:     args,
+		Caller:   env.Frame(),
+	}
+
+	result := applyFunction(function, args, frame, env.Runtime())
+
+	// The innermost call an error passes through records the stack, builtins
+	// don't get a frame of their own
+	if err, ok := result.(*object.Error); ok && err.Stack == nil {
+		if _, ok := function.(*object.Function); ok {
+			err.Stack = frame.Stack()
+		} else if caller := env.Frame(); caller != nil {
+			err.Stack = caller.Stack()
+		}
+	}
+
+	return result
+}
+
+// functionName is the name a function is shown with in tracebacks
+func functionName(callee ast.Expression, function object.Object) string {
+	if fn, ok := function.(*object.Function); ok && fn.Name != "" {
+		return fn.Name
+	}
+	if ident, ok := callee.(*ast.Identifier); ok {
+		return ident.Value
+	}
+	return "<anonymous>"
+}
+
+// applyFunction calls fn for code evaluated with the runtime rt
+func applyFunction(fn object.Object, args []object.Object, frame *object.Frame, rt *object.Runtime) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
 		if len(fn.Parameters) != len(args) {
-			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+			return newError(object.ARGUMENT_ERROR, "wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		if rt != nil {
+			if err := enterCall(rt); err != nil {
+				return err
+			}
+			defer leaveCall(rt)
 		}
-		extendedEnv := extendFunctionEnv(fn, args)
+		extendedEnv := extendFunctionEnv(fn, args, frame, rt)
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		return allocate(rt, fn.Call(rt, args...))
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(object.TYPE_ERROR, "not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+func extendFunctionEnv(fn *object.Function, args []object.Object, frame *object.Frame, rt *object.Runtime) *object.Environment {
+	var names []string
+	if fn.Scope != nil {
+		names = fn.Scope.Names
+	}
+	env := object.NewFunctionEnvironment(fn.Env, frame, names)
+	env.SetRuntime(rt)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		bind(param, args[paramIdx], env)
 	}
 
 	return env
 }
 
+// newScopeEnvironment creates the environment of a for loop iteration or a
+// catch block
+func newScopeEnvironment(outer *object.Environment, scope *ast.Scope) *object.Environment {
+	if scope == nil {
+		return object.NewEnclosedEnvironment(outer)
+	}
+	return object.NewScopeEnvironment(outer, scope.Names)
+}
+
+// bind binds the variable name defines in env, in its slot if the resolver
+// gave it one
+func bind(name *ast.Identifier, val object.Object, env *object.Environment) {
+	if b := name.Binding; b != nil {
+		env.SetSlot(b.Slot, val)
+		return
+	}
+	env.Set(name.Value, val)
+}
+
+// assign changes the variable name refers to, it reports false if there is
+// no such variable bound
+func assign(name *ast.Identifier, val object.Object, env *object.Environment) bool {
+	if b := name.Binding; b != nil && env.AssignSlot(b.Depth, b.Slot, val) {
+		return true
+	}
+	_, ok := env.SetIfNameExists(name.Value, val)
+	return ok
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -528,8 +839,12 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ERROR_VALUE_OBJ && index.Type() == object.STRING_OBJ:
+		return evalErrorValueIndexExpression(left, index)
+	case left.Type() == object.MODULE_OBJ && index.Type() == object.STRING_OBJ:
+		return evalModuleIndexExpression(left, index)
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
 	}
 }
 
@@ -549,9 +864,9 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 }
 
 func evalStringIndexExpression(str, index object.Object) object.Object {
-	strObject := str.(*object.String)
+	runes := []rune(str.(*object.String).Value)
 	idx := index.(*object.Integer).Value
-	max := int64(len(strObject.Value) - 1)
+	max := int64(len(runes) - 1)
 	if idx < 0 {
 		idx = max + idx + 1
 	}
@@ -560,36 +875,32 @@ func evalStringIndexExpression(str, index object.Object) object.Object {
 		return NULL
 	}
 
-	return &object.String{Value: string(strObject.Value[idx])}
+	return &object.String{Value: string(runes[idx])}
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := map[object.HashKey]object.HashPair{}
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := Eval(pair.Value, env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{
-			Key:   key,
-			Value: value,
-		}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
@@ -597,13 +908,31 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 
 	return pair.Value
 }
+
+func evalErrorValueIndexExpression(errorValue, index object.Object) object.Object {
+	err := errorValue.(*object.ErrorValue).Error
+
+	switch index.(*object.String).Value {
+	case "message":
+		return &object.String{Value: err.Message}
+	case "type":
+		return &object.String{Value: err.Kind}
+	case "payload":
+		if err.Payload == nil {
+			return NULL
+		}
+		return err.Payload
+	default:
+		return NULL
+	}
+}
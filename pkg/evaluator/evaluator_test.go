@@ -1,20 +1,66 @@
 package evaluator_test
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/JasirZaeem/ape/pkg/evaluator"
 	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/module"
 	"github.com/JasirZaeem/ape/pkg/object"
 	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/vm"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func testEval(input string) object.Object {
+// testEval evaluates input and checks that the virtual machine gives the
+// same result
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	env := object.NewEnvironment()
 
-	return evaluator.Eval(program, env)
+	evaluated := evaluator.Eval(program, env)
+	testSameResult(t, input, evaluated, vm.New(nil).Run(program))
+
+	return evaluated
+}
+
+func testSameResult(t *testing.T, input string, evaluated, ran object.Object) {
+	t.Helper()
+
+	// The evaluator gives nil for blocks without a value where the virtual
+	// machine gives null
+	if evaluated == nil {
+		evaluated = evaluator.NULL
+	}
+	if ran == nil {
+		ran = evaluator.NULL
+	}
+
+	if expected, ok := evaluated.(*object.Error); ok {
+		got, ok := ran.(*object.Error)
+		if !ok {
+			t.Errorf("vm gave no error for %q. expected = %q, got = %s", input, expected.Traceback(), ran.Inspect())
+			return
+		}
+		if got.Kind != expected.Kind || got.Traceback() != expected.Traceback() {
+			t.Errorf("vm gave a different error for %q. expected = %s %q, got = %s %q",
+				input, expected.Kind, expected.Traceback(), got.Kind, got.Traceback())
+		}
+		return
+	}
+
+	if ran.Type() != evaluated.Type() || ran.Inspect() != evaluated.Inspect() {
+		t.Errorf("vm gave a different result for %q. expected = %s %q, got = %s %q",
+			input, evaluated.Type(), evaluated.Inspect(), ran.Type(), ran.Inspect())
+	}
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -97,7 +143,7 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -130,7 +176,7 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -162,7 +208,7 @@ func TestBangOperator(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -182,7 +228,7 @@ func TestIfElseExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
 		switch expected := tt.expected.(type) {
 		case int:
@@ -207,7 +253,7 @@ func TestReturnStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testObject(t, evaluated, tt.expected)
 	}
 }
@@ -265,7 +311,7 @@ return 1; }
 	}
 
 	for i, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
 		errObj, ok := evaluated.(*object.Error)
 		if !ok {
@@ -279,6 +325,429 @@ return 1; }
 	}
 }
 
+func TestErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(a, b) { a + b };
+let outer = fn(x) {
+  inner(x, "s");
+};
+let apply = fn(f) { f() };
+apply(fn() { outer(1) });`
+
+	evaluated := testEval(t, input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got = %T(%+v)", evaluated, evaluated)
+	}
+
+	if errObj.Span.Start.Line != 1 || errObj.Span.Start.Column != 24 {
+		t.Errorf("wrong error position. expected = 1:24, got = %d:%d",
+			errObj.Span.Start.Line, errObj.Span.Start.Column)
+	}
+
+	expected := []string{
+		`inner(1, "s") at 3:3`,
+		`outer(1) at 6:14`,
+		`f() at 5:21`,
+		`apply(fn) at 6:1`,
+	}
+
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack depth. expected = %d, got = %d", len(expected), len(errObj.Stack))
+	}
+
+	for i, frame := range errObj.Stack {
+		if frame.String() != expected[i] {
+			t.Errorf("wrong frame %d. expected = %q, got = %q", i, expected[i], frame.String())
+		}
+	}
+}
+
+func TestBuiltinErrorStackTrace(t *testing.T) {
+	input := `let parse = fn(s) { int(s) };
+parse("abc");`
+
+	errObj, ok := testEval(t, input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+
+	expected := "ERROR: could not convert \"abc\" to integer (1:21)\n  in parse(\"abc\") at 2:1"
+	if errObj.Traceback() != expected {
+		t.Errorf("wrong traceback. expected = %q, got = %q", expected, errObj.Traceback())
+	}
+}
+
+func TestTryCatchThrow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { int("abc") } catch (e) { e["type"] }`, "ValueError"},
+		{`try { int("abc") } catch (e) { e["message"] }`, `could not convert "abc" to integer`},
+		{`try { 1 / 0 } catch (e) { e["type"] }`, "ZeroDivisionError"},
+		{`try { foo } catch (e) { e["type"] }`, "NameError"},
+		{`try { char(55296) } catch (e) { e["type"] }`, "ValueError"},
+		{`try { from_bytes([256]) } catch (e) { e["type"] }`, "ValueError"},
+		{`try { from_bytes(["a"]) } catch (e) { e["type"] }`, "TypeError"},
+		{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+		{`try { throw {"code": 7} } catch (e) { e["payload"]["code"] }`, 7},
+		{`try { throw "boom" } catch (e) { e["type"] }`, "Error"},
+		{`try { int("x") } catch (e) { e["payload"] }`, nil},
+		{`try { throw 1 } catch { 2 }`, 2},
+		{`let x = 0; try { throw 1 } catch { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`let x = 0; try { 5 } finally { x = 3 }; x`, 3},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, 1},
+		{`let f = fn() { try { 1 } finally { return 2 } }; f()`, 2},
+		{`try { try { throw "in" } catch (e) { throw e } } catch (e) { e["message"] }`, "in"},
+		{`try { throw 1 } catch (e) { is_error(e) }`, true},
+		{`let f = fn() { throw "deep" }; try { f() } catch (e) { e["message"] }`, "deep"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if expected, ok := tt.expected.(string); ok {
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got = %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("wrong value. expected = %q, got = %q", expected, str.Value)
+			}
+			continue
+		}
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedKind    string
+		expectedMessage string
+	}{
+		{`throw "boom"`, "Error", "boom"},
+		{`throw 5`, "Error", "5"},
+		{`try { throw "a" } finally { 1 }`, "Error", "a"},
+		{`try { 1 } finally { throw "b" }`, "Error", "b"},
+		{`try { 1 } catch (e) { 2 }; e`, "NameError", "identifier not found: e"},
+		{`len(1, 2)`, "ArgumentError", "wrong number of arguments. got = 2, want = 1"},
+		{`set_at([1], 5, 2)`, "IndexError", "index out of range: 5"},
+		{`1 + true`, "TypeError", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(t, tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q", tt.input)
+			continue
+		}
+		if errObj.Kind != tt.expectedKind {
+			t.Errorf("wrong error kind. expected = %q, got = %q", tt.expectedKind, errObj.Kind)
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected = %q, got = %q", tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestForExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let s = 0; for (x in [1, 2, 3]) { s = s + x }; s`, 6},
+		{`let s = 0; for (i, x in [5, 6, 7]) { s = s + i }; s`, 3},
+		{`let n = 0; for (c in "abc") { n = n + 1 }; n`, 3},
+		{`let s = 0; for (k in {1: 10, 2: 20}) { s = s + k }; s`, 3},
+		{`let s = 0; for (k, v in {1: 10, 2: 20}) { s = s + v }; s`, 30},
+		{`let s = 0; for (x in range(5)) { s = s + x }; s`, 10},
+		{`let s = 0; for (x in range(2, 5)) { s = s + x }; s`, 9},
+		{`let s = 0; for (x in range(10, 0, -3)) { s = s + x }; s`, 22},
+		{`let s = 0; for (i, x in range(10, 13)) { s = s + i }; s`, 3},
+		{`for (x in [1, 2, 3]) { x * 2 }`, 6},
+		{`for (x in []) { x }`, nil},
+		{`let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10 } } }; f()`, 20},
+		{`let fs = []; for (i in range(3)) { fs = push(fs, fn() { i }) }; fs[1]()`, 1},
+		{`for (x in [1]) { let y = x }; y`, "identifier not found: y"},
+		{`for (x in 5) { x }`, "cannot iterate over INTEGER"},
+		{`len(range(0, 10, 3))`, 4},
+		{`len(range(5, 1))`, 0},
+		{`range(1, 2, 0)`, "step of `range` cannot be zero"},
+		{`len(range(0, 10, 9223372036854775807))`, 1},
+		{`let n = 0; for (x in range(0, 10, 9223372036854775807)) { n = n + 1 }; n`, 1},
+		{`let n = 0; for (x in range(9223372036854775800, 9223372036854775807, 3)) { n = n + 1 }; n`, 3},
+		{`let s = 0; for (x in range(-9223372036854775808, 9223372036854775807, 4611686018427387904)) { s = s + x }; s`, -9223372036854775808},
+		{`let s = []; for (x in range(9223372036854775807, -9223372036854775808, -9223372036854775808)) { s = push(s, x) }; s[1]`, -1},
+		{`array(range(9223372036854775805, 9223372036854775807))[1]`, 9223372036854775806},
+		{`array(range(9223372036854775807))`, "range too long for an array: 9223372036854775807 integers"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if message, ok := tt.expected.(string); ok {
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got = %T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != message {
+				t.Errorf("wrong error message. expected = %q, got = %q", message, errObj.Message)
+			}
+			continue
+		}
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let i = 0; while (true) { i = i + 1; if (i == 5) { break } }; i`, 5},
+		{`let i = 0; let n = 0; while (i < 5) { i = i + 1; if (i == 2) { continue }; n = n + 1 }; n`, 4},
+		{`let s = 0; for (x in range(10)) { if (x % 2 == 0) { continue }; if (x > 7) { break }; s = s + x }; s`, 16},
+		{`let s = 0; for (x in range(3)) { for (y in range(3)) { if (y == 1) { break }; s = s + 1 } }; s`, 3},
+		{`let s = 0; for (x in [1, 2, 3]) { try { if (x == 2) { break } } finally { s = s + x } }; s`, 3},
+		{`let f = fn() { for (x in range(3)) { break }; 7 }; f()`, 7},
+		{`for (x in [1, 2, 3]) { if (x == 3) { continue }; x }`, 2},
+	}
+
+	for _, tt := range tests {
+		testObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestModules(t *testing.T) {
+	loader := module.NewMemoryLoader(map[string]string{
+		"lib/math.ape": `import "util.ape" as util;
+export let square = fn(x) { x * x };
+export let double = fn(x) { util["twice"](x) };
+let hidden = 1;`,
+		"lib/util.ape": `export let twice = fn(x) { x * 2 };`,
+		"counter.ape": `export let count = 0;
+export let inc = fn() { count = count + 1 };`,
+		"cycle/a.ape": `import "b.ape" as b;`,
+		"cycle/b.ape": `import "a.ape" as a;`,
+		"broken.ape":  `let = 1;`,
+		"failing.ape": `export let x = 1 + true;`,
+		"undefined.ape": `let unused = 1;
+export let f = fn() { missing + 1 };`,
+	})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`import "lib/math.ape" as m; m["square"](4)`, 16},
+		{`import "lib/math.ape" as m; m["double"](5)`, 10},
+		{`import "counter.ape" as a; import "counter.ape" as b; a["inc"](); b["inc"](); a["count"]`, 2},
+		{`import "lib/math.ape" as m; m["hidden"]`, `no export named "hidden" in module /lib/math.ape`},
+		{`import "cycle/a.ape" as a`, "import cycle: /cycle/a.ape -> /cycle/b.ape -> /cycle/a.ape"},
+		{`import "missing.ape" as m`, `cannot import "missing.ape": module /missing.ape not found`},
+		{`import "broken.ape" as m`, `cannot import "broken.ape": /broken.ape:1:5: error[P001]: expected next token to be IDENT, got =`},
+		{`import "failing.ape" as m`, "type mismatch: INTEGER + BOOLEAN"},
+		{`import "undefined.ape" as m`, `cannot import "undefined.ape": /undefined.ape:2:23: error[R001]: undefined name missing`},
+		{`export let x = 3; x`, 3},
+	}
+
+	for _, tt := range tests {
+		env := evaluator.NewModules(loader).NewEnvironment("")
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := evaluator.Eval(program, env)
+		ran := vm.New(vm.NewModules(loader).NewModule("")).Run(program)
+		testSameResult(t, tt.input, evaluated, ran)
+
+		if message, ok := tt.expected.(string); ok {
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got = %T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != message {
+				t.Errorf("wrong error message. expected = %q, got = %q", message, errObj.Message)
+			}
+			continue
+		}
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestImportWithoutModules(t *testing.T) {
+	evaluated := testEval(t, `import "lib.ape" as lib`)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got = %T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Kind != "ImportError" {
+		t.Errorf("wrong error kind. expected = %q, got = %q", "ImportError", errObj.Kind)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Results too large for the heap are refused before they are built, long
+	// before the deadline
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelDeadline()
+
+	loader := module.NewMemoryLoader(map[string]string{"spin.ape": `while (true) {}`})
+
+	tests := []struct {
+		input        string
+		ctx          context.Context
+		limits       object.Limits
+		expectedKind string
+	}{
+		{"while (true) {}", context.Background(), object.Limits{MaxSteps: 1000}, "StepLimitError"},
+		{`import "spin.ape" as spin`, context.Background(), object.Limits{MaxSteps: 1000}, "StepLimitError"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", context.Background(), object.Limits{MaxDepth: 100}, "RecursionError"},
+		{`let s = "ape"; while (true) { s = s + s }`, context.Background(), object.Limits{MaxHeap: 1 << 16}, "MemoryError"},
+		{"let a = []; while (true) { a = push(a, 1) }", context.Background(), object.Limits{MaxHeap: 1 << 16}, "MemoryError"},
+		{"len(array(range(200000000)))", deadline, object.Limits{MaxHeap: 1 << 20}, "MemoryError"},
+		{`let s = "a"; for (i in range(12)) { s = s + s }; len(split(s, ""))`, context.Background(), object.Limits{MaxHeap: 1 << 16}, "MemoryError"},
+		{"len(array(range(200000000)))", expired, object.Limits{}, "TimeoutError"},
+		{"while (true) {}", expired, object.Limits{}, "TimeoutError"},
+		{"while (true) {}", cancelled, object.Limits{}, "CancelledError"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(50)", context.Background(), object.Limits{MaxSteps: 10000, MaxDepth: 100, MaxHeap: 1 << 16}, ""},
+	}
+
+	for _, tt := range tests {
+		env := evaluator.NewModules(loader).NewEnvironment("")
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := evaluator.EvalWithLimits(tt.ctx, program, env, tt.limits)
+
+		errObj, ok := evaluated.(*object.Error)
+		if tt.expectedKind == "" {
+			if ok {
+				t.Errorf("unexpected error for %q. got = %s", tt.input, errObj.Traceback())
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("no error object returned for %q. got = %T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != tt.expectedKind {
+			t.Errorf("wrong error kind for %q. expected = %q, got = %q", tt.input, tt.expectedKind, errObj.Kind)
+		}
+	}
+}
+
+func TestLimitsCanBeCaught(t *testing.T) {
+	input := `let f = fn(n) { f(n + 1) };
+let caught = try { f(0) } catch (e) { e["type"] };
+caught`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	evaluated := evaluator.EvalWithLimits(context.Background(), program, object.NewEnvironment(), object.Limits{MaxDepth: 10})
+
+	str, ok := evaluated.(*object.String)
+	if !ok || str.Value != "RecursionError" {
+		t.Errorf("wrong result. expected = %q, got = %T(%+v)", "RecursionError", evaluated, evaluated)
+	}
+}
+
+func TestLimitsEndWithEvaluation(t *testing.T) {
+	env := object.NewEnvironment()
+	limited := parser.New(lexer.New("let count = fn(n) { if (n > 0) { count(n - 1) } else { 0 } }")).ParseProgram()
+	evaluator.EvalWithLimits(context.Background(), limited, env, object.Limits{MaxDepth: 10})
+
+	// Functions defined under limits are not limited when called later
+	program := parser.New(lexer.New("count(100)")).ParseProgram()
+	testIntegerObject(t, evaluator.Eval(program, env), 0)
+}
+
+func TestEvalSharesResolvedPrograms(t *testing.T) {
+	program := parser.New(lexer.New("let double = fn(x) { x * 2 }; double(n)")).ParseProgram()
+	env := object.NewEnvironment()
+	env.Set("n", &object.Integer{Value: 0})
+	if diagnostics := evaluator.Resolve(program, env); len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	// Eval does not resolve the program again, so it can be evaluated
+	// concurrently in environments of its own
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(n int64) {
+			defer wg.Done()
+			env := object.NewEnvironment()
+			env.Set("n", &object.Integer{Value: n})
+			if result := evaluator.Eval(program, env); result.Inspect() != fmt.Sprint(n*2) {
+				t.Errorf("wrong result for n = %d. got = %s", n, result.Inspect())
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+}
+
+func TestIO(t *testing.T) {
+	loader := module.NewMemoryLoader(map[string]string{"greet.ape": `print("imported");`})
+
+	tests := []struct {
+		input          string
+		stdin          string
+		expectedOutput string
+		expected       string // the result, or the message of the error
+	}{
+		{`print("a", 1, [true])`, "", "a1[true]\n", "null"},
+		{`println("a", 1, [true]); println()`, "", "a 1 [true]\n\n", "null"},
+		{`printf("%s is %d, %.2f%% done %v %q %t\n", "ape", 1, 0.5, {"a": 1}, "x", true)`, "", "ape is 1, 0.50% done {a: 1} \"x\" true\n", "null"},
+		{`printf("%5d|%-3s|%x", 42, "a", 255)`, "", "   42|a  |ff", "null"},
+		{`printf("%d", 9223372036854775807 + 1)`, "", "9223372036854775808", "null"},
+		{`printf("%f", 1)`, "", "1.000000", "null"},
+		{`import "greet.ape" as greet; print("main")`, "", "imported\nmain\n", "null"},
+		{`let name = input("name? "); "hi " + name`, "ape\nrest\n", "name? ", "hi ape"},
+		{`[read_line(), read_line(), read_line()]`, "one\r\ntwo", "", "[one, two, null]"},
+		{`read_line()`, "", "", "null"},
+		{`printf("%d", "a")`, "", "", "argument to `printf` for %d must be INTEGER, got STRING"},
+		{`printf("%d %d", 1)`, "", "", "wrong number of arguments. got = 2, want = 3"},
+		{`printf("%s", 1, 2)`, "", "", "wrong number of arguments. got = 3, want = 2"},
+		{`printf("%z", 1)`, "", "", "invalid format verb %z for argument 1"},
+		{`printf("%")`, "", "", `invalid format "%": missing verb at the end`},
+		{`printf(1)`, "", "", "first argument to `printf` must be STRING, got INTEGER"},
+		{`read_line(1)`, "", "", "wrong number of arguments. got = 1, want = 0"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		var out bytes.Buffer
+		env := evaluator.NewModules(loader).NewEnvironment("")
+		env.SetRuntime(&object.Runtime{IO: object.NewIO(&out, strings.NewReader(tt.stdin))})
+		evaluated := evaluator.Eval(program, env)
+		if out.String() != tt.expectedOutput {
+			t.Errorf("wrong output for %q. expected = %q, got = %q", tt.input, tt.expectedOutput, out.String())
+		}
+
+		var ranOut bytes.Buffer
+		machine := vm.New(vm.NewModules(loader).NewModule(""))
+		machine.SetIO(object.NewIO(&ranOut, strings.NewReader(tt.stdin)))
+		testSameResult(t, tt.input, evaluated, machine.Run(program))
+		if ranOut.String() != tt.expectedOutput {
+			t.Errorf("vm gave a different output for %q. expected = %q, got = %q", tt.input, tt.expectedOutput, ranOut.String())
+		}
+
+		got := ""
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		} else if evaluated != nil {
+			got = evaluated.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. expected = %q, got = %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestLetStatement(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -291,14 +760,14 @@ func TestLetStatement(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) {x + 2;};"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	fn, ok := evaluated.(*object.Function)
 	if !ok {
 		t.Fatalf("object is not Function. got = %T (%+v)", evaluated, evaluated)
@@ -333,14 +802,33 @@ func TestFunctionApplication(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// A local is found outside its function until its let runs
+		{"let x = 1; let f = fn() { let a = x; let x = 2; a * 10 + x }; f()", 12},
+		{"let f = fn() { g() }; let g = fn() { 5 }; f()", 5},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let fs = []; for (i in range(3)) { let j = i * 2; fs = push(fs, fn() { j }) }; fs[2]()", 4},
+		{"let f = fn(x) { for (y in [1]) { try { throw 2 } catch (e) { x = x + y + e[\"payload\"] } }; x }; f(3)", 6},
+		{"let x = 1; if (true) { let x = 2 }; x", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("object is not String. got = %T (%+v)", evaluated, evaluated)
@@ -354,7 +842,7 @@ func TestStringLiteral(t *testing.T) {
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("object is not String. got = %T (%+v)", evaluated, evaluated)
@@ -382,7 +870,7 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
 		switch expected := tt.expected.(type) {
 		case int:
@@ -404,7 +892,7 @@ func TestBuiltinFunctions(t *testing.T) {
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Array)
 	if !ok {
 		t.Fatalf("object is not Array. got = %T (%+v)", evaluated, evaluated)
@@ -464,7 +952,7 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -485,32 +973,353 @@ func TestHashLiterals(t *testing.T) {
   false: 6
 }`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Hash)
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got = %T (%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		evaluator.TRUE.HashKey():                   5,
-		evaluator.FALSE.HashKey():                  6,
+	expected := []struct {
+		key   object.Hashable
+		value int64
+	}{
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{evaluator.TRUE, 5},
+		{evaluator.FALSE, 6},
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got = %d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got = %d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for _, e := range expected {
+		pair, ok := result.Get(e.key)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
+			continue
+		}
+
+		testIntegerObject(t, pair.Value, e.value)
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`9223372036854775807 + 1`, "9223372036854775808"},
+		{`99999999999999999999`, "99999999999999999999"},
+		{`len(range(-5, 9223372036854775807))`, "9223372036854775812"},
+		{`len(range(-9223372036854775808, 9223372036854775807))`, "18446744073709551615"},
+		{`type(18446744073709551616)`, "BIGINT"},
+		{`-9223372036854775808`, "-9223372036854775808"},
+		{`type(-9223372036854775808)`, "INTEGER"},
+		{`99999999999999999999 - 99999999999999999998`, "1"},
+		{`-9223372036854775807 - 2`, "-9223372036854775809"},
+		{`9223372036854775807 * 2`, "18446744073709551614"},
+		{`-9223372036854775807 - 1`, "-9223372036854775808"},
+		{`-(-9223372036854775807 - 1)`, "9223372036854775808"},
+		{`(-9223372036854775807 - 1) / -1`, "9223372036854775808"},
+		{`(-9223372036854775807 - 1) // -1`, "9223372036854775808"},
+		{`2 ** 64`, "18446744073709551616"},
+		{`2 ** 62`, "4611686018427387904"},
+		{`(-2) ** 63`, "-9223372036854775808"},
+		{`1 << 70`, "1180591620717411303424"},
+		{`(1 << 70) >> 68`, "4"},
+		{`-(1 << 70) >> 200`, "-1"},
+		{`1 >> 100`, "0"},
+		{`(2 ** 100) // -3`, "-422550200076076467165567735126"},
+		{`(2 ** 100) / -3`, "-422550200076076467165567735125"},
+		{`(2 ** 65) % 7`, "4"},
+		{`~(2 ** 64)`, "-18446744073709551617"},
+		{`(2 ** 64 + 255) & 255`, "255"},
+		{`(2 ** 64) | 1`, "18446744073709551617"},
+		{`(2 ** 64) ^ (2 ** 64)`, "0"},
+		{`-7 // 2`, "-4"},
+		{`7 // -2`, "-4"},
+		{`9007199254740993 // 1`, "9007199254740993"},
+		{`int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{`int(float(2 ** 70))`, "1180591620717411303424"},
+		{`string(2 ** 70)`, "1180591620717411303424"},
+		{`float(2 ** 70) == 2 ** 70`, "true"},
+		{`2 ** 70 > 1.5`, "true"},
+		{`2 ** 70 < 2 ** 71`, "true"},
+		{`2 ** 64 == 2 ** 64`, "true"},
+		{`[2 ** 64] == [int("18446744073709551616")]`, "true"},
+		{`{2 ** 70: 1}[2 ** 70]`, "1"},
+		{`{float(2 ** 70): 5}[2 ** 70]`, "5"},
+		{`is_int(2 ** 70)`, "true"},
+		{`type(2 ** 70)`, "BIGINT"},
+		{`type(2 ** 70 - 2 ** 70)`, "INTEGER"},
+		{`bool(2 ** 70)`, "true"},
+		{`1 << -1`, "negative shift count: -1"},
+		{`2 ** 100000000`, "integer too large: 2 ** 100000000"},
+		{`4 ** 9223372036854775807`, "integer too large: 4 ** 9223372036854775807"},
+		{`(2 ** 64) ** 4611686018427387904`, "integer too large: 18446744073709551616 ** 4611686018427387904"},
+		{`(-1) ** 9223372036854775807`, "-1"},
+		{`(2 ** 70) / 0`, "division by zero"},
+		{`int(float("inf"))`, "could not convert Inf to integer"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %s. want = %s, got = %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestNumericPromotion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + 2.5`, "3.5"},
+		{`2.5 + 1`, "3.5"},
+		{`5 - 0.5`, "4.5"},
+		{`2.5 * 2`, "5"},
+		{`type(2.5 * 2)`, "FLOAT"},
+		{`7 / 2.0`, "3.5"},
+		{`7 / 2`, "3"},
+		{`7 // 2.0`, "3"},
+		{`-7 // 2.0`, "-4"},
+		{`7.5 % 2`, "1.5"},
+		{`2 ** 0.5`, "1.4142135623730951"},
+		{`2 ** -1`, "0.5"},
+		{`type(2 ** -1)`, "FLOAT"},
+		{`4.0 ** 2`, "16"},
+		{`(2 ** 70) + 0.5`, "1180591620717411300000"},
+		{`(2 ** 2000) * 1.0`, "Inf"},
+		{`1 < 1.5`, "true"},
+		{`1.5 >= 2`, "false"},
+		{`1 & 1.5`, "unknown operator: INTEGER & FLOAT"},
+		{`1.5 << 1`, "unknown operator: FLOAT << INTEGER"},
+		{`1 / 0.0`, "Inf"},
+		{`-1 / 0.0`, "-Inf"},
+		{`0 / 0.0`, "NaN"},
+		{`1.0 // 0`, "Inf"},
+		{`1 % 0.0`, "NaN"},
+		{`1 / 0`, "division by zero"},
+		{`1 % 0`, "modulo by zero"},
+		{`let nan = 0 / 0.0; nan == nan`, "false"},
+		{`let nan = 0 / 0.0; nan != nan`, "true"},
+		{`let nan = 0 / 0.0; [nan < 1, nan > 1, 1 <= nan, nan >= 1.0]`, "[false, false, false, false]"},
+		{`let inf = 1 / 0.0; inf > 2 ** 100`, "true"},
+		{`is_nan(0 / 0.0)`, "true"},
+		{`is_nan(1.5)`, "false"},
+		{`is_nan(1)`, "false"},
+		{`is_inf(-1 / 0.0)`, "true"},
+		{`is_inf(2 ** 2000)`, "false"},
+		{`is_inf("a")`, "argument to `is_inf` must be a number, got STRING"},
+		{`float("inf")`, "Inf"},
+		{`float(string(-1 / 0.0))`, "-Inf"},
+		{`float("nan")`, "NaN"},
+		{`let nan = 0 / 0.0; {nan: 1}[nan]`, "1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %s. want = %s, got = %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestUnicodeStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len("héllo")`, "5"},
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[-1]`, "語"},
+		{`"日本語"[3]`, "null"},
+		{`let 名前 = "太郎"; 名前`, "太郎"},
+		{`"\u{1F600}" == "😀"`, "true"},
+		{`len("\u{1F600}")`, "1"},
+		{`reverse("añb😀")`, "😀bña"},
+		{`[first("éa"), last("aé"), rest("éab"), init("abé")]`, "[é, é, ab, ab]"},
+		{`[pop("aé"), pop_front("éa"), at("aéb", 1)]`, "[a, a, é]"},
+		{`set_at("日本", 0, "x")`, "x本"},
+		{`set_at("ab", 0, "é")`, "éb"},
+		{`insert("日本", 1, "の")`, "日の本"},
+		{`remove("日の本", 1)`, "日本"},
+		{`array("日本")`, "[日, 本]"},
+		{`let out = ""; for (i, c in "aé") { out = out + string(i) + c }; out`, "0a1é"},
+		{`char(233)`, "é"},
+		{`char(128512)`, "😀"},
+		{`char(55296)`, "argument to `char` must be a valid code point, got 55296"},
+		{`ascii("é")`, "233"},
+		{`ascii("ab")`, "argument to `ascii` must be a single character, got ab"},
+		{`bytes("é")`, "[195, 169]"},
+		{`len(bytes("日本"))`, "6"},
+		{`from_bytes([230, 151, 165])`, "日"},
+		{`from_bytes(bytes("naïve")) == "naïve"`, "true"},
+		{`from_bytes([256])`, "elements of `from_bytes` must be INTEGER between 0 and 255, got 256"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
 		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %s. want = %s, got = %s", tt.input, tt.expected, got)
+		}
+	}
+}
 
-		testIntegerObject(t, pair.Value, expectedValue)
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, 2] == [1, 2, 3]`, false},
+		{`[] == []`, true},
+		{`[[1, "a"], [true]] == [[1, "a"], [true]]`, true},
+		{`[[1, "a"], [true]] == [[1, "b"], [true]]`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`{"a": 1} != {"a": 1, "b": 2}`, true},
+		{`{} == {}`, true},
+		{`[1] == {0: 1}`, false},
+		{`[1] == 1`, false},
+		{`1 == 1.0`, true},
+		{`1.0 != 1`, false},
+		{`[1, 2.5] == [1.0, 2.5]`, true},
+		{`1 < 1.5`, true},
+		{`2.5 > 3`, false},
+		{`2 <= 2.0`, true},
+		{`3.0 >= 4`, false},
+		{`9007199254740993 == 9007199254740992.0`, false},
+		{`9007199254740993 > 9007199254740992.0`, true},
+		{`range(3) == range(0, 3)`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		{`1 & 1.5`, "unknown operator: INTEGER & FLOAT"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if message, ok := tt.expected.(string); ok {
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got = %T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != message {
+				t.Errorf("wrong error message. expected = %q, got = %q", message, errObj.Message)
+			}
+			continue
+		}
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestSame(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`let a = [1, 2]; same(a, a)`, true},
+		{`let a = [1, 2]; let b = a; same(a, b)`, true},
+		{`same([1, 2], [1, 2])`, false},
+		{`let h = {"a": 1}; same(h, h)`, true},
+		{`same({"a": 1}, {"a": 1})`, false},
+		{`same(1, 1)`, true},
+		{`same(1, 1.0)`, false},
+		{`same("a", "a")`, true},
+		{`let f = fn() { 1 }; same(f, f)`, true},
+		{`same(fn() { 1 }, fn() { 1 })`, false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"z": 1, "a": 2, 10: 3, "m": 4}`, `{z: 1, a: 2, 10: 3, m: 4}`},
+		{`{"a": 1, "b": 2, "a": 3}`, `{a: 3, b: 2}`},
+		{`keys({"z": 1, "a": 2, "m": 3})`, `[z, a, m]`},
+		{`values({"z": 1, "a": 2, "m": 3})`, `[1, 2, 3]`},
+		{`entries({"z": 1, "a": 2})`, `[[z, 1], [a, 2]]`},
+		{`set({"z": 1, "a": 2}, "b", 3)`, `{z: 1, a: 2, b: 3}`},
+		{`set({"z": 1, "a": 2}, "z", 3)`, `{z: 3, a: 2}`},
+		{`delete({"z": 1, "a": 2, "m": 3}, "a")`, `{z: 1, m: 3}`},
+		{`set(delete({"z": 1, "a": 2}, "z"), "z", 3)`, `{a: 2, z: 3}`},
+		{`let ks = []; for (k in {"z": 1, "a": 2, "m": 3}) { ks = push(ks, k) }; ks`, `[z, a, m]`},
+	}
+
+	for _, tt := range tests {
+		// Repeated as map iteration order would differ between runs
+		for i := 0; i < 10; i++ {
+			evaluated := testEval(t, tt.input)
+			if evaluated.Inspect() != tt.expected {
+				t.Errorf("wrong result for %s. want = %s, got = %s", tt.input, tt.expected, evaluated.Inspect())
+				break
+			}
+		}
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let grid = {[0, 0]: 1, [0, 1]: 2}; grid[[0, 1]]`, 2},
+		{`let grid = {[0, 0]: 1}; let x = 0; grid[[x, x]]`, 1},
+		{`{[1, 2]: 1}[[2, 1]]`, nil},
+		{`{[[1], "a"]: 5}[[[1], "a"]]`, 5},
+		{`{{"a": 1, "b": 2}: 3}[{"b": 2, "a": 1}]`, 3},
+		{`{1.5: 7}[1.5]`, 7},
+		{`{1: 7}[1.0]`, 7},
+		{`{2.0: 7}[2]`, 7},
+		{`let n = if (false) { 1 }; {n: 8}[n]`, 8},
+		{`len({1: 1, 1.0: 2, [1]: 3, [1.0]: 4})`, 2},
+		{`{1: 1, 1.0: 2}[1]`, 2},
+		{`has_key(set({}, [1, 2], 1), [1, 2])`, true},
+		{`len(delete({[1, 2]: 1, [2, 1]: 2}, [1, 2]))`, 1},
+		{`let f = fn() { 1 }; {[f]: 1}[[f]]`, 1},
+		{`{fn() { 1 }: 1}`, "unusable as hash key: FUNCTION"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if message, ok := tt.expected.(string); ok {
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got = %T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != message {
+				t.Errorf("wrong error message. expected = %q, got = %q", message, errObj.Message)
+			}
+			continue
+		}
+		testObject(t, evaluated, tt.expected)
 	}
 }
 
@@ -550,7 +1359,7 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -0,0 +1,114 @@
+package evaluator
+
+import (
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// readLine reads a line of input for input and read_line, null once there is
+// no more input
+func readLine(streams *object.IO, prompt string) object.Object {
+	line, err := streams.ReadLine(prompt)
+	if err == io.EOF {
+		return NULL
+	}
+	if err != nil {
+		return newError(object.IO_ERROR, "cannot read input: %s", err)
+	}
+	return &object.String{Value: line}
+}
+
+// sprintf formats args for printf like Go's fmt.Sprintf, with these verbs
+//
+//	%v  any value as print shows it, %s is the same
+//	%q  a quoted string
+//	%d  an integer, %x and %X in hexadecimal, %b in binary, %o in octal
+//	%f  a number, %e and %g in scientific notation
+//	%t  a boolean
+//	%%  a percent sign
+//
+// Flags, width and precision between % and the verb work as in Go.
+func sprintf(format string, args []object.Object) (string, *object.Error) {
+	var out strings.Builder
+	used := 0
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i == len(format) {
+			return "", newError(object.VALUE_ERROR, "invalid format %q: missing verb at the end", format)
+		}
+
+		verb := format[i]
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+
+		// Verbs without an argument are counted for the error below
+		if used >= len(args) {
+			used++
+			continue
+		}
+		arg := args[used]
+		used++
+
+		value, err := formatValue(verb, arg, used)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, format[start:i+1], value)
+	}
+
+	if used != len(args) {
+		return "", newError(object.ARGUMENT_ERROR, "wrong number of arguments. got = %d, want = %d", len(args)+1, used+1)
+	}
+	return out.String(), nil
+}
+
+// formatValue converts arg, the n-th argument to format, to the Go value
+// formatted by verb
+func formatValue(verb byte, arg object.Object, n int) (interface{}, *object.Error) {
+	switch verb {
+	case 'v', 's':
+		return arg.Inspect(), nil
+	case 'q':
+		if str, ok := arg.(*object.String); ok {
+			return str.Value, nil
+		}
+		return nil, newError(object.TYPE_ERROR, "argument to `printf` for %%%c must be STRING, got %s", verb, arg.Type())
+	case 'd', 'x', 'X', 'b', 'o':
+		if value, ok := object.ToBig(arg); ok {
+			return value, nil
+		}
+		return nil, newError(object.TYPE_ERROR, "argument to `printf` for %%%c must be INTEGER, got %s", verb, arg.Type())
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		switch number := arg.(type) {
+		case *object.Float:
+			return number.Value, nil
+		case *object.Integer:
+			return float64(number.Value), nil
+		case *object.BigInt:
+			value, _ := new(big.Float).SetInt(number.Value).Float64()
+			return value, nil
+		}
+		return nil, newError(object.TYPE_ERROR, "argument to `printf` for %%%c must be FLOAT, got %s", verb, arg.Type())
+	case 't':
+		if boolean, ok := arg.(*object.Boolean); ok {
+			return boolean.Value, nil
+		}
+		return nil, newError(object.TYPE_ERROR, "argument to `printf` for %%%c must be BOOLEAN, got %s", verb, arg.Type())
+	}
+	return nil, newError(object.VALUE_ERROR, "invalid format verb %%%c for argument %d", verb, n)
+}
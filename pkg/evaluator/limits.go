@@ -0,0 +1,175 @@
+package evaluator
+
+import (
+	"context"
+	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"time"
+)
+
+// The context is only checked every so many steps, checking it is slow
+// compared to evaluating a node
+const contextCheckInterval = 1024
+
+// EvalWithLimits is Eval for code that can not be trusted to finish or to
+// stay small. Going over a limit raises an error of its own kind, which try
+// can catch. The call depth goes back down as calls return, but once one of
+// the other limits is reached every further step or allocation raises its
+// error again, so the code can not keep running by catching it.
+//
+//	StepLimitError  more than limits.MaxSteps nodes were evaluated
+//	RecursionError  calls nested deeper than limits.MaxDepth
+//	MemoryError     more than limits.MaxHeap bytes of strings, arrays and hashes were created
+//	TimeoutError    the deadline of ctx passed
+//	CancelledError  ctx was cancelled
+//
+// Functions defined by the code and called later by Eval are not limited.
+func EvalWithLimits(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) object.Object {
+	previous := env.Runtime()
+	rt := &object.Runtime{Context: ctx, Limits: limits}
+	if previous != nil {
+		rt.IO = previous.IO
+	}
+	env.SetRuntime(rt)
+	defer env.SetRuntime(previous)
+
+	return Eval(node, env)
+}
+
+// ApplyWithLimits is Apply for functions of code evaluated by EvalWithLimits
+func ApplyWithLimits(ctx context.Context, fn object.Object, args []object.Object, limits object.Limits) object.Object {
+	return ApplyWithRuntime(fn, args, &object.Runtime{Context: ctx, Limits: limits})
+}
+
+// ApplyWithRuntime is Apply for a call under the limits and with the IO of rt
+func ApplyWithRuntime(fn object.Object, args []object.Object, rt *object.Runtime) object.Object {
+	return apply(fn, args, rt)
+}
+
+// step counts a node about to be evaluated against the limits of rt
+func step(rt *object.Runtime) *object.Error {
+	rt.Steps++
+	if rt.Limits.MaxSteps > 0 && rt.Steps > rt.Limits.MaxSteps {
+		return newError(object.STEP_LIMIT_ERROR, "step limit exceeded: %d steps", rt.Limits.MaxSteps)
+	}
+
+	if rt.Steps%contextCheckInterval == 0 {
+		return interrupted(rt)
+	}
+	return nil
+}
+
+// interrupted returns the error of an evaluation whose deadline passed or
+// that was cancelled, builtins that loop for long check it themselves as
+// they take a single step
+func interrupted(rt *object.Runtime) *object.Error {
+	if rt == nil || rt.Context == nil {
+		return nil
+	}
+
+	// Timers may not fire while evaluation keeps the thread busy, like in the
+	// browser, so the deadline is checked directly too
+	if deadline, ok := rt.Context.Deadline(); ok && time.Now().After(deadline) {
+		return newError(object.TIMEOUT_ERROR, "evaluation timed out")
+	}
+	switch rt.Context.Err() {
+	case context.DeadlineExceeded:
+		return newError(object.TIMEOUT_ERROR, "evaluation timed out")
+	case context.Canceled:
+		return newError(object.CANCELLED_ERROR, "evaluation cancelled")
+	}
+	return nil
+}
+
+// enterCall counts a call of an Ape function against the limits of rt, the
+// caller must call leaveCall once it returns if there is no error
+func enterCall(rt *object.Runtime) *object.Error {
+	if err := CheckDepth(rt, rt.Depth); err != nil {
+		return err
+	}
+	rt.Depth++
+	return nil
+}
+
+func leaveCall(rt *object.Runtime) {
+	rt.Depth--
+}
+
+// Step counts an instruction of the virtual machine against the limits of rt,
+// like a node evaluated by Eval
+func Step(rt *object.Runtime) *object.Error {
+	return step(rt)
+}
+
+// CheckDepth returns the error of a call made while depth calls are running,
+// nil if the depth limit of rt allows it. The virtual machine checks calls
+// with it as it keeps its own call stack.
+func CheckDepth(rt *object.Runtime, depth int) *object.Error {
+	if rt.Limits.MaxDepth > 0 && depth >= rt.Limits.MaxDepth {
+		return newError(object.RECURSION_ERROR, "maximum call depth exceeded: %d calls", rt.Limits.MaxDepth)
+	}
+	return nil
+}
+
+// Allocate counts obj, just created by the virtual machine, like allocate
+func Allocate(rt *object.Runtime, obj object.Object) object.Object {
+	return allocate(rt, obj)
+}
+
+// allocate counts obj, just created, against the heap limit of rt. Objects
+// other than strings, arrays and hashes are returned as they are.
+func allocate(rt *object.Runtime, obj object.Object) object.Object {
+	if rt == nil || rt.Limits.MaxHeap <= 0 {
+		return obj
+	}
+
+	rt.Heap += sizeOf(obj)
+	if rt.Heap > rt.Limits.MaxHeap {
+		return newError(object.MEMORY_ERROR, "memory limit exceeded: %d bytes", rt.Limits.MaxHeap)
+	}
+	return obj
+}
+
+// reserve checks that a result of about size bytes can still be created under
+// the heap limit of rt, before a builtin takes the time and memory to build
+// it. The result is counted by allocate once it is built.
+func reserve(rt *object.Runtime, size int64) *object.Error {
+	if rt == nil || rt.Limits.MaxHeap <= 0 || rt.Heap+size <= rt.Limits.MaxHeap {
+		return nil
+	}
+	// The limit is reached as if the result was built
+	rt.Heap += size
+	return newError(object.MEMORY_ERROR, "memory limit exceeded: %d bytes", rt.Limits.MaxHeap)
+}
+
+// ReserveInfix checks that the result of a binary operator can be created
+// under the heap limit of rt, before strings are concatenated
+func ReserveInfix(rt *object.Runtime, operator string, left, right object.Object) *object.Error {
+	return reserveInfix(rt, operator, left, right)
+}
+
+func reserveInfix(rt *object.Runtime, operator string, left, right object.Object) *object.Error {
+	l, ok := left.(*object.String)
+	if !ok || operator != "+" {
+		return nil
+	}
+	r, ok := right.(*object.String)
+	if !ok {
+		return nil
+	}
+	return reserve(rt, 16+int64(len(l.Value)+len(r.Value)))
+}
+
+// sizeOf approximates the bytes taken by obj itself, not counting the
+// elements it refers to which were counted when they were created
+func sizeOf(obj object.Object) int64 {
+	switch obj := obj.(type) {
+	case *object.String:
+		return 16 + int64(len(obj.Value))
+	case *object.Array:
+		return 24 + 16*int64(len(obj.Elements))
+	case *object.Hash:
+		return 48 + 64*int64(obj.Len())
+	}
+	return 0
+}
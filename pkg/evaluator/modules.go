@@ -0,0 +1,160 @@
+package evaluator
+
+import (
+	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/module"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"strings"
+)
+
+// Modules evaluates the modules imported by a program, every module is
+// evaluated once and shared by all the modules importing it
+type Modules struct {
+	loader  module.Loader
+	run     Runner
+	cache   map[string]*object.Module
+	loading []string        // modules being evaluated, the innermost last
+	runtime *object.Runtime // of the code importing a module
+	globals *object.Environment
+}
+
+// Runner runs the program of mod, binding the names it defines in mod.Env. rt
+// is the runtime of the code importing mod, nil if it has none.
+type Runner func(program *ast.Program, mod *object.Module, rt *object.Runtime) object.Object
+
+func NewModules(loader module.Loader) *Modules {
+	m := NewModulesWithRunner(loader, nil)
+	m.run = func(program *ast.Program, mod *object.Module, rt *object.Runtime) object.Object {
+		env := object.NewEnclosedModuleEnvironment(mod, m.globals)
+		env.SetRuntime(rt)
+		return Eval(program, env)
+	}
+	return m
+}
+
+// NewModulesWithRunner is NewModules for modules that are not run by Eval
+func NewModulesWithRunner(loader module.Loader, run Runner) *Modules {
+	return &Modules{
+		loader: loader,
+		run:    run,
+		cache:  map[string]*object.Module{},
+	}
+}
+
+// NewModule creates the module for a program named name, usually the path of
+// its file, that imports modules through m
+func (m *Modules) NewModule(name string) *object.Module {
+	return &object.Module{Name: name, Importer: m}
+}
+
+// NewEnvironment creates the environment for a program named name that
+// imports modules through m
+func (m *Modules) NewEnvironment(name string) *object.Environment {
+	return object.NewEnclosedModuleEnvironment(m.NewModule(name), m.globals)
+}
+
+// SetGlobals makes the names bound in globals visible to every module run by
+// Eval, like builtins, unless a module binds the name itself
+func (m *Modules) SetGlobals(globals *object.Environment) {
+	m.globals = globals
+}
+
+// SetRuntime sets the runtime the modules imported next run under, the one of
+// the code importing them
+func (m *Modules) SetRuntime(rt *object.Runtime) {
+	m.runtime = rt
+}
+
+func (m *Modules) Import(from, path string) (*object.Module, *object.Error) {
+	name, err := m.loader.Resolve(from, path)
+	if err != nil {
+		return nil, newError(object.IMPORT_ERROR, "cannot import %q: %s", path, err)
+	}
+
+	if mod, ok := m.cache[name]; ok {
+		return mod, nil
+	}
+
+	for i, loading := range m.loading {
+		if loading == name {
+			cycle := append(append([]string{}, m.loading[i:]...), name)
+			return nil, newError(object.IMPORT_ERROR, "import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	source, err := m.loader.Load(name)
+	if err != nil {
+		return nil, newError(object.IMPORT_ERROR, "cannot import %q: %s", path, err)
+	}
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		return nil, newError(object.IMPORT_ERROR, "cannot import %q: %s:%s", path, name, p.Diagnostics()[0])
+	}
+	// Modules see the builtins and the globals, undefined names fail the
+	// import like syntax errors
+	for _, d := range Resolve(program, m.globals) {
+		if d.Severity == diagnostic.ERROR {
+			return nil, newError(object.IMPORT_ERROR, "cannot import %q: %s:%s", path, name, d)
+		}
+	}
+
+	mod := m.NewModule(name)
+
+	m.loading = append(m.loading, name)
+	result := m.run(program, mod, m.runtime)
+	m.loading = m.loading[:len(m.loading)-1]
+
+	if err, ok := result.(*object.Error); ok {
+		return nil, err
+	}
+
+	m.cache[name] = mod
+	return mod, nil
+}
+
+func evalImportStatement(is *ast.ImportStatement, env *object.Environment) object.Object {
+	mod := env.Module()
+	if mod == nil || mod.Importer == nil {
+		return newError(object.IMPORT_ERROR, "cannot import %q: imports are not available here", is.Path.Value)
+	}
+
+	// Modules the program imports run under its limits and with its IO too
+	if m, ok := mod.Importer.(*Modules); ok {
+		m.SetRuntime(env.Runtime())
+	}
+
+	imported, err := mod.Importer.Import(mod.Name, is.Path.Value)
+	if err != nil {
+		return err
+	}
+
+	bind(is.Alias, imported, env)
+	return nil
+}
+
+func evalExportStatement(es *ast.ExportStatement, env *object.Environment) object.Object {
+	if val := Eval(es.Statement, env); isError(val) {
+		return val
+	}
+
+	// Outside a module there is nothing to export to, the name is still bound
+	if mod := env.Module(); mod != nil {
+		mod.Export(es.Statement.Name.Value)
+	}
+	return nil
+}
+
+func evalModuleIndexExpression(module, index object.Object) object.Object {
+	mod := module.(*object.Module)
+	name := index.(*object.String).Value
+
+	if val, ok := mod.Get(name); ok {
+		return val
+	}
+	return newError(object.NAME_ERROR, "no export named %q in module %s", name, mod.Name)
+}
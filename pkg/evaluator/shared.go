@@ -0,0 +1,72 @@
+package evaluator
+
+import (
+	"github.com/JasirZaeem/ape/pkg/object"
+)
+
+// The virtual machine runs operators, builtins and errors through these so
+// both ways of running Ape code agree on the results
+
+// Infix applies a binary operator, other than && and || which decide whether
+// their right operand is evaluated at all
+func Infix(operator string, left, right object.Object) object.Object {
+	return evalInfixOperatorExpression(operator, left, right)
+}
+
+func Prefix(operator string, right object.Object) object.Object {
+	return evalPrefixOperatorExpression(operator, right)
+}
+
+// Index looks up index in left like left[index]
+func Index(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// NewError creates a runtime error of kind with a message formatted like
+// fmt.Sprintf
+func NewError(kind, format string, a ...interface{}) *object.Error {
+	return newError(kind, format, a...)
+}
+
+// Builtin returns the builtin function called name
+func Builtin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// Apply calls fn, an Ape function or a builtin, with args from outside Ape
+// code, errors it raises get a stack starting at the call
+func Apply(fn object.Object, args []object.Object) object.Object {
+	return apply(fn, args, nil)
+}
+
+func apply(fn object.Object, args []object.Object, rt *object.Runtime) object.Object {
+	frame := &object.Frame{Function: functionName(nil, fn), Args: args}
+
+	result := applyFunction(fn, args, frame, rt)
+	if err, ok := result.(*object.Error); ok && err.Stack == nil {
+		if _, ok := fn.(*object.Function); ok {
+			err.Stack = frame.Stack()
+		}
+	}
+	return result
+}
+
+// Throw creates the error thrown by a throw statement with val
+func Throw(val object.Object) *object.Error {
+	// Rethrowing a caught error keeps its original position and stack
+	if caught, ok := val.(*object.ErrorValue); ok {
+		return caught.Error
+	}
+
+	message := val.Inspect()
+	if str, ok := val.(*object.String); ok {
+		message = str.Value
+	}
+
+	return &object.Error{Kind: object.ERROR, Message: message, Payload: val}
+}
@@ -2,8 +2,12 @@ package format
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/JasirZaeem/ape/pkg/ast"
 	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/token"
+	"math"
+	"unicode"
 )
 
 type Formatter struct {
@@ -11,6 +15,14 @@ type Formatter struct {
 	indentChar  byte
 	indentSize  int
 	buffer      bytes.Buffer
+
+	// Comments of the program being formatted, those before comment have
+	// already been written
+	comments []*ast.Comment
+	comment  int
+	// Comments that follow code on their line, written at the end of the
+	// line being formatted
+	pending []*ast.Comment
 }
 
 func New() *Formatter {
@@ -23,13 +35,98 @@ func New() *Formatter {
 }
 
 func (f *Formatter) Format(program *ast.Program) string {
-	for _, statement := range program.Statements {
+	f.comments = program.Comments
+	f.comment = 0
+	f.pending = nil
+
+	f.formatStatements(program.Statements)
+	f.formatComments(math.MaxInt)
+
+	return f.buffer.String()
+}
+
+func (f *Formatter) formatStatements(statements []ast.Statement) {
+	for _, statement := range statements {
+		f.formatComments(statement.Span().Start.Offset)
 		f.formatStatement(&statement)
 		if _, ok := statement.(*ast.EmptyStatement); !ok && f.buffer.Len() > 0 {
-			f.buffer.WriteByte('\n')
+			f.formatLineComments(statement.Span().End.Line, math.MaxInt)
+			f.newline()
 		}
 	}
-	return f.buffer.String()
+}
+
+// formatComments writes the comments starting before offset on lines of their
+// own. Comments inside an expression end up before the next statement.
+func (f *Formatter) formatComments(offset int) {
+	for f.comment < len(f.comments) && f.comments[f.comment].Span().Start.Offset < offset {
+		f.writeIndent()
+		f.buffer.WriteString(f.comments[f.comment].Text())
+		f.buffer.WriteByte('\n')
+		f.comment++
+	}
+}
+
+// formatLineComments keeps the comments on line that start before offset for
+// the end of the line being formatted, including those inside the code
+// written on it
+func (f *Formatter) formatLineComments(line, offset int) {
+	for f.comment < len(f.comments) {
+		span := f.comments[f.comment].Span()
+		if span.Start.Line != line || span.Start.Offset >= offset {
+			return
+		}
+		f.pending = append(f.pending, f.comments[f.comment])
+		f.comment++
+	}
+}
+
+// formatInlineComments writes the block comments starting before offset where
+// the formatter is, line comments can not be followed by code and are kept
+// for the end of the line
+func (f *Formatter) formatInlineComments(offset int) {
+	for f.comment < len(f.comments) && f.comments[f.comment].Span().Start.Offset < offset {
+		comment := f.comments[f.comment]
+		if comment.Text()[0] == '#' {
+			f.pending = append(f.pending, comment)
+		} else {
+			f.buffer.WriteByte(' ')
+			f.buffer.WriteString(comment.Text())
+		}
+		f.comment++
+	}
+}
+
+// hasComments reports whether comments that have not been written start
+// between the offsets from and to
+func (f *Formatter) hasComments(from, to int) bool {
+	for i := f.comment; i < len(f.comments) && f.comments[i].Span().Start.Offset < to; i++ {
+		if f.comments[i].Span().Start.Offset > from {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLineComments reports whether comments running to the end of their line
+// that have not been written start between the offsets from and to
+func (f *Formatter) hasLineComments(from, to int) bool {
+	for i := f.comment; i < len(f.comments) && f.comments[i].Span().Start.Offset < to; i++ {
+		if f.comments[i].Span().Start.Offset > from && f.comments[i].Text()[0] == '#' {
+			return true
+		}
+	}
+	return false
+}
+
+// newline ends the line being formatted after the comments kept for its end
+func (f *Formatter) newline() {
+	for _, comment := range f.pending {
+		f.buffer.WriteByte(' ')
+		f.buffer.WriteString(comment.Text())
+	}
+	f.pending = f.pending[:0]
+	f.buffer.WriteByte('\n')
 }
 
 func (f *Formatter) writeIndent() {
@@ -46,6 +143,22 @@ func (f *Formatter) formatStatement(statement *ast.Statement) {
 	case *ast.ReturnStatement:
 		f.writeIndent()
 		f.formatReturnStatement(statement)
+	case *ast.ThrowStatement:
+		f.writeIndent()
+		f.formatThrowStatement(statement)
+	case *ast.ImportStatement:
+		f.writeIndent()
+		f.formatImportStatement(statement)
+	case *ast.ExportStatement:
+		f.writeIndent()
+		f.buffer.WriteString("export ")
+		f.formatLetStatement(statement.Statement)
+	case *ast.BreakStatement:
+		f.writeIndent()
+		f.buffer.WriteString("break;")
+	case *ast.ContinueStatement:
+		f.writeIndent()
+		f.buffer.WriteString("continue;")
 	case *ast.ExpressionStatement:
 		f.writeIndent()
 		f.formatExpressionStatement(statement)
@@ -54,6 +167,9 @@ func (f *Formatter) formatStatement(statement *ast.Statement) {
 		f.formatBlockStatement(statement)
 	case *ast.EmptyStatement:
 		f.formatEmptyStatement()
+	case *ast.BadStatement:
+		f.writeIndent()
+		f.formatBadStatement(statement)
 	}
 }
 
@@ -72,22 +188,55 @@ func (f *Formatter) formatReturnStatement(returnStatement *ast.ReturnStatement)
 
 }
 
+func (f *Formatter) formatImportStatement(importStatement *ast.ImportStatement) {
+	f.buffer.WriteString("import ")
+	f.formatStringLiteral(importStatement.Path)
+	f.buffer.WriteString(" as ")
+	f.buffer.WriteString(importStatement.Alias.String())
+	f.buffer.WriteByte(';')
+}
+
+func (f *Formatter) formatThrowStatement(throwStatement *ast.ThrowStatement) {
+	f.buffer.WriteString("throw ")
+	f.formatExpression(&throwStatement.Value, parser.LOWEST)
+	f.buffer.WriteByte(';')
+}
+
 func (f *Formatter) formatExpressionStatement(expressionStatement *ast.ExpressionStatement) {
 	f.formatExpression(&expressionStatement.Expression, parser.LOWEST)
 	f.buffer.WriteByte(';')
 }
 
+// formatBlock writes block in braces, comments on the lines of its braces stay
+// on them
+func (f *Formatter) formatBlock(block *ast.BlockStatement) {
+	f.buffer.WriteByte('{')
+	first := block.EndToken.Start.Offset
+	if len(block.Statements) > 0 {
+		first = block.Statements[0].Span().Start.Offset
+	}
+	f.formatLineComments(block.Token.Start.Line, first)
+	f.newline()
+
+	f.formatBlockStatement(block)
+	f.writeIndent()
+	f.buffer.WriteByte('}')
+	f.formatLineComments(block.EndToken.Start.Line, math.MaxInt)
+}
+
 func (f *Formatter) formatBlockStatement(blockStatement *ast.BlockStatement) {
 	f.indentation++
-	for _, statement := range blockStatement.Statements {
-		f.formatStatement(&statement)
-		if _, ok := statement.(*ast.EmptyStatement); !ok && f.buffer.Len() > 0 {
-			f.buffer.WriteByte('\n')
-		}
-	}
+	f.formatStatements(blockStatement.Statements)
+	f.formatComments(blockStatement.EndToken.Start.Offset)
 	f.indentation--
 }
 
+// Code that failed to parse is kept as it was written
+func (f *Formatter) formatBadStatement(badStatement *ast.BadStatement) {
+	f.buffer.WriteString(badStatement.Source)
+	f.buffer.WriteByte(';')
+}
+
 func (f *Formatter) formatEmptyStatement() {
 	if f.buffer.Len() > 1 &&
 		!(f.buffer.Bytes()[f.buffer.Len()-2] == '\n' && f.buffer.Bytes()[f.buffer.Len()-1] == '\n') {
@@ -113,6 +262,10 @@ func (f *Formatter) formatExpression(expression *ast.Expression, precedence int)
 		f.formatIfExpression(expression)
 	case *ast.WhileExpression:
 		f.formatWhileExpression(expression)
+	case *ast.ForExpression:
+		f.formatForExpression(expression)
+	case *ast.TryExpression:
+		f.formatTryExpression(expression)
 	case *ast.FunctionLiteral:
 		f.formatFunctionLiteral(expression)
 	case *ast.CallExpression:
@@ -125,6 +278,8 @@ func (f *Formatter) formatExpression(expression *ast.Expression, precedence int)
 		f.formatIndexExpression(expression)
 	case *ast.HashLiteral:
 		f.formatHashLiteral(expression)
+	case *ast.BadExpression:
+		f.buffer.WriteString(expression.Source)
 	}
 }
 
@@ -199,51 +354,71 @@ func (f *Formatter) formatInfixExpression(infixExpression *ast.InfixExpression,
 func (f *Formatter) formatIfExpression(ifExpression *ast.IfExpression) {
 	f.buffer.WriteString("if (")
 	f.formatExpression(&ifExpression.Condition, parser.LOWEST)
-	f.buffer.WriteString(") {\n")
-	f.formatBlockStatement(ifExpression.Consequence)
-	f.writeIndent()
-	f.buffer.WriteByte('}')
+	f.buffer.WriteString(") ")
+	f.formatBlock(ifExpression.Consequence)
 	if ifExpression.Alternative != nil {
-		f.buffer.WriteString(" else {\n")
-		f.formatBlockStatement(ifExpression.Alternative)
-		f.writeIndent()
-		f.buffer.WriteByte('}')
+		f.buffer.WriteString(" else ")
+		f.formatBlock(ifExpression.Alternative)
 	}
 }
 
 func (f *Formatter) formatWhileExpression(whileExpression *ast.WhileExpression) {
 	f.buffer.WriteString("while (")
 	f.formatExpression(&whileExpression.Condition, parser.LOWEST)
-	f.buffer.WriteString(") {\n")
-	f.formatBlockStatement(whileExpression.Body)
-	f.writeIndent()
-	f.buffer.WriteByte('}')
+	f.buffer.WriteString(") ")
+	f.formatBlock(whileExpression.Body)
+}
+
+func (f *Formatter) formatForExpression(forExpression *ast.ForExpression) {
+	f.buffer.WriteString("for (")
+	if forExpression.Key != nil {
+		f.buffer.WriteString(forExpression.Key.String())
+		f.buffer.WriteString(", ")
+	}
+	f.buffer.WriteString(forExpression.Value.String())
+	f.buffer.WriteString(" in ")
+	f.formatExpression(&forExpression.Iterable, parser.LOWEST)
+	f.buffer.WriteString(") ")
+	f.formatBlock(forExpression.Body)
+}
+
+func (f *Formatter) formatTryExpression(tryExpression *ast.TryExpression) {
+	f.buffer.WriteString("try ")
+	f.formatBlock(tryExpression.Block)
+	if tryExpression.Catch != nil {
+		f.buffer.WriteString(" catch ")
+		if tryExpression.Parameter != nil {
+			f.buffer.WriteByte('(')
+			f.buffer.WriteString(tryExpression.Parameter.String())
+			f.buffer.WriteString(") ")
+		}
+		f.formatBlock(tryExpression.Catch)
+	}
+	if tryExpression.Finally != nil {
+		f.buffer.WriteString(" finally ")
+		f.formatBlock(tryExpression.Finally)
+	}
 }
 
 func (f *Formatter) formatFunctionLiteral(functionLiteral *ast.FunctionLiteral) {
 	f.buffer.WriteString("fn(")
 	for i, parameter := range functionLiteral.Parameters {
 		f.buffer.WriteString(parameter.String())
+		// Comments after a parameter stay with it
 		if i < len(functionLiteral.Parameters)-1 {
+			f.formatInlineComments(functionLiteral.Parameters[i+1].Span().Start.Offset)
 			f.buffer.WriteString(", ")
+		} else {
+			f.formatInlineComments(functionLiteral.Body.Token.Start.Offset)
 		}
 	}
-	f.buffer.WriteString(") {\n")
-	f.formatBlockStatement(functionLiteral.Body)
-	f.writeIndent()
-	f.buffer.WriteByte('}')
+	f.buffer.WriteString(") ")
+	f.formatBlock(functionLiteral.Body)
 }
 
 func (f *Formatter) formatCallExpression(callExpression *ast.CallExpression) {
 	f.formatExpression(&callExpression.Function, parser.LOWEST)
-	f.buffer.WriteByte('(')
-	for i, argument := range callExpression.Arguments {
-		f.formatExpression(&argument, parser.LOWEST)
-		if i < len(callExpression.Arguments)-1 {
-			f.buffer.WriteString(", ")
-		}
-	}
-	f.buffer.WriteByte(')')
+	f.formatList('(', ')', callExpression.Arguments, callExpression.Token, callExpression.EndToken.Start.Offset)
 }
 
 func (f *Formatter) formatStringLiteral(stringLiteral *ast.StringLiteral) {
@@ -259,21 +434,78 @@ func (f *Formatter) formatStringLiteral(stringLiteral *ast.StringLiteral) {
 		case '\\':
 			f.buffer.WriteString("\\\\")
 		default:
-			f.buffer.WriteRune(char)
+			// Control and other invisible characters are kept as escapes
+			if unicode.IsPrint(char) {
+				f.buffer.WriteRune(char)
+			} else {
+				fmt.Fprintf(&f.buffer, "\\u{%x}", char)
+			}
 		}
 	}
 	f.buffer.WriteByte('"')
 }
 
 func (f *Formatter) formatArrayLiteral(arrayLiteral *ast.ArrayLiteral) {
-	f.buffer.WriteByte('[')
-	for i, element := range arrayLiteral.Elements {
-		f.formatExpression(&element, parser.LOWEST)
-		if i < len(arrayLiteral.Elements)-1 {
+	f.formatList('[', ']', arrayLiteral.Elements, arrayLiteral.Token, arrayLiteral.EndToken.Start.Offset)
+}
+
+// formatList writes the elements of an array or the arguments of a call
+// between open and close, the opening delimiter being start. Block comments
+// stay before the element they precede, lists with line comments are written
+// an element on each line.
+func (f *Formatter) formatList(open, close byte, list []ast.Expression, start token.Token, end int) {
+	if f.hasLineComments(start.Start.Offset, end) {
+		f.formatListLines(open, close, list, start.Start.Line, end)
+		return
+	}
+
+	f.buffer.WriteByte(open)
+	for i := range list {
+		for f.comment < len(f.comments) && f.comments[f.comment].Span().Start.Offset < list[i].Span().Start.Offset {
+			f.buffer.WriteString(f.comments[f.comment].Text())
+			f.buffer.WriteByte(' ')
+			f.comment++
+		}
+		f.formatExpression(&list[i], parser.LOWEST)
+		if i < len(list)-1 {
 			f.buffer.WriteString(", ")
 		}
 	}
-	f.buffer.WriteByte(']')
+	f.formatInlineComments(end)
+	f.buffer.WriteByte(close)
+}
+
+// formatListLines writes an element on each line, for lists with comments
+// that are kept next to the elements they are written by
+func (f *Formatter) formatListLines(open, close byte, list []ast.Expression, line, end int) {
+	f.buffer.WriteByte(open)
+	first := end
+	if len(list) > 0 {
+		first = list[0].Span().Start.Offset
+	}
+	f.formatLineComments(line, first)
+	f.newline()
+
+	f.indentation++
+	for i := range list {
+		f.formatComments(list[i].Span().Start.Offset)
+		f.writeIndent()
+		f.formatExpression(&list[i], parser.LOWEST)
+
+		// Lists can not end with a comma
+		next := end
+		if i < len(list)-1 {
+			f.buffer.WriteByte(',')
+			next = list[i+1].Span().Start.Offset
+		}
+		f.formatLineComments(list[i].Span().End.Line, next)
+		f.newline()
+	}
+	f.formatComments(end)
+	f.indentation--
+
+	f.writeIndent()
+	f.buffer.WriteByte(close)
 }
 
 func (f *Formatter) formatIndexExpression(indexExpression *ast.IndexExpression) {
@@ -284,21 +516,62 @@ func (f *Formatter) formatIndexExpression(indexExpression *ast.IndexExpression)
 }
 
 func (f *Formatter) formatHashLiteral(hashLiteral *ast.HashLiteral) {
+	if f.hasComments(hashLiteral.Token.Start.Offset, hashLiteral.EndToken.Start.Offset) {
+		f.formatHashLiteralLines(hashLiteral)
+		return
+	}
+
 	f.buffer.WriteByte('{')
-	i := 0
-	for key, value := range hashLiteral.Pairs {
-		f.formatExpression(&key, parser.LOWEST)
+	for i := range hashLiteral.Pairs {
+		pair := &hashLiteral.Pairs[i]
+		f.formatExpression(&pair.Key, parser.LOWEST)
 		f.buffer.WriteString(": ")
-		f.formatExpression(&value, parser.LOWEST)
+		f.formatExpression(&pair.Value, parser.LOWEST)
 
 		if i < len(hashLiteral.Pairs)-1 {
 			f.buffer.WriteString(", ")
 		}
-		i++
 	}
 	f.buffer.WriteByte('}')
 }
 
+// formatHashLiteralLines writes a pair on each line, for hashes with comments
+// that are kept next to the pairs they are written by
+func (f *Formatter) formatHashLiteralLines(hashLiteral *ast.HashLiteral) {
+	end := hashLiteral.EndToken.Start.Offset
+
+	f.buffer.WriteByte('{')
+	first := end
+	if len(hashLiteral.Pairs) > 0 {
+		first = hashLiteral.Pairs[0].Key.Span().Start.Offset
+	}
+	f.formatLineComments(hashLiteral.Token.Start.Line, first)
+	f.newline()
+
+	f.indentation++
+	for i := range hashLiteral.Pairs {
+		pair := &hashLiteral.Pairs[i]
+		f.formatComments(pair.Key.Span().Start.Offset)
+		f.writeIndent()
+		f.formatExpression(&pair.Key, parser.LOWEST)
+		f.buffer.WriteString(": ")
+		f.formatExpression(&pair.Value, parser.LOWEST)
+		f.buffer.WriteByte(',')
+
+		next := end
+		if i < len(hashLiteral.Pairs)-1 {
+			next = hashLiteral.Pairs[i+1].Key.Span().Start.Offset
+		}
+		f.formatLineComments(pair.Value.Span().End.Line, next)
+		f.newline()
+	}
+	f.formatComments(end)
+	f.indentation--
+
+	f.writeIndent()
+	f.buffer.WriteByte('}')
+}
+
 func (f *Formatter) String() string {
 	return f.buffer.String()
 }
@@ -112,3 +112,195 @@ hanoi(3, "A", "B", "C");
 		}
 	}
 }
+
+func TestFormatKeepsBrokenCode(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;let  x=1", "let = 5;\nlet x = 1;\n"},
+		{"let f = fn(a) { let = 1; a+1 }", "let f = fn(a) {\n  let = 1;\n  a + 1;\n};\n"},
+		{"if (x { a; }\nb", "if (x { a; };\nb;\n"},
+	}
+
+	for _, tt := range inputs {
+		formatted := testFormat(t, tt.input)
+		if formatted != tt.expected {
+			t.Errorf("expected = %q, got = %q", tt.expected, formatted)
+		}
+	}
+}
+
+func TestFormatTryThrow(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{`throw  "x"`, "throw \"x\";\n"},
+		{
+			`let r=try{int("x")}catch(e){e["type"]}finally{print(1)}`,
+			`let r = try {
+  int("x");
+} catch (e) {
+  e["type"];
+} finally {
+  print(1);
+};
+`,
+		},
+		{"try{1}catch{2}", "try {\n  1;\n} catch {\n  2;\n};\n"},
+	}
+
+	for _, tt := range inputs {
+		formatted := testFormat(t, tt.input)
+		if formatted != tt.expected {
+			t.Errorf("expected = %q, got = %q", tt.expected, formatted)
+		}
+	}
+}
+
+func TestFormatForExpression(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{"for(x in arr){print(x)}", "for (x in arr) {\n  print(x);\n};\n"},
+		{"for(k,v in h){k}", "for (k, v in h) {\n  k;\n};\n"},
+		{"while(x){if(x){break}continue}", "while (x) {\n  if (x) {\n    break;\n  };\n  continue;\n};\n"},
+	}
+
+	for _, tt := range inputs {
+		formatted := testFormat(t, tt.input)
+		if formatted != tt.expected {
+			t.Errorf("expected = %q, got = %q", tt.expected, formatted)
+		}
+	}
+}
+
+func TestFormatKeepsComments(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`# header
+let x=1; # one
+/* block
+   comment */
+let f=fn(a){
+  # inside
+  a+1 # add
+  # end of body
+};
+
+# before if
+if(x){1}else{
+# only comment
+}`,
+			`# header
+let x = 1; # one
+/* block
+   comment */
+let f = fn(a) {
+  # inside
+  a + 1; # add
+  # end of body
+};
+
+# before if
+if (x) {
+  1;
+} else {
+  # only comment
+};
+`,
+		},
+		{"let y = 2 /* two */ + 3", "let y = 2 + 3; /* two */\n"},
+		{"x // 2 # floor", "x // 2; # floor\n"},
+		{"x;\n# last", "x;\n# last\n"},
+		{"# only a comment", "# only a comment\n"},
+		{
+			"let h = {\n  \"a\": 1, # first\n  # own line\n  \"b\": 2 /* second */\n};",
+			"let h = {\n  \"a\": 1, # first\n  # own line\n  \"b\": 2, /* second */\n};\n",
+		},
+		{"let h = {\"a\": {\"b\": 1 # nested\n}}", "let h = {\n  \"a\": {\n    \"b\": 1, # nested\n  },\n};\n"},
+		{"if (x) {\n  1\n} # then\nelse {\n  2\n} # else", "if (x) {\n  1;\n} else { # then\n  2;\n}; # else\n"},
+		{"let f = fn(x) {\n  x\n} # closing\n(1)", "let f = fn(x) {\n  x;\n}(1); # closing\n"},
+		{"let f = fn(x) { # opening\n  x\n}", "let f = fn(x) { # opening\n  x;\n};\n"},
+		{"fn(a /* the a */, b /* the b */) { a }", "fn(a /* the a */, b /* the b */) {\n  a;\n};\n"},
+		{"fn(a, # the a\n b) { a }", "fn(a, b) { # the a\n  a;\n};\n"},
+		{"let a = [1, # first\n 2]", "let a = [\n  1, # first\n  2\n];\n"},
+		{"f(1, /* inline */ 2)", "f(1, /* inline */ 2);\n"},
+		{"f(1, # one\n  # own line\n  [2, /* two */ 3] # three\n)", "f(\n  1, # one\n  # own line\n  [2, /* two */ 3] # three\n);\n"},
+		{"let a = [ # opening\n]", "let a = [ # opening\n];\n"},
+		{"[1 /* one */]", "[1 /* one */];\n"},
+	}
+
+	for _, tt := range inputs {
+		formatted := testFormat(t, tt.input)
+		if formatted != tt.expected {
+			t.Errorf("expected = %q, got = %q", tt.expected, formatted)
+		}
+		if again := testFormat(t, formatted); again != formatted {
+			t.Errorf("formatting is not stable. expected = %q, got = %q", formatted, again)
+		}
+	}
+}
+
+func TestFormatImportExport(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{`import  "lib/math.ape"  as  m`, "import \"lib/math.ape\" as m;\n"},
+		{"export let  sq=fn(x){x*x}", "export let sq = fn(x) {\n  x * x;\n};\n"},
+	}
+
+	for _, tt := range inputs {
+		formatted := testFormat(t, tt.input)
+		if formatted != tt.expected {
+			t.Errorf("expected = %q, got = %q", tt.expected, formatted)
+		}
+	}
+}
+
+func TestFormatHashLiteral(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{`{}`, "{};\n"},
+		{`{"z":1,"a":2,3:[1,2]}`, "{\"z\": 1, \"a\": 2, 3: [1, 2]};\n"},
+		{`let h={"b":{"y":1,"x":2},"a":0}`, "let h = {\"b\": {\"y\": 1, \"x\": 2}, \"a\": 0};\n"},
+	}
+
+	for _, tt := range inputs {
+		// Pairs used to come out in map order, so check it stays the same
+		for i := 0; i < 10; i++ {
+			formatted := testFormat(t, tt.input)
+			if formatted != tt.expected {
+				t.Errorf("expected = %q, got = %q", tt.expected, formatted)
+				break
+			}
+		}
+	}
+}
+
+func TestFormatStringEscapes(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{`"héllo 日本"`, "\"héllo 日本\";\n"},
+		{`"\u{41}\u{1F600}"`, "\"A😀\";\n"},
+		{`"a\u{0}b\u{200B}"`, "\"a\\u{0}b\\u{200b}\";\n"},
+		{`"tab\tquote\""`, "\"tab\\tquote\\\"\";\n"},
+	}
+
+	for _, tt := range inputs {
+		formatted := testFormat(t, tt.input)
+		if formatted != tt.expected {
+			t.Errorf("expected = %q, got = %q", tt.expected, formatted)
+		}
+	}
+}
@@ -1,45 +1,101 @@
 package lexer
 
 import (
-	"bytes"
 	"github.com/JasirZaeem/ape/pkg/token"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int // after current position, for look ahead.
-	ch           byte
+	ch           rune
+
+	offset int // byte offset of input in the untrimmed source
+	line   int // line of ch
+	column int // column of ch, counted in characters
+
+	comments []token.Token
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: strings.TrimSpace(input)}
+	trimmed := strings.TrimLeftFunc(input, unicode.IsSpace)
+	skipped := input[:len(input)-len(trimmed)]
+
+	l := &Lexer{
+		input:  strings.TrimRightFunc(trimmed, unicode.IsSpace),
+		offset: len(skipped),
+		line:   1 + strings.Count(skipped, "\n"),
+		column: utf8.RuneCountInString(skipped[strings.LastIndexByte(skipped, '\n')+1:]),
+	}
 	l.readChar()
 	return l
 }
 
-// Consumes the char at readPosition and updates state of the lexer
+// Consumes the char at readPosition and updates state of the lexer, input is
+// decoded as UTF-8 with invalid bytes read as utf8.RuneError
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+		return ch
+	}
+}
+
+// Slice returns the source text covered by span
+func (l *Lexer) Slice(span token.Span) string {
+	clamp := func(offset int) int {
+		offset -= l.offset
+		if offset < 0 {
+			return 0
+		}
+		if offset > len(l.input) {
+			return len(l.input)
+		}
+		return offset
+	}
+
+	start, end := clamp(span.Start.Offset), clamp(span.End.Offset)
+	if start > end {
+		return ""
 	}
+	return l.input[start:end]
+}
+
+// Comments returns the comments skipped so far, in source order
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+// Position of the char currently held in ch
+func (l *Lexer) pos() token.Position {
+	return token.Position{Offset: l.offset + l.position, Line: l.line, Column: l.column}
 }
 
 // Creates a new token after converting ch to a string
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
@@ -48,6 +104,7 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	start := l.pos()
 
 	switch l.ch {
 	case '=':
@@ -84,8 +141,18 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BIT_OR, l.ch)
 		}
+	case '#':
+		l.readLineComment(start)
+		return l.NextToken()
 	case '/':
-		if l.peekChar() == '/' {
+		if l.peekChar() == '*' {
+			if !l.readBlockComment(start) {
+				tok = token.Token{Type: token.ILLEGAL, Literal: "/*"}
+				tok.Start, tok.End = start, l.pos()
+				return tok
+			}
+			return l.NextToken()
+		} else if l.peekChar() == '/' {
 			l.readChar()
 			tok = token.Token{Type: token.DOUBLE_SLASH, Literal: "//"}
 		} else {
@@ -135,8 +202,12 @@ func (l *Lexer) NextToken() token.Token {
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
 	case '"':
+		var ok bool
+		tok.Literal, ok = l.readString()
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		if !ok {
+			tok.Type = token.ILLEGAL
+		}
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -147,7 +218,9 @@ func (l *Lexer) NextToken() token.Token {
 		l.readChar()
 		l.skipWhitespace()
 		if l.ch == '\n' {
-			return newToken(token.EMPTY_LINE, l.ch)
+			tok = newToken(token.EMPTY_LINE, l.ch)
+			tok.Start, tok.End = start, l.pos()
+			return tok
 		} else {
 			return l.NextToken()
 		}
@@ -158,16 +231,20 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Start, tok.End = start, l.pos()
 			// readIdentifier has already advanced readposition in lexer
 			return tok
 		} else if isDigit(l.ch) || (l.ch == '.' && isDigit(l.peekChar())) {
-			return l.readNumber()
+			tok = l.readNumber()
+			tok.Start, tok.End = start, l.pos()
+			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
 
 	l.readChar()
+	tok.Start, tok.End = start, l.pos()
 	return tok
 }
 
@@ -179,8 +256,10 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[startingPosition:l.position]
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// isLetter accepts any Unicode letter so identifiers can be written in any script
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
 func (l *Lexer) readNumber() token.Token {
@@ -205,7 +284,7 @@ func (l *Lexer) readNumber() token.Token {
 	return tok
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
@@ -215,9 +294,53 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) readString() string {
-	var out bytes.Buffer
+// readLineComment skips a # comment up to, but not including, the end of line
+func (l *Lexer) readLineComment(start token.Position) {
+	startingPosition := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
 
+	l.comments = append(l.comments, token.Token{
+		Type:    token.COMMENT,
+		Literal: strings.TrimRight(l.input[startingPosition:l.position], " \t\r"),
+		Start:   start,
+		End:     l.pos(),
+	})
+}
+
+// readBlockComment skips a /* */ comment, it reports false if the comment is
+// not closed before the end of input
+func (l *Lexer) readBlockComment(start token.Position) bool {
+	startingPosition := l.position
+	l.readChar() // skip the opening /*
+	l.readChar()
+
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		if l.ch == 0 {
+			return false
+		}
+		l.readChar()
+	}
+	l.readChar() // skip the closing */
+	l.readChar()
+
+	l.comments = append(l.comments, token.Token{
+		Type:    token.COMMENT,
+		Literal: l.input[startingPosition:l.position],
+		Start:   start,
+		End:     l.pos(),
+	})
+	return true
+}
+
+// readString reads a string literal and resolves its escapes, it reports
+// false with the raw literal if a \u{...} escape is not a valid code point
+func (l *Lexer) readString() (string, bool) {
+	var out strings.Builder
+
+	startingPosition := l.position
+	valid := true
 	l.readChar() // skip the opening "
 
 	for l.ch != '"' && l.ch != 0 {
@@ -232,15 +355,56 @@ func (l *Lexer) readString() string {
 				out.WriteByte('"')
 			case '\\':
 				out.WriteByte('\\')
+			case 'u':
+				if ch, ok := l.readUnicodeEscape(); ok {
+					out.WriteRune(ch)
+				} else {
+					valid = false
+				}
+				continue
 			default:
 				out.WriteByte('\\')
-				out.WriteByte(l.ch)
+				out.WriteRune(l.ch)
 			}
 		} else {
-			out.WriteByte(l.ch)
+			out.WriteRune(l.ch)
 		}
 		l.readChar()
 	}
 
-	return out.String()
+	if !valid {
+		return l.input[startingPosition:l.position], false
+	}
+	return out.String(), true
+}
+
+// readUnicodeEscape reads the {XXXX} of a \u{XXXX} escape with ch on the u,
+// it leaves ch on the char after the escape
+func (l *Lexer) readUnicodeEscape() (rune, bool) {
+	l.readChar() // skip the u
+	if l.ch != '{' {
+		return 0, false
+	}
+	l.readChar()
+
+	startingPosition := l.position
+	for isHexDigit(l.ch) {
+		l.readChar()
+	}
+	digits := l.input[startingPosition:l.position]
+	if l.ch != '}' || len(digits) == 0 || len(digits) > 6 {
+		return 0, false
+	}
+	l.readChar() // skip the }
+
+	code, _ := strconv.ParseUint(digits, 16, 32)
+	ch := rune(code)
+	if !utf8.ValidRune(ch) {
+		return 0, false
+	}
+	return ch, true
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
 }
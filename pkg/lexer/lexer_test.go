@@ -16,7 +16,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -160,3 +160,155 @@ bar"
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `
+
+  let x = 10;
+let s = "ab";
+x >= 5`
+
+	tests := []struct {
+		expectedType  token.TokenType
+		expectedStart token.Position
+		expectedEnd   token.Position
+	}{
+		{token.LET, token.Position{Offset: 4, Line: 3, Column: 3}, token.Position{Offset: 7, Line: 3, Column: 6}},
+		{token.IDENT, token.Position{Offset: 8, Line: 3, Column: 7}, token.Position{Offset: 9, Line: 3, Column: 8}},
+		{token.ASSIGN, token.Position{Offset: 10, Line: 3, Column: 9}, token.Position{Offset: 11, Line: 3, Column: 10}},
+		{token.INT, token.Position{Offset: 12, Line: 3, Column: 11}, token.Position{Offset: 14, Line: 3, Column: 13}},
+		{token.SEMICOLON, token.Position{Offset: 14, Line: 3, Column: 13}, token.Position{Offset: 15, Line: 3, Column: 14}},
+		{token.LET, token.Position{Offset: 16, Line: 4, Column: 1}, token.Position{Offset: 19, Line: 4, Column: 4}},
+		{token.IDENT, token.Position{Offset: 20, Line: 4, Column: 5}, token.Position{Offset: 21, Line: 4, Column: 6}},
+		{token.ASSIGN, token.Position{Offset: 22, Line: 4, Column: 7}, token.Position{Offset: 23, Line: 4, Column: 8}},
+		{token.STRING, token.Position{Offset: 24, Line: 4, Column: 9}, token.Position{Offset: 28, Line: 4, Column: 13}},
+		{token.SEMICOLON, token.Position{Offset: 28, Line: 4, Column: 13}, token.Position{Offset: 29, Line: 4, Column: 14}},
+		{token.IDENT, token.Position{Offset: 30, Line: 5, Column: 1}, token.Position{Offset: 31, Line: 5, Column: 2}},
+		{token.GTE, token.Position{Offset: 32, Line: 5, Column: 3}, token.Position{Offset: 34, Line: 5, Column: 5}},
+		{token.INT, token.Position{Offset: 35, Line: 5, Column: 6}, token.Position{Offset: 36, Line: 5, Column: 7}},
+		{token.EOF, token.Position{Offset: 36, Line: 5, Column: 7}, token.Position{Offset: 37, Line: 5, Column: 8}},
+	}
+
+	l := lexer.New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - incorrect token type. Expected = %q, got = %q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Start != tt.expectedStart {
+			t.Errorf("tests[%d] - incorrect start position. Expected = %+v, got = %+v",
+				i, tt.expectedStart, tok.Start)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - incorrect end position. Expected = %+v, got = %+v",
+				i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `# header
+let x = 1; # one
+/* block
+comment */ x / 2 // 3 /**/`
+
+	expectedTokens := []token.TokenType{
+		token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.SLASH, token.INT, token.DOUBLE_SLASH, token.INT, token.EOF,
+	}
+
+	l := lexer.New(input)
+
+	for i, expected := range expectedTokens {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("tests[%d] - incorrect token type. Expected = %q, got = %q", i, expected, tok.Type)
+		}
+	}
+
+	expectedComments := []struct {
+		literal string
+		start   token.Position
+	}{
+		{"# header", token.Position{Offset: 0, Line: 1, Column: 1}},
+		{"# one", token.Position{Offset: 20, Line: 2, Column: 12}},
+		{"/* block\ncomment */", token.Position{Offset: 26, Line: 3, Column: 1}},
+		{"/**/", token.Position{Offset: 57, Line: 4, Column: 23}},
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(expectedComments) {
+		t.Fatalf("wrong number of comments. expected = %d, got = %d", len(expectedComments), len(comments))
+	}
+
+	for i, tt := range expectedComments {
+		if comments[i].Type != token.COMMENT {
+			t.Errorf("comments[%d] - incorrect type. got = %q", i, comments[i].Type)
+		}
+		if comments[i].Literal != tt.literal {
+			t.Errorf("comments[%d] - incorrect literal. Expected = %q, got = %q", i, tt.literal, comments[i].Literal)
+		}
+		if comments[i].Start != tt.start {
+			t.Errorf("comments[%d] - incorrect start position. Expected = %+v, got = %+v", i, tt.start, comments[i].Start)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := lexer.New("x /* never closed")
+
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != "/*" {
+		t.Errorf("expected ILLEGAL /* token, got = %q %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := `let 名前 = "héllo\u{1F600}\u{41}"; naïve_x`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedStart   token.Position
+		expectedEnd     token.Position
+	}{
+		{token.LET, "let", token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, "名前", token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 10, Line: 1, Column: 7}},
+		{token.ASSIGN, "=", token.Position{Offset: 11, Line: 1, Column: 8}, token.Position{Offset: 12, Line: 1, Column: 9}},
+		{token.STRING, "héllo😀A", token.Position{Offset: 13, Line: 1, Column: 10}, token.Position{Offset: 36, Line: 1, Column: 32}},
+		{token.SEMICOLON, ";", token.Position{Offset: 36, Line: 1, Column: 32}, token.Position{Offset: 37, Line: 1, Column: 33}},
+		{token.IDENT, "naïve_x", token.Position{Offset: 38, Line: 1, Column: 34}, token.Position{Offset: 46, Line: 1, Column: 41}},
+		{token.EOF, "", token.Position{Offset: 46, Line: 1, Column: 41}, token.Position{Offset: 47, Line: 1, Column: 42}},
+	}
+
+	l := lexer.New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - incorrect token. Expected = %q %q, got = %q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+
+		if tok.Start != tt.expectedStart || tok.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - incorrect span. Expected = %+v %+v, got = %+v %+v",
+				i, tt.expectedStart, tt.expectedEnd, tok.Start, tok.End)
+		}
+	}
+}
+
+func TestInvalidUnicodeEscape(t *testing.T) {
+	for _, input := range []string{`"\u{110000}"`, `"\u{D800}"`, `"\u41"`, `"\u{}"`, `"\u{1234567}"`} {
+		tok := lexer.New(input).NextToken()
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("expected ILLEGAL token for %s, got = %q %q", input, tok.Type, tok.Literal)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package module
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Loader finds the source of the modules a program imports. Modules are
+// identified by the names Resolve returns, so the same module imported through
+// different relative paths is only loaded once.
+type Loader interface {
+	// Resolve returns the name of the module imported as importPath from the
+	// module named from, from is empty for code that is not in a module file
+	Resolve(from, importPath string) (string, error)
+	// Load returns the source of the module named name
+	Load(name string) (string, error)
+}
+
+// FileLoader loads modules from the filesystem, import paths are relative to
+// the directory of the importing file or to the working directory
+type FileLoader struct{}
+
+func NewFileLoader() *FileLoader {
+	return &FileLoader{}
+}
+
+func (fl *FileLoader) Resolve(from, importPath string) (string, error) {
+	if importPath == "" {
+		return "", fmt.Errorf("empty import path")
+	}
+	if filepath.IsAbs(importPath) {
+		return filepath.Clean(importPath), nil
+	}
+	return filepath.Join(filepath.Dir(from), importPath), nil
+}
+
+func (fl *FileLoader) Load(name string) (string, error) {
+	source, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return string(source), nil
+}
+
+// MemoryLoader serves modules added to it by name, for environments without a
+// filesystem like the playground
+type MemoryLoader struct {
+	files map[string]string
+}
+
+func NewMemoryLoader(files map[string]string) *MemoryLoader {
+	ml := &MemoryLoader{files: map[string]string{}}
+	for name, source := range files {
+		ml.Add(name, source)
+	}
+	return ml
+}
+
+// Add stores source as the module named name, replacing any previous one
+func (ml *MemoryLoader) Add(name, source string) {
+	ml.files[path.Clean("/"+name)] = source
+}
+
+func (ml *MemoryLoader) Resolve(from, importPath string) (string, error) {
+	if importPath == "" {
+		return "", fmt.Errorf("empty import path")
+	}
+	if path.IsAbs(importPath) {
+		return path.Clean(importPath), nil
+	}
+	return path.Join("/", path.Dir(from), importPath), nil
+}
+
+func (ml *MemoryLoader) Load(name string) (string, error) {
+	source, ok := ml.files[name]
+	if !ok {
+		return "", fmt.Errorf("module %s not found", name)
+	}
+	return source, nil
+}
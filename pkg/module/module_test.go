@@ -0,0 +1,93 @@
+package module_test
+
+import (
+	"github.com/JasirZaeem/ape/pkg/module"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLoader(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lib", "math.ape"), []byte("export let x = 1;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := module.NewFileLoader()
+
+	tests := []struct {
+		from     string
+		path     string
+		expected string
+	}{
+		{filepath.Join(dir, "main.ape"), "lib/math.ape", filepath.Join(dir, "lib", "math.ape")},
+		{filepath.Join(dir, "lib", "other.ape"), "./math.ape", filepath.Join(dir, "lib", "math.ape")},
+		{filepath.Join(dir, "lib", "other.ape"), "../lib/math.ape", filepath.Join(dir, "lib", "math.ape")},
+		{"", filepath.Join(dir, "lib", "math.ape"), filepath.Join(dir, "lib", "math.ape")},
+	}
+
+	for _, tt := range tests {
+		name, err := loader.Resolve(tt.from, tt.path)
+		if err != nil {
+			t.Fatalf("Resolve(%q, %q) returned error: %v", tt.from, tt.path, err)
+		}
+		if name != tt.expected {
+			t.Errorf("wrong name for %q from %q. expected = %q, got = %q", tt.path, tt.from, tt.expected, name)
+		}
+
+		source, err := loader.Load(name)
+		if err != nil {
+			t.Fatalf("Load(%q) returned error: %v", name, err)
+		}
+		if source != "export let x = 1;" {
+			t.Errorf("wrong source. got = %q", source)
+		}
+	}
+
+	if _, err := loader.Load(filepath.Join(dir, "missing.ape")); err == nil {
+		t.Errorf("expected error loading a missing file")
+	}
+}
+
+func TestMemoryLoader(t *testing.T) {
+	loader := module.NewMemoryLoader(map[string]string{
+		"main.ape":     "import \"lib/math.ape\" as m;",
+		"lib/math.ape": "export let x = 1;",
+	})
+
+	tests := []struct {
+		from     string
+		path     string
+		expected string
+	}{
+		{"", "lib/math.ape", "/lib/math.ape"},
+		{"/main.ape", "lib/math.ape", "/lib/math.ape"},
+		{"/lib/other.ape", "math.ape", "/lib/math.ape"},
+		{"/lib/other.ape", "/main.ape", "/main.ape"},
+	}
+
+	for _, tt := range tests {
+		name, err := loader.Resolve(tt.from, tt.path)
+		if err != nil {
+			t.Fatalf("Resolve(%q, %q) returned error: %v", tt.from, tt.path, err)
+		}
+		if name != tt.expected {
+			t.Errorf("wrong name for %q from %q. expected = %q, got = %q", tt.path, tt.from, tt.expected, name)
+		}
+		if _, err := loader.Load(name); err != nil {
+			t.Errorf("Load(%q) returned error: %v", name, err)
+		}
+	}
+
+	loader.Add("/extra.ape", "1")
+	if source, err := loader.Load("/extra.ape"); err != nil || source != "1" {
+		t.Errorf("added module not loaded. got = %q, %v", source, err)
+	}
+
+	if _, err := loader.Load("/missing.ape"); err == nil {
+		t.Errorf("expected error loading a missing module")
+	}
+}
@@ -1,14 +1,82 @@
 package object
 
+import "sort"
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	if outer != nil {
+		env.runtime = outer.runtime
+	}
+	return env
+}
+
+// NewScopeEnvironment creates the environment of a scope the resolver gave
+// slots to, names are the variables of the slots in order
+func NewScopeEnvironment(outer *Environment, names []string) *Environment {
+	env := &Environment{slots: make([]Object, len(names)), names: names, outer: outer}
+	if outer != nil {
+		env.runtime = outer.runtime
+	}
+	return env
+}
+
+// NewFunctionEnvironment creates the environment a function call is evaluated in
+func NewFunctionEnvironment(outer *Environment, frame *Frame, names []string) *Environment {
+	env := NewScopeEnvironment(outer, names)
+	env.frame = frame
+	return env
+}
+
+// NewModuleEnvironment creates the top level environment of module
+func NewModuleEnvironment(module *Module) *Environment {
+	env := NewEnvironment()
+	env.module = module
+	module.Env = env
 	return env
 }
 
+// NewEnclosedModuleEnvironment is NewModuleEnvironment for a module that
+// also sees the names bound in outer
+func NewEnclosedModuleEnvironment(module *Module, outer *Environment) *Environment {
+	env := NewModuleEnvironment(module)
+	env.outer = outer
+	return env
+}
+
+// Environment binds top level variables by name and the variables of other
+// scopes by slot, a slot is nil until its variable is bound. Names the
+// resolver did not see, like those of code it did not run on, go in store.
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	slots  []Object
+	names  []string
+	outer  *Environment
+	frame  *Frame  // set on environments created for function calls
+	module *Module // set on the top level environment of a module
+
+	runtime *Runtime
+}
+
+// Module returns the module code in this environment belongs to, nil if it
+// does not belong to one
+func (e *Environment) Module() *Module {
+	for env := e; env != nil; env = env.outer {
+		if env.module != nil {
+			return env.module
+		}
+	}
+	return nil
+}
+
+// Frame returns the call being evaluated in this environment, nil at the top level
+func (e *Environment) Frame() *Frame {
+	for env := e; env != nil; env = env.outer {
+		if env.frame != nil {
+			return env.frame
+		}
+	}
+	return nil
 }
 
 func NewEnvironment() *Environment {
@@ -16,26 +84,136 @@ func NewEnvironment() *Environment {
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+	for env := e; env != nil; env = env.outer {
+		if obj, ok := env.get(name); ok {
+			return obj, true
+		}
 	}
+	return nil, false
+}
+
+// get looks name up in e alone
+func (e *Environment) get(name string) (Object, bool) {
+	if slot := e.slot(name); slot >= 0 && e.slots[slot] != nil {
+		return e.slots[slot], true
+	}
+	obj, ok := e.store[name]
 	return obj, ok
 }
 
+func (e *Environment) slot(name string) int {
+	for i, n := range e.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetSlot returns the variable in slot of the environment depth scopes out,
+// nil if it is not bound
+func (e *Environment) GetSlot(depth, slot int) Object {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.outer
+	}
+	return env.slots[slot]
+}
+
+func (e *Environment) SetSlot(slot int, val Object) Object {
+	e.slots[slot] = val
+	return val
+}
+
+// AssignSlot changes the variable in slot of the environment depth scopes
+// out, it reports false if the variable is not bound
+func (e *Environment) AssignSlot(depth, slot int, val Object) bool {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.outer
+	}
+	if env.slots[slot] == nil {
+		return false
+	}
+	env.slots[slot] = val
+	return true
+}
+
+// Outer returns the enclosing environment, nil for a top level environment
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Names returns the names bound in this environment, not the ones of its
+// enclosing environments, in sorted order
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store)+len(e.names))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	for i, name := range e.names {
+		if e.slots[i] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (e *Environment) Set(name string, val Object) Object {
+	if slot := e.slot(name); slot >= 0 {
+		e.slots[slot] = val
+		return val
+	}
+	if e.store == nil {
+		e.store = map[string]Object{}
+	}
 	e.store[name] = val
 	return val
 }
 
 func (e *Environment) SetIfNameExists(name string, val Object) (Object, bool) {
-	_, ok := e.Get(name)
-	if ok {
-		e.store[name] = val
-		return val, true
+	for env := e; env != nil; env = env.outer {
+		if slot := env.slot(name); slot >= 0 && env.slots[slot] != nil {
+			env.slots[slot] = val
+			return val, true
+		}
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return val, true
+		}
 	}
-	if e.outer != nil {
-		return e.outer.SetIfNameExists(name, val)
+	return nil, false
+}
+
+// Scope looks up the names defined by a module, in an Environment for the
+// evaluator and in Globals for the virtual machine
+type Scope interface {
+	Get(name string) (Object, bool)
+}
+
+// Globals are the top level variables of a module run by the virtual
+// machine, they are indexed by the slots the compiler gave them
+type Globals struct {
+	Values []Object // nil for variables that are not bound yet
+	Names  []string
+	Module *Module
+}
+
+// NewModuleGlobals creates the globals of module
+func NewModuleGlobals(module *Module) *Globals {
+	globals := &Globals{Module: module}
+	if module != nil {
+		module.Env = globals
+	}
+	return globals
+}
+
+func (g *Globals) Get(name string) (Object, bool) {
+	for i, n := range g.Names {
+		if n == name && i < len(g.Values) && g.Values[i] != nil {
+			return g.Values[i], true
+		}
 	}
 	return nil, false
 }
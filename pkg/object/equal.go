@@ -0,0 +1,123 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// Equal compares values structurally, arrays and hashes are equal when their
+// elements are, integers and floats are equal when their values are
+func Equal(left, right Object) bool {
+	switch left := left.(type) {
+	case *Integer:
+		switch right := right.(type) {
+		case *Integer:
+			return left.Value == right.Value
+		case *BigInt:
+			return right.Value.IsInt64() && right.Value.Int64() == left.Value
+		case *Float:
+			return CompareIntegerFloat(left.Value, right.Value) == 0
+		}
+	case *BigInt:
+		switch right := right.(type) {
+		case *Integer, *BigInt:
+			r, _ := ToBig(right)
+			return left.Value.Cmp(r) == 0
+		case *Float:
+			return CompareBigFloat(left.Value, right.Value) == 0
+		}
+	case *Float:
+		switch right := right.(type) {
+		case *Integer:
+			return CompareIntegerFloat(right.Value, left.Value) == 0
+		case *BigInt:
+			return CompareBigFloat(right.Value, left.Value) == 0
+		case *Float:
+			return left.Value == right.Value
+		}
+	case *String:
+		if right, ok := right.(*String); ok {
+			return left.Value == right.Value
+		}
+	case *Boolean:
+		if right, ok := right.(*Boolean); ok {
+			return left.Value == right.Value
+		}
+	case *Null:
+		_, ok := right.(*Null)
+		return ok
+	case *Range:
+		if right, ok := right.(*Range); ok {
+			return *left == *right
+		}
+	case *Array:
+		right, ok := right.(*Array)
+		if !ok || len(left.Elements) != len(right.Elements) {
+			return false
+		}
+		for i := range left.Elements {
+			if !Equal(left.Elements[i], right.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		right, ok := right.(*Hash)
+		if !ok || left.Len() != right.Len() {
+			return false
+		}
+		// The order pairs were inserted in does not matter
+		for _, leftPair := range left.pairs {
+			if leftPair.Key == nil {
+				continue
+			}
+			rightPair, ok := right.Get(leftPair.Key.(Hashable))
+			if !ok || !Equal(leftPair.Value, rightPair.Value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return left == right
+}
+
+// CompareIntegerFloat returns -1, 0 or 1 as i is less than, equal to or
+// greater than f, and 2 if f is NaN. It does not lose precision on large
+// integers.
+func CompareIntegerFloat(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 2
+	case f >= math.MaxInt64: // the float closest to MaxInt64 is 2^63
+		return -1
+	case f < math.MinInt64:
+		return 1
+	}
+
+	whole := math.Trunc(f)
+	switch {
+	case i < int64(whole):
+		return -1
+	case i > int64(whole):
+		return 1
+	case f > whole:
+		return -1
+	case f < whole:
+		return 1
+	}
+	return 0
+}
+
+// CompareBigFloat is CompareIntegerFloat for big integers
+func CompareBigFloat(i *big.Int, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 2
+	case math.IsInf(f, 1):
+		return -1
+	case math.IsInf(f, -1):
+		return 1
+	}
+	return new(big.Float).SetInt(i).Cmp(big.NewFloat(f))
+}
@@ -0,0 +1,274 @@
+package object
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Hashable values can be keys of a Hash, values that are Equal must have the
+// same HashKey
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+// HashKey narrows down the keys a key can be equal to, different keys can
+// have the same HashKey so keys are compared once found
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+
+	if b.Value {
+		value = 1
+	} else {
+		value = 0
+	}
+
+	return HashKey{
+		Type:  b.Type(),
+		Value: value,
+	}
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+// HashKey of a float with a whole value is the one of the equal integer, as
+// 1 and 1.0 are the same key
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return (&Integer{Value: int64(f.Value)}).HashKey()
+	}
+	if math.IsNaN(f.Value) {
+		return HashKey{Type: f.Type(), Value: math.Float64bits(math.NaN())}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
+// HashKey of a big integer a float can hold exactly is the one of the equal
+// float
+func (b *BigInt) HashKey() HashKey {
+	if f, accuracy := new(big.Float).SetInt(b.Value).Float64(); accuracy == big.Exact {
+		return (&Float{Value: f}).HashKey()
+	}
+
+	h := fnv.New64a()
+	h.Write(b.Value.Bytes())
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
+
+func (n *Null) HashKey() HashKey {
+	return HashKey{Type: n.Type(), Value: 0}
+}
+
+// HashKey of an array combines the ones of its elements, elements that are
+// not hashable, like functions, only add their type
+func (a *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	for _, element := range a.Elements {
+		writeHashKey(h, element)
+	}
+
+	return HashKey{Type: a.Type(), Value: h.Sum64()}
+}
+
+// HashKey of a hash does not depend on the order of its pairs, as hashes with
+// the same pairs in another order are equal
+func (h *Hash) HashKey() HashKey {
+	var sum uint64
+	for _, pair := range h.pairs {
+		if pair.Key == nil {
+			continue
+		}
+		pairHash := fnv.New64a()
+		writeHashKey(pairHash, pair.Key)
+		writeHashKey(pairHash, pair.Value)
+		sum += pairHash.Sum64()
+	}
+
+	return HashKey{Type: h.Type(), Value: sum}
+}
+
+func writeHashKey(w interface{ Write([]byte) (int, error) }, obj Object) {
+	hashable, ok := obj.(Hashable)
+	if !ok {
+		w.Write([]byte(obj.Type()))
+		return
+	}
+
+	key := hashable.HashKey()
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], key.Value)
+	w.Write([]byte(key.Type))
+	w.Write(value[:])
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash keeps its pairs in the order their keys were first inserted. Keys are
+// looked up by HashKey and then compared with Equal, so keys with colliding
+// hash keys are kept apart.
+type Hash struct {
+	// Deleted pairs are left with a nil Key until the pairs are compacted
+	pairs   []HashPair
+	deleted int
+	buckets map[HashKey][]int // indexes in pairs of the keys with a HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{buckets: map[HashKey][]int{}}
+}
+
+func (h *Hash) Len() int { return len(h.pairs) - h.deleted }
+
+// index returns the position of key in pairs, -1 if it is not in the hash
+func (h *Hash) index(key Hashable) int {
+	for _, i := range h.buckets[key.HashKey()] {
+		// Identity covers keys not equal to themselves, like NaN
+		if h.pairs[i].Key == Object(key) || Equal(h.pairs[i].Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Hash) Get(key Hashable) (HashPair, bool) {
+	i := h.index(key)
+	if i == -1 {
+		return HashPair{}, false
+	}
+	return h.pairs[i], true
+}
+
+// Set sets the value of key, a key already in the hash keeps its position
+// and the key it was first set with
+func (h *Hash) Set(key Hashable, value Object) {
+	if i := h.index(key); i != -1 {
+		h.pairs[i].Value = value
+		return
+	}
+
+	hashKey := key.HashKey()
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+// Delete removes key from the hash, it reports whether the key was in it
+func (h *Hash) Delete(key Hashable) bool {
+	i := h.index(key)
+	if i == -1 {
+		return false
+	}
+
+	hashKey := key.HashKey()
+	bucket := h.buckets[hashKey]
+	for j, index := range bucket {
+		if index == i {
+			bucket[j] = bucket[len(bucket)-1]
+			bucket = bucket[:len(bucket)-1]
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(h.buckets, hashKey)
+	} else {
+		h.buckets[hashKey] = bucket
+	}
+
+	// The pair is left in place so the indexes of the others stay the same,
+	// until deleted pairs take up half of them
+	h.pairs[i] = HashPair{}
+	h.deleted++
+	if h.deleted > len(h.pairs)/2 {
+		h.compact()
+	}
+	return true
+}
+
+// compact removes the deleted pairs, moving the others down
+func (h *Hash) compact() {
+	pairs := make([]HashPair, 0, len(h.pairs)-h.deleted)
+	for _, pair := range h.pairs {
+		if pair.Key != nil {
+			pairs = append(pairs, pair)
+		}
+	}
+	h.pairs = pairs
+	h.deleted = 0
+
+	h.buckets = make(map[HashKey][]int, len(h.pairs))
+	for i, pair := range h.pairs {
+		hashKey := pair.Key.(Hashable).HashKey()
+		h.buckets[hashKey] = append(h.buckets[hashKey], i)
+	}
+}
+
+// OrderedPairs returns the pairs of the hash in insertion order, it must not
+// be modified
+func (h *Hash) OrderedPairs() []HashPair {
+	if h.deleted == 0 {
+		return h.pairs
+	}
+
+	// Reading a hash does not change it, so the deleted pairs are left out of
+	// a copy rather than compacted away
+	pairs := make([]HashPair, 0, h.Len())
+	for _, pair := range h.pairs {
+		if pair.Key != nil {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	var pairs []string
+	for _, pair := range h.pairs {
+		if pair.Key == nil {
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	}
+
+	out.WriteByte('{')
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteByte('}')
+
+	return out.String()
+}
+
+func DeepCopyHash(hash *Hash) *Hash {
+	copied := NewHash()
+
+	for _, pair := range hash.OrderedPairs() {
+		copied.Set(DeepCopy(pair.Key).(Hashable), DeepCopy(pair.Value))
+	}
+
+	return copied
+}
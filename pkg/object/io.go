@@ -0,0 +1,46 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// IO is where a program writes its output and reads its input, hosts give
+// their own to capture the output or to read the input some other way
+type IO struct {
+	Stdout io.Writer
+	// ReadLine shows prompt and returns the next line of input without its
+	// newline, io.EOF once there is no more input
+	ReadLine func(prompt string) (string, error)
+}
+
+// StandardIO is the IO of programs that were not given one
+var StandardIO = NewIO(os.Stdout, os.Stdin)
+
+// NewIO creates an IO writing to stdout and reading lines from stdin, the
+// prompts are written to stdout. stdin may be nil for programs without input.
+func NewIO(stdout io.Writer, stdin io.Reader) *IO {
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	reader := bufio.NewReader(stdin)
+
+	return &IO{
+		Stdout: stdout,
+		ReadLine: func(prompt string) (string, error) {
+			io.WriteString(stdout, prompt)
+			line, err := reader.ReadString('\n')
+			// The last line may not end with a newline
+			if err == io.EOF && line != "" {
+				err = nil
+			}
+			if err != nil {
+				return "", err
+			}
+			line = strings.TrimSuffix(line, "\n")
+			return strings.TrimSuffix(line, "\r"), nil
+		},
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/JasirZaeem/ape/pkg/ast"
-	"hash/fnv"
+	"github.com/JasirZaeem/ape/pkg/code"
+	"github.com/JasirZaeem/ape/pkg/token"
+	"math"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -14,16 +18,24 @@ type BuiltinFunction func(args ...Object) Object
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	BIGINT_OBJ       = "BIGINT"
 	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE_OBJ"
+	BREAK_OBJ        = "BREAK_OBJ"
+	CONTINUE_OBJ     = "CONTINUE_OBJ"
 	ERROR_OBJ        = "ERROR"
+	ERROR_VALUE_OBJ  = "ERROR_VALUE"
 	FUNCTION_OBJ     = "FUNCTION"
+	COMPILED_FN_OBJ  = "COMPILED_FUNCTION"
+	CELL_OBJ         = "CELL"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	RANGE_OBJ        = "RANGE"
+	MODULE_OBJ       = "MODULE"
 )
 
 type Object interface {
@@ -31,10 +43,6 @@ type Object interface {
 	Inspect() string
 }
 
-type Hashable interface {
-	HashKey() HashKey
-}
-
 type Integer struct {
 	Value int64
 }
@@ -42,12 +50,49 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return strconv.FormatInt(i.Value, 10) }
 
+// BigInt is an integer that does not fit in 64 bits, results of integer
+// operations that fit are always an Integer instead
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return BIGINT_OBJ }
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+
+// NewInteger returns value as an Integer if it fits in 64 bits, as a BigInt
+// otherwise
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInt{Value: value}
+}
+
+// ToBig returns the value of an Integer or a BigInt as a big.Int, it reports
+// false for other objects
+func ToBig(obj Object) (*big.Int, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value), true
+	case *BigInt:
+		return obj.Value, true
+	default:
+		return nil, false
+	}
+}
+
 type Float struct {
 	Value float64
 }
 
 func (f *Float) Type() ObjectType { return FLOAT_OBJ }
-func (f *Float) Inspect() string  { return strconv.FormatFloat(f.Value, 'f', -1, 64) }
+func (f *Float) Inspect() string {
+	// Written the way float() reads them back
+	if math.IsInf(f.Value, 1) {
+		return "Inf"
+	}
+	return strconv.FormatFloat(f.Value, 'f', -1, 64)
+}
 
 type Boolean struct {
 	Value bool
@@ -68,25 +113,165 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue signal the nearest enclosing loop, like ReturnValue they
+// are passed up through blocks and never seen by Ape code
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Kinds of runtime errors, caught errors expose them to Ape code
+const (
+	ERROR               = "Error"
+	TYPE_ERROR          = "TypeError"
+	NAME_ERROR          = "NameError"
+	ARGUMENT_ERROR      = "ArgumentError"
+	VALUE_ERROR         = "ValueError"
+	INDEX_ERROR         = "IndexError"
+	ZERO_DIVISION_ERROR = "ZeroDivisionError"
+	SYNTAX_ERROR        = "SyntaxError"
+	IMPORT_ERROR        = "ImportError"
+	IO_ERROR            = "IOError"
+	STEP_LIMIT_ERROR    = "StepLimitError"
+	RECURSION_ERROR     = "RecursionError"
+	MEMORY_ERROR        = "MemoryError"
+	TIMEOUT_ERROR       = "TimeoutError"
+	CANCELLED_ERROR     = "CancelledError"
+)
+
 type Error struct {
+	Kind    string // one of the kinds above, caught errors expose it to Ape code
 	Message string
+	Payload Object     // value given to throw, nil for runtime errors
+	Span    token.Span // innermost node that produced the error, zero if unknown
+	Module  string     // name of the module Span is in, empty outside of modules
+	Stack   []*Frame   // calls active when the error was raised, innermost first
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
+// Only this many frames of a deep stack are shown, split between the innermost
+// and outermost calls
+const (
+	tracebackHead = 10
+	tracebackTail = 5
+)
+
+// Traceback renders the error with its position and the call stack that led to it
+func (e *Error) Traceback() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.Inspect())
+	if e.Span.Start.Line > 0 {
+		fmt.Fprintf(&out, " (%d:%d)", e.Span.Start.Line, e.Span.Start.Column)
+	}
+	out.WriteString(e.StackTrace())
+
+	return out.String()
+}
+
+// StackTrace renders the call stack of the error, a line for each call
+// starting with a newline
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+
+	for i, frame := range e.Stack {
+		if len(e.Stack) > tracebackHead+tracebackTail && i >= tracebackHead && i < len(e.Stack)-tracebackTail {
+			if i == tracebackHead {
+				fmt.Fprintf(&out, "\n  ... %d more calls", len(e.Stack)-tracebackHead-tracebackTail)
+			}
+			continue
+		}
+		out.WriteString("\n  in ")
+		out.WriteString(frame.String())
+	}
+
+	return out.String()
+}
+
+// ErrorValue is an error caught by a try expression. Unlike Error, which
+// unwinds evaluation, it is an ordinary value that can be stored and inspected.
+type ErrorValue struct {
+	Error *Error
+}
+
+func (ev *ErrorValue) Type() ObjectType { return ERROR_VALUE_OBJ }
+func (ev *ErrorValue) Inspect() string {
+	return fmt.Sprintf("<%s: %s>", ev.Error.Kind, ev.Error.Message)
+}
+
+// Frame is a call to an Ape function that is being evaluated
+type Frame struct {
+	Function string
+	Call     token.Span // the call expression
+	Args     []Object
+	Caller   *Frame // frame the call was made from, nil at the top level
+}
+
+// Longer argument values are cut short in tracebacks
+const maxFrameArgLength = 32
+
+func (f *Frame) String() string {
+	var args []string
+	for _, arg := range f.Args {
+		var inspected string
+		switch arg := arg.(type) {
+		case *String:
+			inspected = strconv.Quote(arg.Value)
+		case *Function:
+			inspected = "fn"
+			if arg.Name != "" {
+				inspected = arg.Name
+			}
+		case *Closure:
+			inspected = "fn"
+			if arg.Fn.Name != "" {
+				inspected = arg.Fn.Name
+			}
+		default:
+			inspected = arg.Inspect()
+		}
+		if len(inspected) > maxFrameArgLength {
+			inspected = inspected[:maxFrameArgLength-3] + "..."
+		}
+		args = append(args, inspected)
+	}
+
+	return fmt.Sprintf("%s(%s) at %d:%d",
+		f.Function, strings.Join(args, ", "), f.Call.Start.Line, f.Call.Start.Column)
+}
+
+// Stack lists this frame and its callers, innermost first
+func (f *Frame) Stack() []*Frame {
+	var stack []*Frame
+	for frame := f; frame != nil; frame = frame.Caller {
+		stack = append(stack, frame)
+	}
+	return stack
+}
+
 type Function struct {
+	Name       string // empty for anonymous functions
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
+	Scope      *ast.Scope // nil if the resolver did not run on the function
 	Env        *Environment
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
-func (f *Function) Inspect() string {
+func (f *Function) Inspect() string  { return inspectFunction(f.Parameters, f.Body) }
+
+func inspectFunction(parameters []*ast.Identifier, body *ast.BlockStatement) string {
 	var out bytes.Buffer
 
 	var params []string
-	for _, p := range f.Parameters {
+	for _, p := range parameters {
 		params = append(params, p.String())
 	}
 
@@ -94,12 +279,74 @@ func (f *Function) Inspect() string {
 	out.WriteByte('(')
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 
 	return out.String()
 }
 
+// CompiledFunction is a function literal compiled to bytecode, the virtual
+// machine runs it as a Closure
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	Constants     []Object
+	Positions     []code.Position // sorted by offset
+	NumLocals     int
+	NumParameters int
+	Cells         []bool   // locals captured by closures, they are kept in a Cell
+	LocalNames    []string // for errors, empty for slots the compiler uses itself
+	FreeNames     []string
+	Name          string // empty for anonymous functions
+	Literal       *ast.FunctionLiteral
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FN_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("<compiled function %p>", cf)
+}
+
+// Position returns the position of the instruction at offset, the zero
+// Position if it was not recorded
+func (cf *CompiledFunction) Position(offset int) code.Position {
+	i := sort.Search(len(cf.Positions), func(i int) bool {
+		return cf.Positions[i].Offset > offset
+	})
+	if i == 0 {
+		return code.Position{}
+	}
+	return cf.Positions[i-1]
+}
+
+// Closure is a CompiledFunction with the variables it captured, it is the
+// virtual machine's Function
+type Closure struct {
+	Fn      *CompiledFunction
+	Free    []*Cell
+	Globals *Globals // of the module the function was defined in
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	if c.Fn.Literal == nil {
+		return "fn() {\n\n}"
+	}
+	return inspectFunction(c.Fn.Literal.Parameters, c.Fn.Literal.Body)
+}
+
+// Cell holds a local variable captured by a closure, so that the closure and
+// the function defining the variable see the same value
+type Cell struct {
+	Value Object // nil until the variable is bound
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	if c.Value == nil {
+		return "<unbound>"
+	}
+	return c.Value.Inspect()
+}
+
 type String struct {
 	Value string
 }
@@ -109,6 +356,18 @@ func (s *String) Inspect() string  { return s.Value }
 
 type Builtin struct {
 	Fn BuiltinFunction
+	// RuntimeFn is called instead of Fn if it is set, for builtins that use
+	// the IO of the program calling them or check its limits. rt is nil for
+	// programs without a runtime.
+	RuntimeFn func(rt *Runtime, args ...Object) Object
+}
+
+// Call calls the builtin for a program running with rt
+func (b *Builtin) Call(rt *Runtime, args ...Object) Object {
+	if b.RuntimeFn != nil {
+		return b.RuntimeFn(rt, args...)
+	}
+	return b.Fn(args...)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -134,60 +393,79 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
-type HashKey struct {
-	Type  ObjectType
-	Value uint64
+// Range is a lazy sequence of integers from Start up to, but excluding, Stop
+type Range struct {
+	Start int64
+	Stop  int64
+	Step  int64
 }
 
-func (b *Boolean) HashKey() HashKey {
-	var value uint64
-
-	if b.Value {
-		value = 1
-	} else {
-		value = 0
-	}
-
-	return HashKey{
-		Type:  b.Type(),
-		Value: value,
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.Stop)
 	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
 }
 
-func (i *Integer) HashKey() HashKey {
-	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+// Len is the number of integers the range produces, it is counted in uint64
+// as ranges from near the smallest to near the largest int64 have more
+// integers than an int64 can count, and their distances overflow it
+func (r *Range) Len() uint64 {
+	if r.Step > 0 && r.Start < r.Stop {
+		return (uint64(r.Stop)-uint64(r.Start)-1)/uint64(r.Step) + 1
+	}
+	if r.Step < 0 && r.Start > r.Stop {
+		return (uint64(r.Start)-uint64(r.Stop)-1)/(-uint64(r.Step)) + 1
+	}
+	return 0
 }
 
-func (s *String) HashKey() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(s.Value))
-
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+// At is the i-th integer of the range, i must be less than Len. It is worked
+// out in uint64 too, the integers of the range all fit in an int64 even when
+// the distance between them does not.
+func (r *Range) At(i uint64) int64 {
+	return int64(uint64(r.Start) + i*uint64(r.Step))
 }
 
-type HashPair struct {
-	Key   Object
-	Value Object
+// Importer loads the module imported as path by code in the module named from
+type Importer interface {
+	Import(from, path string) (*Module, *Error)
 }
 
-type Hash struct {
-	Pairs map[HashKey]HashPair
+// Module is an evaluated source file, only the names it exports can be read
+// from other modules
+type Module struct {
+	Name     string
+	Env      Scope    // names defined by the module
+	Exports  []string // in the order they were exported
+	Importer Importer // loads the modules this one imports
 }
 
-func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return fmt.Sprintf("<module %s>", m.Name) }
 
-	var pairs []string
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+func (m *Module) Export(name string) {
+	if !m.IsExported(name) {
+		m.Exports = append(m.Exports, name)
 	}
+}
 
-	out.WriteByte('{')
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteByte('}')
+func (m *Module) IsExported(name string) bool {
+	for _, export := range m.Exports {
+		if export == name {
+			return true
+		}
+	}
+	return false
+}
 
-	return out.String()
+// Get returns the current value of an exported name
+func (m *Module) Get(name string) (Object, bool) {
+	if !m.IsExported(name) {
+		return nil, false
+	}
+	return m.Env.Get(name)
 }
 
 func DeepCopy(obj Object) Object {
@@ -215,16 +493,3 @@ func DeepCopyArrayInto(dst, src []Object) {
 		dst[i] = DeepCopy(e)
 	}
 }
-
-func DeepCopyHash(hash *Hash) *Hash {
-	pairs := make(map[HashKey]HashPair)
-
-	for k, v := range hash.Pairs {
-		pairs[k] = HashPair{
-			Key:   DeepCopy(v.Key),
-			Value: DeepCopy(v.Value),
-		}
-	}
-
-	return &Hash{Pairs: pairs}
-}
@@ -2,6 +2,8 @@ package object_test
 
 import (
 	"github.com/JasirZaeem/ape/pkg/object"
+	"math"
+	"math/big"
 	"testing"
 )
 
@@ -23,3 +25,161 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestHashOrder(t *testing.T) {
+	hash := object.NewHash()
+	for _, key := range []string{"c", "a", "b", "a"} {
+		k := &object.String{Value: key}
+		hash.Set(k, k)
+	}
+	hash.Delete(&object.String{Value: "c"})
+	hash.Delete(&object.String{Value: "missing"})
+	d := &object.String{Value: "c"}
+	hash.Set(d, d)
+
+	if hash.Inspect() != "{a: a, b: b, c: c}" {
+		t.Errorf("hash.Inspect() wrong. got = %q", hash.Inspect())
+	}
+
+	copied := object.DeepCopyHash(hash)
+	if copied.Inspect() != hash.Inspect() {
+		t.Errorf("copy has a different order. want = %q, got = %q", hash.Inspect(), copied.Inspect())
+	}
+}
+
+func TestHashDelete(t *testing.T) {
+	hash := object.NewHash()
+	for i := int64(0); i < 1000; i++ {
+		hash.Set(&object.Integer{Value: i}, &object.Integer{Value: i * 2})
+	}
+	// Deleting the first pairs leaves them in place for a while, until the
+	// hash is compacted
+	for i := int64(0); i < 990; i++ {
+		if !hash.Delete(&object.Integer{Value: i}) {
+			t.Fatalf("key %d not deleted", i)
+		}
+		if hash.Len() != int(999-i) {
+			t.Fatalf("wrong length after deleting %d. got = %d", i, hash.Len())
+		}
+		if pair, ok := hash.Get(&object.Integer{Value: i + 1}); !ok || pair.Value.(*object.Integer).Value != (i+1)*2 {
+			t.Fatalf("key %d lost after deleting %d", i+1, i)
+		}
+	}
+	if hash.Delete(&object.Integer{Value: 0}) {
+		t.Errorf("deleted key deleted again")
+	}
+
+	hash.Set(&object.Integer{Value: 0}, &object.Integer{Value: 0})
+	expected := "{990: 1980, 991: 1982, 992: 1984, 993: 1986, 994: 1988, 995: 1990, 996: 1992, 997: 1994, 998: 1996, 999: 1998, 0: 0}"
+	if hash.Inspect() != expected {
+		t.Errorf("hash.Inspect() wrong. got = %q", hash.Inspect())
+	}
+	if len(hash.OrderedPairs()) != hash.Len() {
+		t.Errorf("wrong number of ordered pairs. want = %d, got = %d", hash.Len(), len(hash.OrderedPairs()))
+	}
+}
+
+// collidingKey always has the same hash key, it is only equal to itself
+type collidingKey struct{ name string }
+
+func (c *collidingKey) Type() object.ObjectType { return "COLLIDING" }
+func (c *collidingKey) Inspect() string         { return c.name }
+func (c *collidingKey) HashKey() object.HashKey {
+	return object.HashKey{Type: "COLLIDING", Value: 1}
+}
+
+func TestHashKeyCollisions(t *testing.T) {
+	a, b, c := &collidingKey{"a"}, &collidingKey{"b"}, &collidingKey{"c"}
+
+	hash := object.NewHash()
+	hash.Set(a, &object.Integer{Value: 1})
+	hash.Set(b, &object.Integer{Value: 2})
+	hash.Set(c, &object.Integer{Value: 3})
+	hash.Delete(a)
+
+	if _, ok := hash.Get(a); ok {
+		t.Errorf("deleted key still in hash")
+	}
+	for key, expected := range map[*collidingKey]int64{b: 2, c: 3} {
+		pair, ok := hash.Get(key)
+		if !ok {
+			t.Errorf("key %s not in hash", key.name)
+			continue
+		}
+		if pair.Value.(*object.Integer).Value != expected {
+			t.Errorf("key %s has wrong value. want = %d, got = %s", key.name, expected, pair.Value.Inspect())
+		}
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	array := func(elements ...object.Object) *object.Array {
+		return &object.Array{Elements: elements}
+	}
+	one, two := &object.Integer{Value: 1}, &object.Integer{Value: 2}
+	hashOf := func(pairs ...object.Hashable) *object.Hash {
+		hash := object.NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			hash.Set(pairs[i], pairs[i+1])
+		}
+		return hash
+	}
+
+	equal := []struct {
+		a, b object.Hashable
+	}{
+		{array(one, two), array(&object.Integer{Value: 1}, &object.Integer{Value: 2})},
+		{array(one, array(two)), array(one, array(&object.Float{Value: 2}))},
+		{&object.Float{Value: 1}, one},
+		{hashOf(one, two, two, one), hashOf(two, one, one, two)},
+		{&object.Null{}, &object.Null{}},
+	}
+	for _, tt := range equal {
+		if tt.a.HashKey() != tt.b.HashKey() {
+			t.Errorf("equal keys %s and %s have different hash keys", tt.a.Inspect(), tt.b.Inspect())
+		}
+	}
+
+	different := []struct {
+		a, b object.Hashable
+	}{
+		{array(one, two), array(two, one)},
+		{array(one), array(one, one)},
+		{&object.Float{Value: 1.5}, one},
+		{hashOf(one, two), hashOf(two, one)},
+		{array(), hashOf()},
+	}
+	for _, tt := range different {
+		if tt.a.HashKey() == tt.b.HashKey() {
+			t.Errorf("different keys %s and %s have the same hash key", tt.a.Inspect(), tt.b.Inspect())
+		}
+	}
+}
+
+func TestBigIntHashKey(t *testing.T) {
+	big1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	big2, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	negative := new(big.Int).Neg(big1)
+
+	if (&object.BigInt{Value: big1}).HashKey() != (&object.BigInt{Value: big2}).HashKey() {
+		t.Errorf("equal big integers have different hash keys")
+	}
+	if (&object.BigInt{Value: big1}).HashKey() == (&object.BigInt{Value: negative}).HashKey() {
+		t.Errorf("big integers of different sign have the same hash key")
+	}
+
+	power := new(big.Int).Lsh(big.NewInt(1), 70)
+	float := &object.Float{Value: math.Ldexp(1, 70)}
+	if (&object.BigInt{Value: power}).HashKey() != float.HashKey() {
+		t.Errorf("big integer and equal float have different hash keys")
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	if _, ok := object.NewInteger(big.NewInt(5)).(*object.Integer); !ok {
+		t.Errorf("small value not demoted to Integer")
+	}
+	if _, ok := object.NewInteger(new(big.Int).Lsh(big.NewInt(1), 63)).(*object.BigInt); !ok {
+		t.Errorf("value over 64 bits not kept as BigInt")
+	}
+}
@@ -0,0 +1,44 @@
+package object
+
+import "context"
+
+// Limits cap what evaluating a program may use, a zero field is no limit
+type Limits struct {
+	MaxSteps int // nodes evaluated
+	MaxDepth int // nested function calls
+	// MaxHeap approximates in bytes the strings, arrays and hashes created,
+	// memory taken is not given back when they are no longer used
+	MaxHeap int64
+}
+
+// Runtime is the state of one evaluation of a program, shared by the
+// environments it creates. Environments of function calls get the Runtime of
+// the caller rather than the one of the environment the function was defined
+// in, so a function runs under the limits of the code calling it.
+type Runtime struct {
+	Context context.Context // nil if the evaluation can not be cancelled
+	Limits  Limits
+	IO      *IO // nil for StandardIO
+	Steps   int
+	Depth   int
+	Heap    int64
+}
+
+// Streams returns the IO of the evaluation, StandardIO if rt is nil or was
+// not given one
+func (rt *Runtime) Streams() *IO {
+	if rt == nil || rt.IO == nil {
+		return StandardIO
+	}
+	return rt.IO
+}
+
+// Runtime returns the runtime of the evaluation the environment was created
+// by, nil if it was not given one
+func (e *Environment) Runtime() *Runtime {
+	return e.runtime
+}
+
+func (e *Environment) SetRuntime(runtime *Runtime) {
+	e.runtime = runtime
+}
@@ -3,9 +3,12 @@ package parser
 import (
 	"fmt"
 	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
 	"github.com/JasirZaeem/ape/pkg/lexer"
 	"github.com/JasirZaeem/ape/pkg/token"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 type (
@@ -14,16 +17,38 @@ type (
 )
 
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l           *lexer.Lexer
+	diagnostics []diagnostic.Diagnostic
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// Error recovery, after an error further ones are suppressed until the
+	// parser resynchronizes at a statement boundary. depth counts the braces
+	// open at curToken and stmtDepth is the depth of the enclosing block.
+	panicking bool
+	depth     int
+	stmtDepth int
+
+	// Loops enclosing curToken within the current function, break and
+	// continue are only valid when it is not zero
+	loopDepth int
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
 
+// Diagnostic codes reported by the parser
+const (
+	UNEXPECTED_TOKEN   diagnostic.Code = "P001"
+	NO_PREFIX_PARSE_FN diagnostic.Code = "P002"
+	INVALID_INTEGER    diagnostic.Code = "P003"
+	INVALID_FLOAT      diagnostic.Code = "P004"
+	MISSING_HANDLER    diagnostic.Code = "P005"
+	OUTSIDE_LOOP       diagnostic.Code = "P006"
+	NESTED_EXPORT      diagnostic.Code = "P007"
+)
+
 const (
 	_ int = iota
 	LOWEST
@@ -71,7 +96,7 @@ var precedences = map[token.TokenType]int{
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, diagnostics: []diagnostic.Diagnostic{}}
 
 	p.prefixParseFns = map[token.TokenType]prefixParseFn{}
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
@@ -86,6 +111,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.WHILE, p.parseWhileExpression)
+	p.registerPrefix(token.FOR, p.parseForExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
@@ -126,6 +153,13 @@ func New(l *lexer.Lexer) *Parser {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		p.depth--
+	}
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -145,9 +179,20 @@ func (p *Parser) ParseProgram() *ast.Program {
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
+		if p.panicking {
+			p.synchronize()
+		}
+		// A stray closing brace has nothing to close at the top level
+		if p.depth < 0 {
+			p.depth = 0
+		}
 		p.nextToken()
 	}
 
+	for _, comment := range p.l.Comments() {
+		program.Comments = append(program.Comments, &ast.Comment{Token: comment})
+	}
+
 	return program
 }
 
@@ -157,6 +202,16 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
 	case token.EMPTY_LINE:
 		return p.parseEmptyStatement()
 	default:
@@ -164,11 +219,11 @@ func (p *Parser) parseStatement() ast.Statement {
 	}
 }
 
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
 	if !p.expectPeek(token.IDENT) {
-		return nil
+		return p.badStatement(stmt.Token.Start)
 	}
 
 	stmt.Name = &ast.Identifier{
@@ -177,13 +232,17 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 
 	if !p.expectPeek(token.ASSIGN) {
-		return nil
+		return p.badStatement(stmt.Token.Start)
 	}
 
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if function, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		function.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -205,6 +264,102 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseImportStatement() ast.Statement {
+	stmt := &ast.ImportStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.STRING) {
+		return p.badStatement(stmt.Token.Start)
+	}
+	stmt.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.AS) {
+		return p.badStatement(stmt.Token.Start)
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return p.badStatement(stmt.Token.Start)
+	}
+	stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseExportStatement() ast.Statement {
+	stmt := &ast.ExportStatement{Token: p.curToken}
+
+	if p.depth > 0 {
+		p.report(stmt.Token.Span(), NESTED_EXPORT, "move the export out of the block",
+			"export is only allowed at the top level of a module")
+	}
+
+	if !p.expectPeek(token.LET) {
+		return p.badStatement(stmt.Token.Start)
+	}
+
+	switch let := p.parseLetStatement().(type) {
+	case *ast.LetStatement:
+		stmt.Statement = let
+	case *ast.BadStatement:
+		// The broken code starts at export, not at let
+		let.From = stmt.Token.Start
+		let.Source = p.l.Slice(token.Span{Start: let.From, End: let.To})
+		return let
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+	p.checkInLoop()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+	p.checkInLoop()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// checkInLoop reports break or continue at curToken used outside a loop, the
+// statement itself is well formed so the parser does not need to recover
+func (p *Parser) checkInLoop() {
+	if p.loopDepth > 0 || p.panicking {
+		return
+	}
+
+	p.report(p.curToken.Span(), OUTSIDE_LOOP, "break and continue can only be used inside while and for loops",
+		"%s outside of a loop", p.curToken.Literal)
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{
 		Token:      p.curToken,
@@ -220,6 +375,11 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken, Statements: []ast.Statement{}}
+
+	outerDepth := p.stmtDepth
+	p.stmtDepth = p.depth
+	defer func() { p.stmtDepth = outerDepth }()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
@@ -227,8 +387,21 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if p.panicking {
+			p.synchronize()
+		}
+		// Recovery stopped on the brace that closes this block
+		if p.depth < p.stmtDepth {
+			break
+		}
 		p.nextToken()
 	}
+	block.EndToken = p.curToken
+
+	if p.curTokenIs(token.EOF) {
+		p.error(p.curToken.Span(), UNEXPECTED_TOKEN, "check for a missing }",
+			"expected next token to be }, got EOF")
+	}
 
 	return block
 }
@@ -244,11 +417,11 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		return p.badExpression(p.curToken.Start)
 	}
 	leftExp := prefix()
 
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+	for !p.panicking && !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp
@@ -271,8 +444,13 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		p.errors = append(p.errors, fmt.Sprintf("cout not parse %q as integer", p.curToken.Literal))
-		return nil
+		// Literals too large for 64 bits are big integers
+		if value, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			return &ast.IntegerLiteral{Token: p.curToken, Big: value}
+		}
+		p.error(p.curToken.Span(), INVALID_INTEGER, "",
+			"could not parse %q as integer", p.curToken.Literal)
+		return p.badExpression(p.curToken.Start)
 	}
 
 	return &ast.IntegerLiteral{Token: p.curToken, Value: value}
@@ -281,8 +459,9 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 func (p *Parser) parseFloatLiteral() ast.Expression {
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		p.errors = append(p.errors, fmt.Sprintf("cout not parse %q as float", p.curToken.Literal))
-		return nil
+		p.error(p.curToken.Span(), INVALID_FLOAT, "",
+			"could not parse %q as float", p.curToken.Literal)
+		return p.badExpression(p.curToken.Start)
 	}
 
 	return &ast.FloatLiteral{Token: p.curToken, Value: value}
@@ -296,12 +475,13 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	start := p.curToken.Start
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(start)
 	}
 
 	return exp
@@ -336,18 +516,18 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(expression.Token.Start)
 	}
 
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(expression.Token.Start)
 	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(expression.Token.Start)
 	}
 
 	expression.Consequence = p.parseBlockStatement()
@@ -356,7 +536,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		p.nextToken()
 
 		if !p.expectPeek(token.LBRACE) {
-			return nil
+			return p.badExpression(expression.Token.Start)
 		}
 
 		expression.Alternative = p.parseBlockStatement()
@@ -369,21 +549,118 @@ func (p *Parser) parseWhileExpression() ast.Expression {
 	expression := &ast.WhileExpression{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(expression.Token.Start)
 	}
 
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(expression.Token.Start)
 	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(expression.Token.Start)
+	}
+
+	p.loopDepth++
+	expression.Body = p.parseBlockStatement()
+	p.loopDepth--
+
+	return expression
+}
+
+func (p *Parser) parseForExpression() ast.Expression {
+	expression := &ast.ForExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return p.badExpression(expression.Token.Start)
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return p.badExpression(expression.Token.Start)
+	}
+	expression.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+
+		if !p.expectPeek(token.IDENT) {
+			return p.badExpression(expression.Token.Start)
+		}
+		expression.Key = expression.Value
+		expression.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(token.IN) {
+		return p.badExpression(expression.Token.Start)
+	}
+
+	p.nextToken()
+	expression.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return p.badExpression(expression.Token.Start)
 	}
 
+	if !p.expectPeek(token.LBRACE) {
+		return p.badExpression(expression.Token.Start)
+	}
+
+	p.loopDepth++
 	expression.Body = p.parseBlockStatement()
+	p.loopDepth--
+
+	return expression
+}
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return p.badExpression(expression.Token.Start)
+	}
+
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+
+			if !p.expectPeek(token.IDENT) {
+				return p.badExpression(expression.Token.Start)
+			}
+			expression.Parameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+			if !p.expectPeek(token.RPAREN) {
+				return p.badExpression(expression.Token.Start)
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return p.badExpression(expression.Token.Start)
+		}
+
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return p.badExpression(expression.Token.Start)
+		}
+
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.error(p.peekToken.Span(), MISSING_HANDLER, "add a catch or finally block",
+			"expected catch or finally after try block, got %s", p.peekToken.Type)
+		return p.badExpression(expression.Token.Start)
+	}
 
 	return expression
 }
@@ -391,16 +668,23 @@ func (p *Parser) parseWhileExpression() ast.Expression {
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(lit.Token.Start)
 	}
 
 	lit.Parameters = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return p.badExpression(lit.Token.Start)
+	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(lit.Token.Start)
 	}
 
+	// Loops outside a function can not be broken out of from inside it
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
 
 	return lit
 }
@@ -433,11 +717,15 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	return &ast.CallExpression{
-		Token:     p.curToken,
-		Function:  function,
-		Arguments: p.parseExpressionList(token.RPAREN),
+	call := &ast.CallExpression{Token: p.curToken, Function: function}
+
+	call.Arguments = p.parseExpressionList(token.RPAREN)
+	if call.Arguments == nil {
+		return p.badExpression(call.Span().Start)
 	}
+	call.EndToken = p.curToken
+
+	return call
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -474,16 +762,137 @@ func (p *Parser) peekPrecedence() int {
 	return LOWEST
 }
 
+// Diagnostics returns every problem found while parsing, in the order they were found
+func (p *Parser) Diagnostics() []diagnostic.Diagnostic {
+	return p.diagnostics
+}
+
+// Errors returns the messages of the diagnostics, kept for callers that only need text
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := make([]string, 0, len(p.diagnostics))
+	for _, d := range p.diagnostics {
+		errors = append(errors, d.Message)
+	}
+	return errors
+}
+
+func (p *Parser) error(span token.Span, code diagnostic.Code, hint string, format string, a ...interface{}) {
+	// Errors following the first one in a statement are usually caused by it
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+
+	p.report(span, code, hint, format, a...)
+}
+
+func (p *Parser) report(span token.Span, code diagnostic.Code, hint string, format string, a ...interface{}) {
+	p.diagnostics = append(p.diagnostics, diagnostic.Diagnostic{
+		Severity: diagnostic.ERROR,
+		Span:     span,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
 }
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	hint := ""
+	switch t {
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		hint = fmt.Sprintf("check for a missing %s", t)
+	}
+	p.error(p.peekToken.Span(), UNEXPECTED_TOKEN, hint,
+		"expected next token to be %s, got %s", t, p.peekToken.Type)
+}
+
+// atSyncPoint reports whether the current token already ends the statement
+// being parsed, so recovery has nothing to skip
+func (p *Parser) atSyncPoint() bool {
+	switch p.curToken.Type {
+	case token.SEMICOLON, token.EMPTY_LINE, token.EOF:
+		return true
+	case token.RBRACE:
+		// A brace that closes nothing is skipped like any other stray token
+		return p.depth >= 0
+	default:
+		return false
+	}
+}
+
+// skipToSync advances until the next token starts a new statement or closes
+// the enclosing block. Anything nested in braces is stepped over, and a
+// braced construct like a block or hash ends the skip once it is closed.
+func (p *Parser) skipToSync() {
+	for !p.peekTokenIs(token.EOF) && p.depth >= p.stmtDepth {
+		if p.depth == p.stmtDepth {
+			switch p.peekToken.Type {
+			case token.SEMICOLON, token.RBRACE, token.LET, token.RETURN, token.EMPTY_LINE:
+				return
+			}
+		}
+		p.nextToken()
+		if p.curTokenIs(token.RBRACE) && p.depth == p.stmtDepth {
+			return
+		}
+	}
+}
+
+// synchronize ends panic mode after a broken statement, leaving the parser on
+// the last token of the statement like a successful parse would
+func (p *Parser) synchronize() {
+	p.panicking = false
+	if p.atSyncPoint() {
+		return
+	}
+
+	p.skipToSync()
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+}
+
+// badExpression skips the rest of a broken expression that started at from
+// and returns a placeholder covering its source
+func (p *Parser) badExpression(from token.Position) ast.Expression {
+	bad := &ast.BadExpression{Token: p.curToken, From: from}
+	bad.To, bad.Source = p.skipBroken(from)
+	return bad
+}
+
+// badStatement skips the rest of a broken statement that started at from
+// and returns a placeholder covering its source
+func (p *Parser) badStatement(from token.Position) ast.Statement {
+	bad := &ast.BadStatement{Token: p.curToken, From: from}
+	bad.To, bad.Source = p.skipBroken(from)
+	return bad
+}
+
+func (p *Parser) skipBroken(from token.Position) (token.Position, string) {
+	to := p.curToken.Start
+	if p.curTokenIs(token.RBRACE) && p.depth >= p.stmtDepth {
+		// The brace closes something inside the broken code, not the enclosing block
+		to = p.curToken.End
+	} else if !p.atSyncPoint() {
+		p.skipToSync()
+		to = p.curToken.End
+	}
+	if to.Offset < from.Offset {
+		to = from
+	}
+	return to, p.l.Slice(token.Span{Start: from, End: to})
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	p.errors = append(p.errors, fmt.Sprintf("no prefix parser function for %s found", t))
+	hint := ""
+	if t == token.EOF {
+		hint = "the input ended before the expression was complete"
+	} else if t == token.ILLEGAL && p.curToken.Literal == "/*" {
+		hint = "block comment is missing its closing */"
+	} else if t == token.ILLEGAL && strings.HasPrefix(p.curToken.Literal, "\"") {
+		hint = "\\u{...} escapes take 1 to 6 hex digits naming a valid code point"
+	}
+	p.error(p.curToken.Span(), NO_PREFIX_PARSE_FN, hint, "no prefix parser function for %s found", t)
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
@@ -497,6 +906,10 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if array.Elements == nil {
+		return p.badExpression(array.Token.Start)
+	}
+	array.EndToken = p.curToken
 
 	return array
 }
@@ -533,36 +946,38 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp.Index = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RBRACKET) {
-		return nil
+		return p.badExpression(exp.Span().Start)
 	}
+	exp.EndToken = p.curToken
 
 	return exp
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
-	hash := &ast.HashLiteral{Token: p.curToken, Pairs: map[ast.Expression]ast.Expression{}}
+	hash := &ast.HashLiteral{Token: p.curToken, Pairs: []ast.HashPair{}}
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
 		key := p.parseExpression(LOWEST)
 
 		if !p.expectPeek(token.COLON) {
-			return nil
+			return p.badExpression(hash.Token.Start)
 		}
 
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
-			return nil
+			return p.badExpression(hash.Token.Start)
 		}
 	}
 
 	if !p.expectPeek(token.RBRACE) {
-		return nil
+		return p.badExpression(hash.Token.Start)
 	}
+	hash.EndToken = p.curToken
 
 	return hash
 }
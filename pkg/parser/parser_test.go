@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
 	"github.com/JasirZaeem/ape/pkg/lexer"
 	"github.com/JasirZaeem/ape/pkg/parser"
 )
@@ -170,6 +171,24 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	testIntegerLiteral(t, stmt.Expression, 42)
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := "99999999999999999999;"
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	integer, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.IntegerLiteral. got = %T", stmt.Expression)
+	}
+	if integer.Big == nil || integer.Big.String() != "99999999999999999999" {
+		t.Errorf("integer.Big not 99999999999999999999. got = %v", integer.Big)
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	input := "true;"
 
@@ -801,16 +820,21 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	expected := map[string]int64{"one": 1,
-		"two":   2,
-		"three": 3}
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	// Pairs are kept in source order
+	expected := []struct {
+		key   string
+		value int64
+	}{{"one", 1}, {"two", 2}, {"three", 3}}
+	for i, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
+			continue
 		}
-		expectedValue := expected[literal.String()]
-		testIntegerLiteral(t, value, expectedValue)
+		if literal.String() != expected[i].key {
+			t.Errorf("key %d wrong. want = %q, got = %q", i, expected[i].key, literal.String())
+		}
+		testIntegerLiteral(t, pair.Value, expected[i].value)
 	}
 }
 
@@ -859,7 +883,8 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			testInfixExpression(t, e, 15, "/", 5)
 		},
 	}
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -873,3 +898,349 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStart int
+		expectedEnd   int
+	}{
+		{"a + b * c", 0, 9},
+		{"-x", 0, 2},
+		{"add(1, 2)", 0, 9},
+		{"arr[1 + 2]", 0, 10},
+		{"[1, 2, 3]", 0, 9},
+		{`{"a": 1}`, 0, 8},
+		{"fn(x) { x }", 0, 11},
+		{"if (x) { 1 } else { 2 }", 0, 23},
+		{"while (x) { x = x - 1 }", 0, 23},
+		{"let x = 5 * 5;", 0, 13},
+		{"return add(1);", 0, 13},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		span := program.Statements[0].Span()
+		if span.Start.Offset != tt.expectedStart || span.End.Offset != tt.expectedEnd {
+			t.Errorf("wrong span for %q. expected = [%d, %d), got = [%d, %d)",
+				tt.input, tt.expectedStart, tt.expectedEnd, span.Start.Offset, span.End.Offset)
+		}
+	}
+}
+
+func TestParserDiagnostics(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedCode    diagnostic.Code
+		expectedLine    int
+		expectedColumn  int
+		expectedMessage string
+	}{
+		{"let = 5;", parser.UNEXPECTED_TOKEN, 1, 5, "expected next token to be IDENT, got ="},
+		{"let x = 5;\nadd(1, 2", parser.UNEXPECTED_TOKEN, 2, 9, "expected next token to be ), got EOF"},
+		{"let x = 5;\n  * 2", parser.NO_PREFIX_PARSE_FN, 2, 3, "no prefix parser function for * found"},
+		{"09", parser.INVALID_INTEGER, 1, 1, `could not parse "09" as integer`},
+		{"try { 1 };", parser.MISSING_HANDLER, 1, 10, "expected catch or finally after try block, got ;"},
+		{"let x = 1;\nbreak;", parser.OUTSIDE_LOOP, 2, 1, "break outside of a loop"},
+		{"while (x) { fn() { continue } }", parser.OUTSIDE_LOOP, 1, 20, "continue outside of a loop"},
+		{"if (x) { export let y = 1; }", parser.NESTED_EXPORT, 1, 10, "export is only allowed at the top level of a module"},
+		{`import "lib.ape" lib;`, parser.UNEXPECTED_TOKEN, 1, 18, "expected next token to be AS, got IDENT"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) == 0 {
+			t.Errorf("expected diagnostics for %q, got none", tt.input)
+			continue
+		}
+
+		d := diagnostics[0]
+		if d.Severity != diagnostic.ERROR {
+			t.Errorf("wrong severity. expected = %s, got = %s", diagnostic.ERROR, d.Severity)
+		}
+		if d.Code != tt.expectedCode {
+			t.Errorf("wrong code. expected = %s, got = %s", tt.expectedCode, d.Code)
+		}
+		if d.Span.Start.Line != tt.expectedLine || d.Span.Start.Column != tt.expectedColumn {
+			t.Errorf("wrong position. expected = %d:%d, got = %d:%d",
+				tt.expectedLine, tt.expectedColumn, d.Span.Start.Line, d.Span.Start.Column)
+		}
+		if d.Message != tt.expectedMessage {
+			t.Errorf("wrong message. expected = %q, got = %q", tt.expectedMessage, d.Message)
+		}
+		if p.Errors()[0] != tt.expectedMessage {
+			t.Errorf("Errors() does not wrap diagnostics. expected = %q, got = %q", tt.expectedMessage, p.Errors()[0])
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `let = 5;
+let y = ;
+let f = fn(a) { let = 1; a };
+if (x { a; }
+let z = 10;`
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	expectedErrors := []string{
+		"1:5: error[P001]: expected next token to be IDENT, got =",
+		"2:9: error[P002]: no prefix parser function for ; found",
+		"3:21: error[P001]: expected next token to be IDENT, got =",
+		"4:7: error[P001]: expected next token to be ), got {",
+	}
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(expectedErrors) {
+		t.Errorf("expected %d diagnostics, got = %d", len(expectedErrors), len(diagnostics))
+	}
+	for i, d := range diagnostics {
+		if i < len(expectedErrors) && d.String() != expectedErrors[i] {
+			t.Errorf("diagnostics[%d] wrong. expected = %q, got = %q", i, expectedErrors[i], d.String())
+		}
+	}
+
+	if len(program.Statements) != 5 {
+		t.Fatalf("program.Statements does not have 5 statements. got = %d", len(program.Statements))
+	}
+
+	bad, ok := program.Statements[0].(*ast.BadStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.BadStatement. got = %T", program.Statements[0])
+	}
+	if bad.Source != "let = 5" {
+		t.Errorf("bad.Source wrong. got = %q", bad.Source)
+	}
+
+	let, ok := program.Statements[1].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not ast.LetStatement. got = %T", program.Statements[1])
+	}
+	if _, ok := let.Value.(*ast.BadExpression); !ok {
+		t.Errorf("let.Value is not ast.BadExpression. got = %T", let.Value)
+	}
+
+	function := program.Statements[2].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if len(function.Body.Statements) != 2 {
+		t.Fatalf("function body does not have 2 statements. got = %d", len(function.Body.Statements))
+	}
+	if _, ok := function.Body.Statements[0].(*ast.BadStatement); !ok {
+		t.Errorf("function body statement is not ast.BadStatement. got = %T", function.Body.Statements[0])
+	}
+
+	ifStatement := program.Statements[3].(*ast.ExpressionStatement)
+	badIf, ok := ifStatement.Expression.(*ast.BadExpression)
+	if !ok {
+		t.Fatalf("ifStatement.Expression is not ast.BadExpression. got = %T", ifStatement.Expression)
+	}
+	if badIf.Source != "if (x { a; }" {
+		t.Errorf("badIf.Source wrong. got = %q", badIf.Source)
+	}
+
+	if !testLetStatement(t, program.Statements[4], "z") {
+		return
+	}
+}
+
+func TestUnterminatedBlock(t *testing.T) {
+	l := lexer.New("let f = fn(x) { x + 1")
+	p := parser.New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got = %d (%q)", len(errors), errors)
+	}
+	if errors[0] != "expected next token to be }, got EOF" {
+		t.Errorf("wrong error. got = %q", errors[0])
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedParameter string
+		hasCatch          bool
+		hasFinally        bool
+	}{
+		{"try { x } catch (e) { e }", "e", true, false},
+		{"try { x } catch { 1 }", "", true, false},
+		{"try { x } finally { 1 }", "", false, true},
+		{"try { x } catch (err) { 1 } finally { 2 }", "err", true, true},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got = %d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got = %T", program.Statements[0])
+		}
+
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.TryExpression. got = %T", stmt.Expression)
+		}
+
+		if len(exp.Block.Statements) != 1 {
+			t.Errorf("try block does not contain 1 statement. got = %d", len(exp.Block.Statements))
+		}
+
+		if tt.expectedParameter == "" && exp.Parameter != nil {
+			t.Errorf("exp.Parameter is not nil. got = %q", exp.Parameter.Value)
+		}
+		if tt.expectedParameter != "" && (exp.Parameter == nil || exp.Parameter.Value != tt.expectedParameter) {
+			t.Errorf("exp.Parameter is not %q. got = %+v", tt.expectedParameter, exp.Parameter)
+		}
+		if (exp.Catch != nil) != tt.hasCatch {
+			t.Errorf("wrong catch block. expected = %t, got = %+v", tt.hasCatch, exp.Catch)
+		}
+		if (exp.Finally != nil) != tt.hasFinally {
+			t.Errorf("wrong finally block. expected = %t, got = %+v", tt.hasFinally, exp.Finally)
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw "boom";`)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got = %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ThrowStatement. got = %T", program.Statements[0])
+	}
+
+	if stmt.TokenLiteral() != "throw" {
+		t.Errorf("stmt.TokenLiteral not 'throw'. got = %q", stmt.TokenLiteral())
+	}
+
+	if stmt.Value.String() != "boom" {
+		t.Errorf("stmt.Value is not %q. got = %q", "boom", stmt.Value.String())
+	}
+}
+
+func TestForExpression(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedKey      string
+		expectedValue    string
+		expectedIterable string
+	}{
+		{"for (x in arr) { x }", "", "x", "arr"},
+		{"for (k, v in {1: 2}) { v }", "k", "v", "{1:2}"},
+		{"for (i in range(1, 5)) { i }", "", "i", "range(1, 5)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got = %d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got = %T", program.Statements[0])
+		}
+
+		exp, ok := stmt.Expression.(*ast.ForExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.ForExpression. got = %T", stmt.Expression)
+		}
+
+		if tt.expectedKey == "" && exp.Key != nil {
+			t.Errorf("exp.Key is not nil. got = %q", exp.Key.Value)
+		}
+		if tt.expectedKey != "" && (exp.Key == nil || exp.Key.Value != tt.expectedKey) {
+			t.Errorf("exp.Key is not %q. got = %+v", tt.expectedKey, exp.Key)
+		}
+		if exp.Value.Value != tt.expectedValue {
+			t.Errorf("exp.Value is not %q. got = %q", tt.expectedValue, exp.Value.Value)
+		}
+		if exp.Iterable.String() != tt.expectedIterable {
+			t.Errorf("exp.Iterable is not %q. got = %q", tt.expectedIterable, exp.Iterable.String())
+		}
+		if len(exp.Body.Statements) != 1 {
+			t.Errorf("for body does not contain 1 statement. got = %d", len(exp.Body.Statements))
+		}
+	}
+}
+
+func TestBreakContinueInLoops(t *testing.T) {
+	inputs := []string{
+		"while (x) { break; }",
+		"while (x) { continue }",
+		"for (x in y) { if (x) { break } else { continue } }",
+		"for (x in y) { while (x) { break }; continue }",
+		"while (x) { let f = fn() { for (y in z) { break } } }",
+	}
+
+	for _, input := range inputs {
+		l := lexer.New(input)
+		p := parser.New(l)
+		p.ParseProgram()
+		checkParserErrors(t, p)
+	}
+}
+
+func TestImportExportStatements(t *testing.T) {
+	input := `import "lib/math.ape" as math;
+export let x = 5;`
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got = %d", len(program.Statements))
+	}
+
+	importStmt, ok := program.Statements[0].(*ast.ImportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ImportStatement. got = %T", program.Statements[0])
+	}
+	if importStmt.Path.Value != "lib/math.ape" {
+		t.Errorf("importStmt.Path is not %q. got = %q", "lib/math.ape", importStmt.Path.Value)
+	}
+	if importStmt.Alias.Value != "math" {
+		t.Errorf("importStmt.Alias is not %q. got = %q", "math", importStmt.Alias.Value)
+	}
+
+	exportStmt, ok := program.Statements[1].(*ast.ExportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not ast.ExportStatement. got = %T", program.Statements[1])
+	}
+	if !testLetStatement(t, exportStmt.Statement, "x") {
+		return
+	}
+	if !testLiteralExpression(t, exportStmt.Statement.Value, 5) {
+		return
+	}
+}
@@ -0,0 +1,260 @@
+package pretty
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/token"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI escape codes used for colors
+const (
+	RESET   = "\x1b[0m"
+	RED     = "\x1b[31m"
+	GREEN   = "\x1b[32m"
+	YELLOW  = "\x1b[33m"
+	BLUE    = "\x1b[34m"
+	MAGENTA = "\x1b[35m"
+	CYAN    = "\x1b[36m"
+	GRAY    = "\x1b[90m"
+)
+
+// Colors of values by their type
+var objectColors = map[object.ObjectType]string{
+	object.INTEGER_OBJ:     YELLOW,
+	object.BIGINT_OBJ:      YELLOW,
+	object.FLOAT_OBJ:       YELLOW,
+	object.BOOLEAN_OBJ:     MAGENTA,
+	object.NULL_OBJ:        GRAY,
+	object.STRING_OBJ:      GREEN,
+	object.FUNCTION_OBJ:    BLUE,
+	object.BUILTIN_OBJ:     BLUE,
+	object.RANGE_OBJ:       CYAN,
+	object.MODULE_OBJ:      CYAN,
+	object.ERROR_OBJ:       RED,
+	object.ERROR_VALUE_OBJ: RED,
+}
+
+// Colors of tokens in highlighted source, keywords are looked up separately
+var tokenColors = map[token.TokenType]string{
+	token.INT:     YELLOW,
+	token.FLOAT:   YELLOW,
+	token.STRING:  GREEN,
+	token.TRUE:    MAGENTA,
+	token.FALSE:   MAGENTA,
+	token.COMMENT: GRAY,
+	token.ILLEGAL: RED,
+}
+
+// Printer renders values for people to read, collections that do not fit on
+// one line are split over lines and indented
+type Printer struct {
+	Color    bool // color values by type with ANSI escape codes
+	Width    int  // columns a line can take before a collection is split
+	MaxItems int  // elements of a collection shown before the rest are elided
+	MaxDepth int  // nesting of collections shown before they are elided
+}
+
+func New() *Printer {
+	return &Printer{Width: 80, MaxItems: 100, MaxDepth: 16}
+}
+
+// Print renders obj, strings at the top level are not quoted
+func (p *Printer) Print(obj object.Object) string {
+	if s, ok := obj.(*object.String); ok {
+		return p.paint(objectColors[object.STRING_OBJ], s.Value)
+	}
+	if err, ok := obj.(*object.Error); ok {
+		return p.paint(objectColors[object.ERROR_OBJ], err.Traceback())
+	}
+
+	var out bytes.Buffer
+	p.print(&out, obj, 0, 0)
+	return out.String()
+}
+
+// print writes obj at indent, depth is the number of collections obj is in
+func (p *Printer) print(out *bytes.Buffer, obj object.Object, indent int, depth int) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		if depth >= p.MaxDepth {
+			out.WriteString("[...]")
+			return
+		}
+		p.printCollection(out, obj, "[", "]", len(obj.Elements), indent, depth, func(out *bytes.Buffer, i int, indent int) {
+			p.print(out, obj.Elements[i], indent, depth+1)
+		})
+	case *object.Hash:
+		if depth >= p.MaxDepth {
+			out.WriteString("{...}")
+			return
+		}
+		pairs := obj.OrderedPairs()
+		p.printCollection(out, obj, "{", "}", len(pairs), indent, depth, func(out *bytes.Buffer, i int, indent int) {
+			p.print(out, pairs[i].Key, indent, depth+1)
+			out.WriteString(": ")
+			p.print(out, pairs[i].Value, indent, depth+1)
+		})
+	case *object.String:
+		out.WriteString(p.paint(objectColors[object.STRING_OBJ], strconv.Quote(obj.Value)))
+	default:
+		out.WriteString(p.paint(objectColors[obj.Type()], obj.Inspect()))
+	}
+}
+
+// printCollection writes the n elements of obj on one line when they fit, or
+// one per line otherwise, writeElement writes the element at i
+func (p *Printer) printCollection(out *bytes.Buffer, obj object.Object, open, close string, n int, indent int, depth int,
+	writeElement func(out *bytes.Buffer, i int, indent int)) {
+	shown := n
+	if p.MaxItems > 0 && shown > p.MaxItems {
+		shown = p.MaxItems
+	}
+	elided := ""
+	if shown < n {
+		elided = fmt.Sprintf("... %d more", n-shown)
+	}
+
+	if n == 0 || p.fits(obj, indent, depth) {
+		out.WriteString(open)
+		for i := 0; i < shown; i++ {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			writeElement(out, i, indent)
+		}
+		if elided != "" {
+			out.WriteString(", " + elided)
+		}
+		out.WriteString(close)
+		return
+	}
+
+	prefix := strings.Repeat(" ", indent+2)
+	out.WriteString(open + "\n")
+	for i := 0; i < shown; i++ {
+		out.WriteString(prefix)
+		writeElement(out, i, indent+2)
+		if i < shown-1 || elided != "" {
+			out.WriteByte(',')
+		}
+		out.WriteByte('\n')
+	}
+	if elided != "" {
+		out.WriteString(prefix + elided + "\n")
+	}
+	out.WriteString(strings.Repeat(" ", indent) + close)
+}
+
+// fits reports whether obj can be written on a single line at indent
+func (p *Printer) fits(obj object.Object, indent int, depth int) bool {
+	var out bytes.Buffer
+	p.flatten(&out, obj, depth)
+	return indent+utf8.RuneCount(out.Bytes()) <= p.Width
+}
+
+// flatten writes obj on a single line without colors
+func (p *Printer) flatten(out *bytes.Buffer, obj object.Object, depth int) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		if depth >= p.MaxDepth {
+			out.WriteString("[...]")
+			return
+		}
+		var elements []string
+		for i, element := range obj.Elements {
+			if p.MaxItems > 0 && i == p.MaxItems {
+				elements = append(elements, fmt.Sprintf("... %d more", len(obj.Elements)-i))
+				break
+			}
+			var e bytes.Buffer
+			p.flatten(&e, element, depth+1)
+			elements = append(elements, e.String())
+		}
+		out.WriteString("[" + strings.Join(elements, ", ") + "]")
+	case *object.Hash:
+		if depth >= p.MaxDepth {
+			out.WriteString("{...}")
+			return
+		}
+		var pairs []string
+		for i, pair := range obj.OrderedPairs() {
+			if p.MaxItems > 0 && i == p.MaxItems {
+				pairs = append(pairs, fmt.Sprintf("... %d more", obj.Len()-i))
+				break
+			}
+			var k, v bytes.Buffer
+			p.flatten(&k, pair.Key, depth+1)
+			p.flatten(&v, pair.Value, depth+1)
+			pairs = append(pairs, k.String()+": "+v.String())
+		}
+		out.WriteString("{" + strings.Join(pairs, ", ") + "}")
+	case *object.String:
+		out.WriteString(strconv.Quote(obj.Value))
+	default:
+		out.WriteString(obj.Inspect())
+	}
+}
+
+// Highlight colors the tokens of source, text between tokens is kept as is
+func (p *Printer) Highlight(source string) string {
+	if !p.Color {
+		return source
+	}
+
+	l := lexer.New(source)
+	var tokens []token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		tokens = append(tokens, tok)
+	}
+	tokens = append(tokens, l.Comments()...)
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Start.Offset < tokens[j].Start.Offset
+	})
+
+	var out bytes.Buffer
+	written := 0
+	for _, tok := range tokens {
+		start, end := tok.Start.Offset, tok.End.Offset
+		if start < written || end > len(source) {
+			continue
+		}
+		out.WriteString(source[written:start])
+		out.WriteString(p.paint(tokenColor(tok), source[start:end]))
+		written = end
+	}
+	out.WriteString(source[written:])
+
+	return out.String()
+}
+
+func tokenColor(tok token.Token) string {
+	if tok.Type == token.IDENT {
+		return ""
+	}
+	if token.LookupIdent(tok.Literal) != token.IDENT {
+		return MAGENTA
+	}
+	return tokenColors[tok.Type]
+}
+
+// paint wraps every line of text in color, so text split over lines does not
+// color what is written between the lines
+func (p *Printer) paint(color string, text string) string {
+	if !p.Color || color == "" || text == "" {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = color + line + RESET
+		}
+	}
+	return strings.Join(lines, "\n")
+}
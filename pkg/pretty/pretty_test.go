@@ -0,0 +1,112 @@
+package pretty_test
+
+import (
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/pretty"
+	"testing"
+)
+
+func eval(t *testing.T, input string) object.Object {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		t.Fatalf("parser diagnostics for %q: %v", input, p.Diagnostics())
+	}
+	return evaluator.Eval(program, object.NewEnvironment())
+}
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		input    string
+		width    int
+		maxItems int
+		expected string
+	}{
+		{`5`, 80, 100, "5"},
+		{`"top level"`, 80, 100, "top level"},
+		{`[1, "two", 3.5, true]`, 80, 100, `[1, "two", 3.5, true]`},
+		{`{"b": 2, "a": 1, 3: 3, true: 4}`, 80, 100, `{"b": 2, "a": 1, 3: 3, true: 4}`},
+		{`[[1, 2], {"k": [3]}]`, 80, 100, `[[1, 2], {"k": [3]}]`},
+		{`[1, 2, 3]`, 6, 100, "[\n  1,\n  2,\n  3\n]"},
+		{`{"list": [1, 2, 3], "name": "ape"}`, 20, 100, "{\n  \"list\": [1, 2, 3],\n  \"name\": \"ape\"\n}"},
+		{`[[1, 2, 3], [4, 5, 6]]`, 12, 100, "[\n  [1, 2, 3],\n  [4, 5, 6]\n]"},
+		{`[[1, 2, 3], [4, 5, 6]]`, 10, 100, "[\n  [\n    1,\n    2,\n    3\n  ],\n  [\n    4,\n    5,\n    6\n  ]\n]"},
+		{`array(range(10))`, 80, 3, "[0, 1, 2, ... 7 more]"},
+		{`array(range(10))`, 8, 3, "[\n  0,\n  1,\n  2,\n  ... 7 more\n]"},
+		{`{}`, 80, 100, "{}"},
+		{`[]`, 1, 100, "[]"},
+	}
+
+	for _, tt := range tests {
+		printer := pretty.New()
+		printer.Width = tt.width
+		printer.MaxItems = tt.maxItems
+
+		got := printer.Print(eval(t, tt.input))
+		if got != tt.expected {
+			t.Errorf("Print(%s) wrong.\nwant =\n%s\ngot =\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestPrintMaxDepth(t *testing.T) {
+	printer := pretty.New()
+	printer.MaxDepth = 2
+
+	got := printer.Print(eval(t, `[1, [2, [3, [4]]]]`))
+	expected := "[1, [2, [...]]]"
+	if got != expected {
+		t.Errorf("Print wrong. want = %q, got = %q", expected, got)
+	}
+}
+
+func TestPrintColor(t *testing.T) {
+	printer := pretty.New()
+	printer.Color = true
+
+	got := printer.Print(eval(t, `[1, "a", if (false) { 1 }]`))
+	expected := "[" + pretty.YELLOW + "1" + pretty.RESET + ", " +
+		pretty.GREEN + `"a"` + pretty.RESET + ", " +
+		pretty.GRAY + "null" + pretty.RESET + "]"
+	if got != expected {
+		t.Errorf("Print wrong. want = %q, got = %q", expected, got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	printer := pretty.New()
+	if got := printer.Highlight(`let a = 1;`); got != `let a = 1;` {
+		t.Errorf("Highlight without color changed the source. got = %q", got)
+	}
+
+	printer.Color = true
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let a = "s"; # note`,
+			pretty.MAGENTA + "let" + pretty.RESET + " a = " + pretty.GREEN + `"s"` + pretty.RESET + "; " +
+				pretty.GRAY + "# note" + pretty.RESET,
+		},
+		{
+			"  if (true) {\n  2.5\n}",
+			"  " + pretty.MAGENTA + "if" + pretty.RESET + " (" + pretty.MAGENTA + "true" + pretty.RESET + ") {\n  " +
+				pretty.YELLOW + "2.5" + pretty.RESET + "\n}",
+		},
+		{
+			"/* a\nb */ x",
+			pretty.GRAY + "/* a" + pretty.RESET + "\n" + pretty.GRAY + "b */" + pretty.RESET + " x",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := printer.Highlight(tt.input); got != tt.expected {
+			t.Errorf("Highlight(%q) wrong.\nwant = %q\ngot  = %q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package repl
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/format"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// command is a REPL meta-command, entered as :name followed by its argument
+type command struct {
+	name        string
+	argument    string // shown in :help, empty if the command takes none
+	description string
+	run         func(s *session, argument string)
+}
+
+var commands []command
+
+func init() {
+	// Assigned here as :help refers back to the list
+	commands = []command{
+		{"ast", "<code>", "print the syntax tree of code as JSON", (*session).astCommand},
+		{"env", "", "list the bindings of the session with their types", (*session).envCommand},
+		{"fmt", "[code]", "format code, or the last code entered", (*session).fmtCommand},
+		{"help", "", "list the commands", (*session).helpCommand},
+		{"load", "<file>", "evaluate a file in the session", (*session).loadCommand},
+		{"reset", "", "drop every binding and imported module", (*session).resetCommand},
+		{"time", "<code>", "evaluate code and print how long it took", (*session).timeCommand},
+		{"type", "<code>", "evaluate code and print the type of the result", (*session).typeCommand},
+	}
+}
+
+func isCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), ":")
+}
+
+// splitCommand splits ":name argument" into the name and the argument
+func splitCommand(input string) (string, string) {
+	input = strings.TrimPrefix(strings.TrimSpace(input), ":")
+	name, argument, _ := strings.Cut(input, " ")
+	// A line break can separate the name from the argument too
+	if i := strings.IndexAny(name, "\t\n"); i != -1 {
+		name, argument = input[:i], input[i+1:]
+	}
+	return name, strings.TrimSpace(argument)
+}
+
+func (s *session) runCommand(input string) {
+	name, argument := splitCommand(input)
+	for _, c := range commands {
+		if c.name == name {
+			c.run(s, argument)
+			return
+		}
+	}
+	fmt.Fprintf(s.out, "unknown command :%s, :help lists the commands\n", name)
+}
+
+// needsArgument reports, and returns true, when a command was not given the
+// argument it requires
+func (s *session) needsArgument(name, argument string) bool {
+	if argument != "" {
+		return false
+	}
+	for _, c := range commands {
+		if c.name == name {
+			fmt.Fprintf(s.out, "usage: :%s %s\n", c.name, c.argument)
+		}
+	}
+	return true
+}
+
+func (s *session) astCommand(argument string) {
+	if s.needsArgument("ast", argument) {
+		return
+	}
+	program := s.parse(argument)
+	if program == nil {
+		return
+	}
+
+	astJson, err := json.MarshalIndent(program, "", "  ")
+	if err != nil {
+		fmt.Fprintf(s.out, "could not convert the syntax tree to JSON: %s\n", err)
+		return
+	}
+	fmt.Fprintf(s.out, "%s\n", astJson)
+}
+
+func (s *session) envCommand(argument string) {
+	names := s.env.Names()
+	if len(names) == 0 {
+		io.WriteString(s.out, "no bindings\n")
+		return
+	}
+
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	for _, name := range names {
+		value, _ := s.env.Get(name)
+		fmt.Fprintf(s.out, "%-*s : %s\n", width, name, value.Type())
+	}
+}
+
+func (s *session) fmtCommand(argument string) {
+	if argument == "" {
+		argument = s.last
+	}
+	if argument == "" {
+		io.WriteString(s.out, "nothing to format\n")
+		return
+	}
+
+	program := s.parse(argument)
+	if program == nil {
+		return
+	}
+	io.WriteString(s.out, strings.TrimRight(format.New().Format(program), "\n")+"\n")
+}
+
+func (s *session) helpCommand(argument string) {
+	for _, c := range commands {
+		usage := ":" + c.name
+		if c.argument != "" {
+			usage += " " + c.argument
+		}
+		fmt.Fprintf(s.out, "  %-14s %s\n", usage, c.description)
+	}
+}
+
+func (s *session) loadCommand(argument string) {
+	if s.needsArgument("load", argument) {
+		return
+	}
+
+	source, err := os.ReadFile(argument)
+	if err != nil {
+		fmt.Fprintf(s.out, "cannot load %s: %s\n", argument, err)
+		return
+	}
+
+	l := lexer.New(string(source))
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		for _, d := range p.Diagnostics() {
+			io.WriteString(s.out, diagnostic.Render(argument, string(source), d))
+		}
+		return
+	}
+
+	diagnostics := evaluator.Resolve(program, s.env)
+	for _, d := range diagnostics {
+		io.WriteString(s.out, diagnostic.Render(argument, string(source), d))
+	}
+	if hasErrors(diagnostics) {
+		return
+	}
+
+	s.print(evaluator.Eval(program, s.env))
+}
+
+func (s *session) resetCommand(argument string) {
+	s.reset()
+	io.WriteString(s.out, "session reset\n")
+}
+
+func (s *session) timeCommand(argument string) {
+	if s.needsArgument("time", argument) {
+		return
+	}
+
+	start := time.Now()
+	evaluated, ok := s.eval(argument)
+	elapsed := time.Since(start)
+	if !ok {
+		return
+	}
+
+	s.print(evaluated)
+	fmt.Fprintf(s.out, "time: %s\n", elapsed)
+}
+
+func (s *session) typeCommand(argument string) {
+	if s.needsArgument("type", argument) {
+		return
+	}
+
+	evaluated, ok := s.eval(argument)
+	if !ok {
+		return
+	}
+	if evaluated == nil {
+		// Statements like let do not produce a value
+		io.WriteString(s.out, "no value\n")
+		return
+	}
+	fmt.Fprintf(s.out, "%s\n", evaluated.Type())
+}
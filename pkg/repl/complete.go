@@ -0,0 +1,128 @@
+package repl
+
+import (
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/token"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Matches an index expression with an unclosed string key, like h["ke, names
+// are letters and underscores like the lexer reads them
+var hashKeyPattern = regexp.MustCompile(`([\p{L}_]+)\[\s*"([^"\\]*)$`)
+
+// Complete returns completions for the word ending at pos in line, counted in
+// runes like liner does, head and tail are the parts of line before and after
+// the word. Words complete to names bound in env, builtins and keywords,
+// inside h[" they complete to the string keys of the hash h, or the exports of
+// a module.
+func Complete(line string, pos int, env *object.Environment) (head string, completions []string, tail string) {
+	runes := []rune(line)
+	before, tail := string(runes[:pos]), string(runes[pos:])
+
+	if match := hashKeyPattern.FindStringSubmatch(before); match != nil {
+		prefix := match[2]
+		closing := `"]`
+		if strings.HasPrefix(tail, `"`) {
+			closing = ""
+		}
+		for _, key := range indexKeys(match[1], env) {
+			if strings.HasPrefix(key, prefix) {
+				completions = append(completions, key+closing)
+			}
+		}
+		return before[:len(before)-len(prefix)], completions, tail
+	}
+
+	if insideString(before) {
+		return before, nil, tail
+	}
+
+	start := len(before)
+	for start > 0 {
+		ch, size := utf8.DecodeLastRuneInString(before[:start])
+		if !isIdentifierRune(ch) {
+			break
+		}
+		start -= size
+	}
+	head, prefix := before[:start], before[start:]
+
+	if head == ":" {
+		for _, c := range commands {
+			if strings.HasPrefix(c.name, prefix) {
+				completions = append(completions, c.name)
+			}
+		}
+		return head, completions, tail
+	}
+
+	if prefix == "" {
+		return before, nil, tail
+	}
+
+	seen := map[string]bool{}
+	add := func(names []string) {
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) && !seen[name] {
+				seen[name] = true
+				completions = append(completions, name)
+			}
+		}
+	}
+	for e := env; e != nil; e = e.Outer() {
+		add(e.Names())
+	}
+	add(evaluator.BuiltinNames())
+	add(token.Keywords())
+	sort.Strings(completions)
+
+	return head, completions, tail
+}
+
+// indexKeys returns the string keys that can index the value bound to name
+func indexKeys(name string, env *object.Environment) []string {
+	value, ok := env.Get(name)
+	if !ok {
+		return nil
+	}
+
+	var keys []string
+	switch value := value.(type) {
+	case *object.Hash:
+		for _, pair := range value.OrderedPairs() {
+			if key, ok := pair.Key.(*object.String); ok {
+				keys = append(keys, key.Value)
+			}
+		}
+	case *object.Module:
+		keys = append(keys, value.Exports...)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// insideString reports whether line ends inside an unclosed string literal
+func insideString(line string) bool {
+	inside := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inside {
+				i++
+			}
+		case '"':
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// isIdentifierRune matches the letters of identifiers as the lexer reads them
+func isIdentifierRune(ch rune) bool {
+	return ch == '_' || unicode.IsLetter(ch)
+}
@@ -0,0 +1,57 @@
+package repl_test
+
+import (
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/repl"
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	env := object.NewEnvironment()
+	source := `let split_here = 1; let point = {"x": 1, "y": 2, "xy": 3, 4: 4}; let num = 5; let 名前 = {"ключ": 1};`
+	evaluator.Eval(parser.New(lexer.New(source)).ParseProgram(), env)
+
+	tests := []struct {
+		line                string
+		pos                 int
+		expectedHead        string
+		expectedCompletions []string
+		expectedTail        string
+	}{
+		{"spl", 3, "", []string{"split", "split_here", "split_once"}, ""},
+		{"let a = push", 12, "let a = ", []string{"push", "push_front"}, ""},
+		{"has_k(x)", 5, "", []string{"has_key"}, "(x)"},
+		{"con", 3, "", []string{"continue"}, ""},
+		{"if (po", 6, "if (", []string{"point", "pop", "pop_front"}, ""},
+		{"nothing_like_this", 17, "", nil, ""},
+		{"1 + ", 4, "1 + ", nil, ""},
+		{`point["`, 7, `point["`, []string{`x"]`, `xy"]`, `y"]`}, ""},
+		{`point["x`, 8, `point["`, []string{`x"]`, `xy"]`}, ""},
+		{`point["x"] + 1`, 8, `point["`, []string{"x", "xy"}, `"] + 1`},
+		{`num["`, 5, `num["`, nil, ""},
+		{`"sp`, 3, `"sp`, nil, ""},
+		{`":"`, 2, `":`, nil, `"`},
+		{"名", 1, "", []string{"名前"}, ""},
+		{"len(名)", 5, "len(", []string{"名前"}, ")"},
+		{`名前["к`, 5, `名前["`, []string{`ключ"]`}, ""},
+		{":lo", 3, ":", []string{"load"}, ""},
+		{":t", 2, ":", []string{"time", "type"}, ""},
+		{":type po", 8, ":type ", []string{"point", "pop", "pop_front"}, ""},
+	}
+
+	for _, tt := range tests {
+		head, completions, tail := repl.Complete(tt.line, tt.pos, env)
+		if head != tt.expectedHead || tail != tt.expectedTail {
+			t.Errorf("Complete(%q, %d) wrong head or tail. want = %q, %q, got = %q, %q",
+				tt.line, tt.pos, tt.expectedHead, tt.expectedTail, head, tail)
+		}
+		if !reflect.DeepEqual(completions, tt.expectedCompletions) {
+			t.Errorf("Complete(%q, %d) wrong completions. want = %q, got = %q",
+				tt.line, tt.pos, tt.expectedCompletions, completions)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/token"
+	"github.com/peterh/liner"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the file in the home directory the history is kept in
+const HISTORY_FILE = ".ape_history"
+
+// errInterrupted is returned by prompt when the user aborts the current input
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the input of the REPL a line at a time
+type lineReader interface {
+	// prompt shows prompt and returns the next line without its newline
+	prompt(prompt string) (string, error)
+	addHistory(line string)
+	// setCompleter sets the function tab completion uses
+	setCompleter(completer liner.WordCompleter)
+	close() error
+}
+
+// newLineReader uses the terminal with line editing, history and reverse
+// search when the REPL runs on one, otherwise it reads in as is
+func newLineReader(in io.Reader, out io.Writer) lineReader {
+	if in == os.Stdin && out == os.Stdout && liner.TerminalSupported() {
+		return newTerminalReader()
+	}
+	return &plainReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+type plainReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (pr *plainReader) prompt(prompt string) (string, error) {
+	io.WriteString(pr.out, prompt)
+	if !pr.scanner.Scan() {
+		if err := pr.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return pr.scanner.Text(), nil
+}
+
+func (pr *plainReader) addHistory(line string)                     {}
+func (pr *plainReader) setCompleter(completer liner.WordCompleter) {}
+func (pr *plainReader) close() error                               { return nil }
+
+type terminalReader struct {
+	state       *liner.State
+	historyPath string
+}
+
+func newTerminalReader() *terminalReader {
+	tr := &terminalReader{state: liner.NewLiner()}
+	tr.state.SetCtrlCAborts(true)
+
+	if home, err := os.UserHomeDir(); err == nil {
+		tr.historyPath = filepath.Join(home, HISTORY_FILE)
+		if f, err := os.Open(tr.historyPath); err == nil {
+			tr.state.ReadHistory(f)
+			f.Close()
+		}
+	}
+
+	return tr
+}
+
+func (tr *terminalReader) prompt(prompt string) (string, error) {
+	line, err := tr.state.Prompt(prompt)
+	if err == liner.ErrPromptAborted {
+		return "", errInterrupted
+	}
+	return line, err
+}
+
+func (tr *terminalReader) addHistory(line string) {
+	if strings.TrimSpace(line) != "" {
+		tr.state.AppendHistory(line)
+	}
+}
+
+func (tr *terminalReader) setCompleter(completer liner.WordCompleter) {
+	tr.state.SetWordCompleter(completer)
+}
+
+// close restores the terminal and saves the history
+func (tr *terminalReader) close() error {
+	if tr.historyPath != "" {
+		if f, err := os.Create(tr.historyPath); err == nil {
+			tr.state.WriteHistory(f)
+			f.Close()
+		}
+	}
+	return tr.state.Close()
+}
+
+// IsIncomplete reports whether input needs more lines to be complete, because
+// it has unclosed brackets or a comment, or fails to parse only at its end
+func IsIncomplete(input string) bool {
+	l := lexer.New(input)
+
+	depth, end := 0, 0
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		end = tok.Span().End.Offset
+		switch tok.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			depth--
+		case token.ILLEGAL:
+			if tok.Literal == "/*" {
+				return true
+			}
+		}
+	}
+	if depth > 0 {
+		return true
+	}
+	// Closing too much can not be fixed by more input
+	if depth < 0 {
+		return false
+	}
+
+	// Problems after the last token are where the parser ran out of input
+	p := parser.New(lexer.New(input))
+	p.ParseProgram()
+	for _, d := range p.Diagnostics() {
+		if d.Span.Start.Offset >= end {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,50 +1,236 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
+	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
 	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/module"
 	"github.com/JasirZaeem/ape/pkg/object"
 	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/pretty"
+	"golang.org/x/term"
 	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/JasirZaeem/ape/pkg/lexer"
 )
 
 const PROMPT = ">> "
 
+// Shown while the input so far is incomplete, an empty line evaluates it anyway
+const CONTINUATION_PROMPT = ".. "
+
+// session is the state of a running REPL
+type session struct {
+	out     io.Writer
+	env     *object.Environment
+	io      *object.IO // of the code entered, on the streams of the REPL
+	last    string     // the last code entered, for :fmt
+	printer *pretty.Printer
+	echo    bool // rewrite entered lines with syntax highlighting
+}
+
+func newSession(out io.Writer, reader lineReader) *session {
+	s := &session{
+		out:     out,
+		io:      &object.IO{Stdout: out, ReadLine: reader.prompt},
+		printer: pretty.New(),
+	}
+
+	// Colors are only used when writing to a terminal
+	if out == os.Stdout && term.IsTerminal(int(os.Stdout.Fd())) {
+		s.printer.Color = true
+		if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+			s.printer.Width = width
+		}
+	}
+
+	s.reset()
+	return s
+}
+
+// reset drops every binding and imported module
+func (s *session) reset() {
+	// Imports are relative to the working directory
+	s.env = evaluator.NewModules(module.NewFileLoader()).NewEnvironment("")
+	s.env.SetRuntime(&object.Runtime{IO: s.io})
+}
+
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	reader := newLineReader(in, out)
+	defer reader.close()
+
+	s := newSession(out, reader)
+	_, s.echo = reader.(*terminalReader)
+	s.echo = s.echo && s.printer.Color
+	reader.setCompleter(func(line string, pos int) (string, []string, string) {
+		return Complete(line, pos, s.env)
+	})
 
 	for {
-		fmt.Fprint(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		lines, ok := readInput(reader, out)
+		if !ok {
 			return
 		}
+		if s.echo {
+			s.highlightInput(lines)
+		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		input := strings.Join(lines, "\n")
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if isCommand(input) {
+			s.runCommand(input)
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
+		s.last = input
+		if evaluated, ok := s.eval(input); ok {
+			s.print(evaluated)
+		}
+	}
+}
+
+// parse reports the diagnostics of input, if any, and returns nil in that case
+func (s *session) parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		printParserErrors(s.out, p.Diagnostics())
+		return nil
+	}
+	return program
+}
+
+// eval evaluates input in the session, it reports false if input does not
+// parse or refers to undefined names
+func (s *session) eval(input string) (object.Object, bool) {
+	program := s.parse(input)
+	if program == nil {
+		return nil, false
+	}
+	if !s.resolve(program) {
+		return nil, false
+	}
+	return evaluator.Eval(program, s.env), true
+}
+
+// resolve reports what the resolver finds in program, it returns false if
+// there are errors
+func (s *session) resolve(program *ast.Program) bool {
+	diagnostics := evaluator.Resolve(program, s.env)
+	if len(diagnostics) == 0 {
+		return true
+	}
+
+	ok := !hasErrors(diagnostics)
+	if ok {
+		printDiagnostics(s.out, "warnings", diagnostics)
+	} else {
+		printDiagnostics(s.out, "resolver errors", diagnostics)
+	}
+	return ok
+}
+
+func (s *session) print(evaluated object.Object) {
+	if evaluated != nil {
+		io.WriteString(s.out, s.printer.Print(evaluated))
+		io.WriteString(s.out, "\n")
+	}
+}
+
+// highlightInput writes the lines just entered again over themselves, with
+// syntax highlighting
+func (s *session) highlightInput(lines []string) {
+	for i, line := range lines {
+		prompt := PROMPT
+		if i > 0 {
+			prompt = CONTINUATION_PROMPT
+		}
+		// Lines wrapped by the terminal take more rows than can be counted here
+		if utf8.RuneCountInString(prompt+line) >= s.printer.Width {
+			return
+		}
+	}
+
+	highlighted := strings.Split(s.printer.Highlight(strings.Join(lines, "\n")), "\n")
+	fmt.Fprintf(s.out, "\x1b[%dA", len(lines))
+	for i, line := range highlighted {
+		prompt := PROMPT
+		if i > 0 {
+			prompt = CONTINUATION_PROMPT
+		}
+		io.WriteString(s.out, "\r\x1b[K"+prompt+line+"\n")
+	}
+}
+
+// readInput reads lines until they form a complete input, it reports false
+// when there is no more input
+func readInput(reader lineReader, out io.Writer) ([]string, bool) {
+	var lines []string
+
+	for {
+		prompt := PROMPT
+		if len(lines) > 0 {
+			prompt = CONTINUATION_PROMPT
+		}
+
+		line, err := reader.prompt(prompt)
+		if err == errInterrupted {
+			// Ctrl-C drops what has been typed so far
 			io.WriteString(out, "\n")
+			lines = nil
+			continue
+		}
+		if err != nil {
+			io.WriteString(out, "\n")
+			return lines, len(lines) > 0
+		}
+
+		reader.addHistory(line)
+
+		lines = append(lines, line)
+		if len(lines) > 1 && strings.TrimSpace(line) == "" {
+			return lines, true
+		}
+
+		input := strings.Join(lines, "\n")
+		if isCommand(input) {
+			// The code given to a command can span lines too
+			_, input = splitCommand(input)
+		}
+		if !IsIncomplete(input) {
+			return lines, true
+		}
+	}
+}
+
+func printParserErrors(out io.Writer, diagnostics []diagnostic.Diagnostic) {
+	printDiagnostics(out, "parser errors", diagnostics)
+}
+
+func printDiagnostics(out io.Writer, title string, diagnostics []diagnostic.Diagnostic) {
+	io.WriteString(out, " "+title+":\n")
+	for _, d := range diagnostics {
+		io.WriteString(out, "\t"+d.String()+"\n")
+		if d.Hint != "" {
+			io.WriteString(out, "\t  hint: "+d.Hint+"\n")
 		}
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+func hasErrors(diagnostics []diagnostic.Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == diagnostic.ERROR {
+			return true
+		}
 	}
+	return false
 }
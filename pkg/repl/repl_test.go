@@ -0,0 +1,139 @@
+package repl_test
+
+import (
+	"bytes"
+	"github.com/JasirZaeem/ape/pkg/repl"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let a = 1;", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n  x\n}", false},
+		{"[1, 2,", true},
+		{"puts(1,\n2", true},
+		{"1 +", true},
+		{"1 +\n", true},
+		{"1 + # more to come", true},
+		{"let x =", true},
+		{"let x = 1 +  ", true},
+		{`let s = "a" +`, true},
+		{"if (true) { 1 } else", true},
+		{"/* a comment", true},
+		{"1 + 1 }", false},
+		{"let = 5;", false},
+	}
+
+	for _, tt := range tests {
+		if got := repl.IsIncomplete(tt.input); got != tt.expected {
+			t.Errorf("IsIncomplete(%q) wrong. want = %t, got = %t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStartMultiLineInput(t *testing.T) {
+	input := strings.Join([]string{
+		"let add = fn(a, b) {",
+		"  a + b",
+		"};",
+		"add(1, 2)",
+		"[1,",
+		"2]",
+		"if (true) {",
+		"",
+		"let x = 5; x",
+	}, "\n")
+
+	var out bytes.Buffer
+	repl.Start(strings.NewReader(input), &out)
+
+	expected := []string{
+		repl.PROMPT + repl.CONTINUATION_PROMPT + repl.CONTINUATION_PROMPT + repl.PROMPT + "3\n",
+		repl.PROMPT + repl.CONTINUATION_PROMPT + "[1, 2]\n",
+		// An empty line evaluates incomplete input as it is
+		repl.PROMPT + repl.CONTINUATION_PROMPT + " parser errors:\n",
+		repl.PROMPT + "5\n",
+	}
+
+	got := out.String()
+	for _, e := range expected {
+		if !strings.Contains(got, e) {
+			t.Errorf("output does not contain %q. got = %q", e, got)
+		}
+	}
+}
+
+func TestStartProgramIO(t *testing.T) {
+	input := strings.Join([]string{
+		`let name = input("name? ");`,
+		"ape",
+		`printf("hello %s\n", name)`,
+		":reset",
+		`println("after", "reset")`,
+	}, "\n")
+
+	var out bytes.Buffer
+	repl.Start(strings.NewReader(input), &out)
+
+	// The line read by input is the one after the code calling it
+	expected := []string{
+		repl.PROMPT + "name? " + repl.PROMPT + "hello ape\nnull\n",
+		repl.PROMPT + "after reset\nnull\n",
+	}
+
+	got := out.String()
+	for _, e := range expected {
+		if !strings.Contains(got, e) {
+			t.Errorf("output does not contain %q. got = %q", e, got)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.ape")
+	if err := os.WriteFile(lib, []byte("let double = fn(x) { x * 2 };"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    []string
+		expected []string
+	}{
+		{[]string{"let a = 1;", "let name = \"ape\";", ":env"}, []string{"a    : INTEGER\n", "name : STRING\n"}},
+		{[]string{":env"}, []string{"no bindings\n"}},
+		{[]string{":type 1.5"}, []string{"FLOAT\n"}},
+		{[]string{":type [1,", "2]"}, []string{"ARRAY\n"}},
+		{[]string{":type let a = 1;"}, []string{"no value\n"}},
+		{[]string{":type"}, []string{"usage: :type <code>\n"}},
+		{[]string{":ast 1"}, []string{`"Type": "IntegerLiteral"`, `"Value": 1`}},
+		{[]string{"let  a=[1,2]", ":fmt"}, []string{"let a = [1, 2];\n"}},
+		{[]string{":fmt if(true){1}"}, []string{"if (true) {\n"}},
+		{[]string{":fmt"}, []string{"nothing to format\n"}},
+		{[]string{":time 1 + 2"}, []string{"3\ntime: "}},
+		{[]string{"let a = 1;", ":reset", "a"}, []string{"session reset\n", "error[R001]: undefined name a"}},
+		{[]string{":load " + lib, "double(4)"}, []string{"8\n"}},
+		{[]string{":load " + filepath.Join(dir, "missing.ape")}, []string{"cannot load "}},
+		{[]string{":nope"}, []string{"unknown command :nope"}},
+		{[]string{":help"}, []string{":load <file>", ":reset "}},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		repl.Start(strings.NewReader(strings.Join(tt.input, "\n")), &out)
+
+		got := out.String()
+		for _, e := range tt.expected {
+			if !strings.Contains(got, e) {
+				t.Errorf("output for %q does not contain %q. got = %q", tt.input, e, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Package resolver binds identifiers to the variables they refer to before a
+// program is evaluated, and reports the names it cannot bind.
+//
+// Like the evaluator's environments only functions, for loop bodies and catch
+// blocks have a scope of their own, other blocks bind names in the enclosing
+// one. Each of those scopes gets a slot for every name declared in it and the
+// identifiers referring to them a (depth, slot) Binding. Top level variables
+// stay bound by name, since later programs of a REPL session or the host
+// define more of them.
+package resolver
+
+import (
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
+	"strings"
+)
+
+// Diagnostic codes reported by the resolver
+const (
+	UNDEFINED_NAME  diagnostic.Code = "R001"
+	DUPLICATE_LET   diagnostic.Code = "R002"
+	UNUSED_VARIABLE diagnostic.Code = "R003"
+)
+
+type scope struct {
+	names     []string // of the slots, in order
+	variables map[string]*variable
+	outer     *scope
+	top       bool // the top level, its variables are not given slots
+}
+
+type variable struct {
+	name  *ast.Identifier // where it is declared
+	slot  int
+	used  bool
+	check bool // whether it is reported if it is never used
+}
+
+type resolver struct {
+	scope       *scope
+	globals     map[string]bool
+	diagnostics []diagnostic.Diagnostic
+}
+
+// Resolve binds the identifiers of program and sets the scopes of its
+// functions, for loops and catch blocks. globals are the names defined before
+// program runs, like builtins and the variables of earlier programs, names
+// that are neither one of them nor declared in program are reported.
+func Resolve(program *ast.Program, globals []string) []diagnostic.Diagnostic {
+	r := &resolver{globals: map[string]bool{}}
+	for _, name := range globals {
+		r.globals[name] = true
+	}
+
+	r.scope = &scope{variables: map[string]*variable{}, top: true}
+	r.declare(ast.Declarations(program.Statements), false)
+	r.statements(program.Statements)
+	program.Resolved = true
+
+	diagnostic.Sort(r.diagnostics)
+	return r.diagnostics
+}
+
+// enter opens the scope of a function, loop body or catch block, its
+// variables are declared in the order of their slots
+func (r *resolver) enter(variables []*ast.Identifier, check bool, stmts []ast.Statement) {
+	r.scope = &scope{variables: map[string]*variable{}, outer: r.scope}
+	r.declare(variables, check)
+	r.declare(ast.Declarations(stmts), true)
+}
+
+// leave closes the current scope and returns its slots
+func (r *resolver) leave() *ast.Scope {
+	s := r.scope
+	for _, name := range s.names {
+		v := s.variables[name]
+		if v.check && !v.used && !strings.HasPrefix(name, "_") {
+			r.report(v.name, diagnostic.WARNING, UNUSED_VARIABLE,
+				"remove it, or start its name with _ if it is unused on purpose",
+				"%s is declared but never used", name)
+		}
+	}
+	r.scope = s.outer
+	return &ast.Scope{Names: s.names}
+}
+
+func (r *resolver) declare(names []*ast.Identifier, check bool) {
+	s := r.scope
+	for _, name := range names {
+		if name == nil {
+			continue
+		}
+		if v, ok := s.variables[name.Value]; ok {
+			if v.name != name {
+				r.report(name, diagnostic.WARNING, DUPLICATE_LET,
+					"assign it with = instead, blocks do not have a scope of their own",
+					"%s is already defined in this scope", name.Value)
+			}
+			continue
+		}
+		s.variables[name.Value] = &variable{name: name, slot: len(s.names), check: check}
+		s.names = append(s.names, name.Value)
+	}
+}
+
+// define binds an identifier declaring a variable of the current scope
+func (r *resolver) define(name *ast.Identifier) {
+	if name == nil {
+		return
+	}
+	name.Binding = nil
+	if v, ok := r.scope.variables[name.Value]; ok && !r.scope.top {
+		name.Binding = &ast.Binding{Slot: v.slot}
+	}
+}
+
+// use binds an identifier referring to a variable, read tells whether its
+// value is read rather than assigned
+func (r *resolver) use(name *ast.Identifier, read bool) {
+	name.Binding = nil
+
+	depth := 0
+	for s := r.scope; s != nil; s = s.outer {
+		if v, ok := s.variables[name.Value]; ok {
+			if read {
+				v.used = true
+			}
+			if !s.top {
+				name.Binding = &ast.Binding{Depth: depth, Slot: v.slot}
+			}
+			return
+		}
+		if !s.top {
+			depth++
+		}
+	}
+
+	if r.globals[name.Value] {
+		return
+	}
+	hint := ""
+	if !read {
+		hint = fmt.Sprintf("declare it with let %s = ... first", name.Value)
+	}
+	r.report(name, diagnostic.ERROR, UNDEFINED_NAME, hint, "undefined name %s", name.Value)
+}
+
+func (r *resolver) report(name *ast.Identifier, severity diagnostic.Severity, code diagnostic.Code, hint string, format string, a ...interface{}) {
+	r.diagnostics = append(r.diagnostics, diagnostic.Diagnostic{
+		Severity: severity,
+		Span:     name.Span(),
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
+}
+
+func (r *resolver) statements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		r.statement(stmt)
+	}
+}
+
+func (r *resolver) block(block *ast.BlockStatement) {
+	if block != nil {
+		r.statements(block.Statements)
+	}
+}
+
+func (r *resolver) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		r.expression(stmt.Value)
+		r.define(stmt.Name)
+	case *ast.ExportStatement:
+		r.statement(stmt.Statement)
+	case *ast.ImportStatement:
+		r.define(stmt.Alias)
+	case *ast.ExpressionStatement:
+		r.expression(stmt.Expression)
+	case *ast.ReturnStatement:
+		r.expression(stmt.ReturnValue)
+	case *ast.ThrowStatement:
+		r.expression(stmt.Value)
+	case *ast.BlockStatement:
+		r.block(stmt)
+	}
+}
+
+func (r *resolver) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		r.use(exp, true)
+	case *ast.PrefixExpression:
+		r.expression(exp.Right)
+	case *ast.InfixExpression:
+		if name, ok := exp.Left.(*ast.Identifier); ok && exp.Operator == "=" {
+			r.expression(exp.Right)
+			r.use(name, false)
+			return
+		}
+		r.expression(exp.Left)
+		r.expression(exp.Right)
+	case *ast.IfExpression:
+		r.expression(exp.Condition)
+		r.block(exp.Consequence)
+		r.block(exp.Alternative)
+	case *ast.WhileExpression:
+		r.expression(exp.Condition)
+		r.block(exp.Body)
+	case *ast.ForExpression:
+		r.expression(exp.Iterable)
+		r.enter([]*ast.Identifier{exp.Key, exp.Value}, true, blockStatements(exp.Body))
+		r.define(exp.Key)
+		r.define(exp.Value)
+		r.block(exp.Body)
+		exp.Scope = r.leave()
+	case *ast.TryExpression:
+		r.block(exp.Block)
+		if exp.Catch != nil {
+			r.enter([]*ast.Identifier{exp.Parameter}, true, exp.Catch.Statements)
+			r.define(exp.Parameter)
+			r.block(exp.Catch)
+			exp.CatchScope = r.leave()
+		}
+		r.block(exp.Finally)
+	case *ast.FunctionLiteral:
+		// Parameters are often unused on purpose, like those of callbacks
+		r.enter(exp.Parameters, false, blockStatements(exp.Body))
+		for _, param := range exp.Parameters {
+			r.define(param)
+		}
+		r.block(exp.Body)
+		exp.Scope = r.leave()
+	case *ast.CallExpression:
+		r.expression(exp.Function)
+		for _, arg := range exp.Arguments {
+			r.expression(arg)
+		}
+	case *ast.IndexExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Index)
+	case *ast.ArrayLiteral:
+		for _, element := range exp.Elements {
+			r.expression(element)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range exp.Pairs {
+			r.expression(pair.Key)
+			r.expression(pair.Value)
+		}
+	}
+}
+
+func blockStatements(block *ast.BlockStatement) []ast.Statement {
+	if block == nil {
+		return nil
+	}
+	return block.Statements
+}
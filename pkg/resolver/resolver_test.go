@@ -0,0 +1,121 @@
+package resolver_test
+
+import (
+	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/resolver"
+	"reflect"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		t.Fatalf("parser diagnostics for %q: %v", input, p.Diagnostics())
+	}
+	return program
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		globals  []string
+		expected []string
+	}{
+		{"let a = 1; a + b", nil, []string{"1:16: error[R001]: undefined name b"}},
+		{"len([])", []string{"len"}, nil},
+		{"x = 1", nil, []string{"1:1: error[R001]: undefined name x"}},
+		{"let f = fn() { g() }; let g = fn() { 1 };", nil, nil},
+		{"fn() { a }; let a = 1;", nil, nil},
+		{"let a = 1; let a = 2;", nil, []string{"1:16: warning[R002]: a is already defined in this scope"}},
+		{"if (true) { let a = 1 } else { let a = 2 }", nil, []string{"1:36: warning[R002]: a is already defined in this scope"}},
+		{"fn(a) { let a = 2; a }", nil, []string{"1:13: warning[R002]: a is already defined in this scope"}},
+		{"let a = 1; fn() { let a = 2; a }", nil, nil},
+		{"fn() { let a = 1; 2 }", nil, []string{"1:12: warning[R003]: a is declared but never used"}},
+		{"fn() { let _a = 1; 2 }", nil, nil},
+		{"fn() { let a = 1; a = 2 }", nil, []string{"1:12: warning[R003]: a is declared but never used"}},
+		{"fn() { let a = 1; fn() { a } }", nil, nil},
+		{"fn(unused) { 1 }", nil, nil},
+		{"for (k, v in [1]) { v }", nil, []string{"1:6: warning[R003]: k is declared but never used"}},
+		{"try { 1 } catch (e) { 2 }", nil, []string{"1:18: warning[R003]: e is declared but never used"}},
+		{"let unused = 1;", nil, nil},
+		{"for (x in [1]) { x }; x", nil, []string{"1:23: error[R001]: undefined name x"}},
+		{
+			"fn() { y; let q = 1 }",
+			nil,
+			[]string{"1:8: error[R001]: undefined name y", "1:15: warning[R003]: q is declared but never used"},
+		},
+	}
+
+	for _, tt := range tests {
+		diagnostics := resolver.Resolve(parse(t, tt.input), tt.globals)
+
+		var got []string
+		for _, d := range diagnostics {
+			got = append(got, d.String())
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong diagnostics for %q. expected = %q, got = %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBindings(t *testing.T) {
+	program := parse(t, "let g = 1; fn(a, b) { let c = a; fn() { c + b + g } }")
+	resolver.Resolve(program, nil)
+
+	outer := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if !reflect.DeepEqual(outer.Scope.Names, []string{"a", "b", "c"}) {
+		t.Fatalf("wrong scope names. got = %v", outer.Scope.Names)
+	}
+
+	let := outer.Body.Statements[0].(*ast.LetStatement)
+	inner := outer.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	sum := inner.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	left := sum.Left.(*ast.InfixExpression)
+
+	tests := []struct {
+		ident    *ast.Identifier
+		expected *ast.Binding
+	}{
+		{program.Statements[0].(*ast.LetStatement).Name, nil},
+		{outer.Parameters[1], &ast.Binding{Depth: 0, Slot: 1}},
+		{let.Name, &ast.Binding{Depth: 0, Slot: 2}},
+		{let.Value.(*ast.Identifier), &ast.Binding{Depth: 0, Slot: 0}},
+		{left.Left.(*ast.Identifier), &ast.Binding{Depth: 1, Slot: 2}},
+		{left.Right.(*ast.Identifier), &ast.Binding{Depth: 1, Slot: 1}},
+		{sum.Right.(*ast.Identifier), nil},
+	}
+
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.ident.Binding, tt.expected) {
+			t.Errorf("wrong binding for %s. expected = %+v, got = %+v", tt.ident.Value, tt.expected, tt.ident.Binding)
+		}
+	}
+}
+
+func TestLoopAndCatchScopes(t *testing.T) {
+	program := parse(t, "fn(a) { for (i, x in a) { let y = x; try { y } catch (e) { [e, i, a] } } }")
+	resolver.Resolve(program, nil)
+
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	loop := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ForExpression)
+	if !reflect.DeepEqual(loop.Scope.Names, []string{"i", "x", "y"}) {
+		t.Fatalf("wrong loop scope names. got = %v", loop.Scope.Names)
+	}
+
+	try := loop.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.TryExpression)
+	if !reflect.DeepEqual(try.CatchScope.Names, []string{"e"}) {
+		t.Fatalf("wrong catch scope names. got = %v", try.CatchScope.Names)
+	}
+
+	elements := try.Catch.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ArrayLiteral).Elements
+	expected := []*ast.Binding{{Depth: 0, Slot: 0}, {Depth: 1, Slot: 0}, {Depth: 2, Slot: 0}}
+	for i, element := range elements {
+		if binding := element.(*ast.Identifier).Binding; !reflect.DeepEqual(binding, expected[i]) {
+			t.Errorf("wrong binding for %s. expected = %+v, got = %+v", element, expected[i], binding)
+		}
+	}
+}
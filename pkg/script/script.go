@@ -0,0 +1,146 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"github.com/JasirZaeem/ape/pkg/diagnostic"
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/module"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/resolver"
+	"github.com/JasirZaeem/ape/pkg/vm"
+	"io"
+	"os"
+	"strings"
+)
+
+// Exit codes of Run, an uncaught error exits with 1 and the other failures
+// follow the BSD sysexits codes
+const (
+	EXIT_OK            = 0
+	EXIT_RUNTIME_ERROR = 1
+	EXIT_USAGE         = 64
+	EXIT_SYNTAX_ERROR  = 65
+	EXIT_NO_INPUT      = 66
+)
+
+// Scripts recursing deeper than this raise a RecursionError, rather than
+// crashing the evaluator or growing the stack of the virtual machine until
+// memory runs out
+const MAX_DEPTH = 100000
+
+// Options change how a script is run
+type Options struct {
+	VM bool // compile the script to bytecode and run it on the virtual machine
+	// Where the script writes its output and reads its input, os.Stdout and
+	// os.Stdin if nil
+	Stdout io.Writer
+	Stdin  io.Reader
+}
+
+// Run evaluates the Ape file at path, the script can read args through the
+// `args` array. Problems are reported on stderr and the exit code returned.
+func Run(path string, args []string, stderr io.Writer, options Options) int {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "ape: %s\n", err)
+		return EXIT_NO_INPUT
+	}
+
+	return RunSource(path, string(source), args, stderr, options)
+}
+
+// RunSource is Run for source that has already been read, name is used for
+// error messages and to resolve the imports of the script
+func RunSource(name, source string, args []string, stderr io.Writer, options Options) int {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		for _, d := range p.Diagnostics() {
+			io.WriteString(stderr, diagnostic.Render(name, source, d))
+		}
+		return EXIT_SYNTAX_ERROR
+	}
+
+	// Warnings are reported too but only errors stop the script from running
+	failed := false
+	for _, d := range resolver.Resolve(program, append(evaluator.BuiltinNames(), "args")) {
+		io.WriteString(stderr, diagnostic.Render(name, source, d))
+		failed = failed || d.Severity == diagnostic.ERROR
+	}
+	if failed {
+		return EXIT_SYNTAX_ERROR
+	}
+
+	stdout, stdin := options.Stdout, options.Stdin
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	streams := object.NewIO(stdout, stdin)
+
+	var result object.Object
+	if options.VM {
+		machine := vm.New(vm.NewModules(module.NewFileLoader()).NewModule(name))
+		machine.SetIO(streams)
+		machine.Define("args", newArgs(args))
+		result = machine.RunWithLimits(context.Background(), program, object.Limits{MaxDepth: MAX_DEPTH})
+	} else {
+		env := evaluator.NewModules(module.NewFileLoader()).NewEnvironment(name)
+		env.SetRuntime(&object.Runtime{IO: streams})
+		env.Set("args", newArgs(args))
+		result = evaluator.EvalWithLimits(context.Background(), program, env, object.Limits{MaxDepth: MAX_DEPTH})
+	}
+
+	if err, ok := result.(*object.Error); ok {
+		io.WriteString(stderr, renderError(name, source, err))
+		return EXIT_RUNTIME_ERROR
+	}
+
+	return EXIT_OK
+}
+
+func newArgs(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// renderError formats an uncaught error like a diagnostic, the source line is
+// only shown for errors raised in the script itself
+func renderError(name, source string, err *object.Error) string {
+	var out strings.Builder
+
+	kind := err.Kind
+	if kind == "" {
+		kind = object.ERROR
+	}
+
+	file := err.Module
+	if file == "" {
+		file = name
+	}
+
+	if err.Span.Start.Line > 0 {
+		fmt.Fprintf(&out, "%s:%d:%d: %s: %s\n", file, err.Span.Start.Line, err.Span.Start.Column, kind, err.Message)
+	} else {
+		fmt.Fprintf(&out, "%s: %s: %s\n", file, kind, err.Message)
+	}
+
+	if file == name {
+		out.WriteString(diagnostic.Snippet(source, err.Span))
+	}
+
+	if stack := err.StackTrace(); stack != "" {
+		out.WriteString(strings.TrimPrefix(stack, "\n"))
+		out.WriteByte('\n')
+	}
+
+	return out.String()
+}
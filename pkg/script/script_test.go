@@ -0,0 +1,121 @@
+package script_test
+
+import (
+	"bytes"
+	"github.com/JasirZaeem/ape/pkg/script"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, dir, name, source string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "lib.ape", `export let fail = fn() { 1 / 0 };`)
+
+	tests := []struct {
+		source         string
+		args           []string
+		expectedCode   int
+		expectedStderr []string
+	}{
+		{"#!/usr/bin/env ape\nlet x = 1;", nil, script.EXIT_OK, nil},
+		{`if (len(args) != 2 || args[1] != "b") { throw "bad args" }`, []string{"a", "b"}, script.EXIT_OK, nil},
+		{
+			"let x = 1;\nlet y = (x;",
+			nil,
+			script.EXIT_SYNTAX_ERROR,
+			[]string{"main.ape:2:11: error[P001]: expected next token to be ), got ;", " 2 | let y = (x;", "hint: check for a missing )"},
+		},
+		{
+			"let f = fn(x) { x + true };\nf(1);",
+			nil,
+			script.EXIT_RUNTIME_ERROR,
+			[]string{"main.ape:1:17: TypeError: type mismatch: INTEGER + BOOLEAN", " 1 | let f = fn(x) { x + true };", "  in f(1) at 2:1"},
+		},
+		{
+			`import "lib.ape" as lib; lib["fail"]();`,
+			nil,
+			script.EXIT_RUNTIME_ERROR,
+			[]string{"lib.ape:1:26: ZeroDivisionError: division by zero", "  in fail() at 1:26"},
+		},
+		{`throw "stop";`, nil, script.EXIT_RUNTIME_ERROR, []string{"main.ape:1:1: Error: stop"}},
+		{
+			"let x = 1;\nprint(x + y);",
+			nil,
+			script.EXIT_SYNTAX_ERROR,
+			[]string{"main.ape:2:11: error[R001]: undefined name y", " 2 | print(x + y);"},
+		},
+		{
+			"let f = fn() { let unused = 1; 2 };\nf();",
+			nil,
+			script.EXIT_OK,
+			[]string{"main.ape:1:20: warning[R003]: unused is declared but never used"},
+		},
+	}
+
+	for _, options := range []script.Options{{}, {VM: true}} {
+		for _, tt := range tests {
+			path := writeScript(t, dir, "main.ape", tt.source)
+
+			var stderr bytes.Buffer
+			code := script.Run(path, tt.args, &stderr, options)
+
+			if code != tt.expectedCode {
+				t.Errorf("wrong exit code for %q (%+v). expected = %d, got = %d, stderr = %q",
+					tt.source, options, tt.expectedCode, code, stderr.String())
+			}
+
+			output := strings.ReplaceAll(stderr.String(), dir+string(filepath.Separator), "")
+			for _, expected := range tt.expectedStderr {
+				if !strings.Contains(output, expected) {
+					t.Errorf("stderr for %q (%+v) does not contain %q. got = %q", tt.source, options, expected, output)
+				}
+			}
+			if tt.expectedStderr == nil && output != "" {
+				t.Errorf("unexpected stderr for %q (%+v). got = %q", tt.source, options, output)
+			}
+		}
+	}
+}
+
+func TestRunMissingFile(t *testing.T) {
+	var stderr bytes.Buffer
+	code := script.Run(filepath.Join(t.TempDir(), "missing.ape"), nil, &stderr, script.Options{})
+
+	if code != script.EXIT_NO_INPUT {
+		t.Errorf("wrong exit code. expected = %d, got = %d", script.EXIT_NO_INPUT, code)
+	}
+	if !strings.HasPrefix(stderr.String(), "ape: ") {
+		t.Errorf("missing error message. got = %q", stderr.String())
+	}
+}
+
+func TestRunOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "lib.ape", `println("loading", "lib");`)
+	path := writeScript(t, dir, "main.ape", `import "lib.ape" as lib;
+let name = read_line();
+printf("hello %s\n", name);`)
+
+	for _, vm := range []bool{false, true} {
+		var stdout, stderr bytes.Buffer
+		options := script.Options{VM: vm, Stdout: &stdout, Stdin: strings.NewReader("ape\n")}
+		if code := script.Run(path, nil, &stderr, options); code != script.EXIT_OK {
+			t.Fatalf("wrong exit code (vm = %t). expected = %d, got = %d, stderr = %q", vm, script.EXIT_OK, code, stderr.String())
+		}
+
+		expected := "loading lib\nhello ape\n"
+		if stdout.String() != expected {
+			t.Errorf("wrong output (vm = %t). expected = %q, got = %q", vm, expected, stdout.String())
+		}
+	}
+}
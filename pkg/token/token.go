@@ -1,10 +1,32 @@
 package token
 
+import "sort"
+
 type TokenType string
 
+// Position is a location in the source, Offset is the 0 based byte offset,
+// Line and Column are 1 based with Column counted in characters (runes).
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// Span is the half open range [Start, End) of source covered by a token or node.
+type Span struct {
+	Start Position
+	End   Position
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Start   Position
+	End     Position
+}
+
+func (t Token) Span() Span {
+	return Span{Start: t.Start, End: t.End}
 }
 
 const (
@@ -60,20 +82,55 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
 	RETURN   = "RETURN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	IMPORT   = "IMPORT"
+	AS       = "AS"
+	EXPORT   = "EXPORT"
 
 	EMPTY_LINE = "EMPTY_LINE"
+
+	// Comments are trivia, the lexer collects them instead of returning them
+	COMMENT = "COMMENT"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"while":  WHILE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"return":   RETURN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"throw":    THROW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"import":   IMPORT,
+	"as":       AS,
+	"export":   EXPORT,
+}
+
+// Keywords returns the reserved words of the language in sorted order
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,131 @@
+package vm
+
+import (
+	"github.com/JasirZaeem/ape/pkg/code"
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/object"
+)
+
+// Small integers are shared instead of allocated for every result, integer
+// objects are never changed so this is safe
+const (
+	minCachedInteger = -256
+	maxCachedInteger = 1024
+)
+
+var integers [maxCachedInteger - minCachedInteger + 1]object.Integer
+
+func init() {
+	for i := range integers {
+		integers[i].Value = int64(i + minCachedInteger)
+	}
+}
+
+func newInteger(value int64) *object.Integer {
+	if value >= minCachedInteger && value <= maxCachedInteger {
+		return &integers[value-minCachedInteger]
+	}
+	return &object.Integer{Value: value}
+}
+
+// binaryOperation applies the operator of op, the common integer cases are
+// done here and the others like the evaluator does them
+func binaryOperation(op code.Opcode, left, right object.Object) object.Object {
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			if result := integerOperation(op, l.Value, r.Value); result != nil {
+				return result
+			}
+		}
+	}
+	return evaluator.Infix(operators[op], left, right)
+}
+
+// integerOperation returns nil when the result needs more care, like an
+// overflow or a division
+func integerOperation(op code.Opcode, left, right int64) object.Object {
+	switch op {
+	case code.OpAdd:
+		if sum := left + right; (sum > left) == (right > 0) {
+			return newInteger(sum)
+		}
+	case code.OpSub:
+		if difference := left - right; (difference < left) == (right > 0) {
+			return newInteger(difference)
+		}
+	case code.OpLess:
+		return nativeBool(left < right)
+	case code.OpLessEqual:
+		return nativeBool(left <= right)
+	case code.OpGreater:
+		return nativeBool(left > right)
+	case code.OpGreaterEqual:
+		return nativeBool(left >= right)
+	case code.OpEqual:
+		return nativeBool(left == right)
+	case code.OpNotEqual:
+		return nativeBool(left != right)
+	}
+	return nil
+}
+
+func nativeBool(value bool) *object.Boolean {
+	if value {
+		return evaluator.TRUE
+	}
+	return evaluator.FALSE
+}
+
+// iterator steps through the values a for loop iterates over
+type iterator struct {
+	next func() (key, value object.Object, ok bool)
+	hash bool // a loop with one variable gets the keys of a hash
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+// newIterator reports false for values a for loop cannot iterate over
+func newIterator(iterable object.Object) (*iterator, bool) {
+	i := int64(0)
+
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		elements := iterable.Elements
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if i >= int64(len(elements)) {
+				return nil, nil, false
+			}
+			i++
+			return newInteger(i - 1), elements[i-1], true
+		}}, true
+	case *object.String:
+		runes := []rune(iterable.Value)
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if i >= int64(len(runes)) {
+				return nil, nil, false
+			}
+			i++
+			return newInteger(i - 1), &object.String{Value: string(runes[i-1])}, true
+		}}, true
+	case *object.Hash:
+		pairs := iterable.OrderedPairs()
+		return &iterator{hash: true, next: func() (object.Object, object.Object, bool) {
+			if i >= int64(len(pairs)) {
+				return nil, nil, false
+			}
+			i++
+			return pairs[i-1].Key, pairs[i-1].Value, true
+		}}, true
+	case *object.Range:
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if uint64(i) >= iterable.Len() {
+				return nil, nil, false
+			}
+			i++
+			return newInteger(i - 1), newInteger(iterable.At(uint64(i - 1))), true
+		}}, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,627 @@
+package vm
+
+import (
+	"context"
+	"github.com/JasirZaeem/ape/pkg/ast"
+	"github.com/JasirZaeem/ape/pkg/code"
+	"github.com/JasirZaeem/ape/pkg/compiler"
+	"github.com/JasirZaeem/ape/pkg/evaluator"
+	"github.com/JasirZaeem/ape/pkg/module"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"sort"
+)
+
+const StackSize = 2048
+
+var (
+	operators = map[code.Opcode]string{}
+	builtins  []*object.Builtin
+)
+
+func init() {
+	for operator, op := range code.InfixOperators {
+		operators[op] = operator
+	}
+	for operator, op := range code.PrefixOperators {
+		operators[op] = operator
+	}
+	for _, name := range evaluator.BuiltinNames() {
+		builtin, _ := evaluator.Builtin(name)
+		builtins = append(builtins, builtin)
+	}
+}
+
+// VM compiles programs to bytecode and runs them, it gives the same results
+// as evaluator.Eval. Like an Environment it keeps the globals a program
+// defines for the programs run after it.
+type VM struct {
+	compiler *compiler.Compiler
+	globals  *object.Globals
+	runtime  *object.Runtime // the IO of the programs, and the limits they run under
+	limited  bool            // whether runtime has limits to check
+
+	stack    []object.Object
+	sp       int // the top of the stack is stack[sp-1]
+	frames   []frame
+	handlers []handler
+}
+
+// frame is a function being run, its locals start at bp
+type frame struct {
+	cl *object.Closure
+	ip int
+	bp int
+}
+
+// handler is where a try expression catches errors raised while it runs
+type handler struct {
+	frame int
+	sp    int
+	ip    int
+}
+
+// New creates a VM for the program of module, module may be nil for a program
+// that does not import others
+func New(module *object.Module) *VM {
+	return &VM{
+		compiler: compiler.New(),
+		globals:  object.NewModuleGlobals(module),
+		runtime:  &object.Runtime{},
+		stack:    make([]object.Object, StackSize),
+	}
+}
+
+// NewModules is evaluator.NewModules for programs run by a VM, the modules
+// they import are run by VMs too
+func NewModules(loader module.Loader) *evaluator.Modules {
+	return evaluator.NewModulesWithRunner(loader, func(program *ast.Program, mod *object.Module, rt *object.Runtime) object.Object {
+		vm := New(mod)
+		if rt != nil {
+			vm.runtime = rt
+		}
+		return vm.Run(program)
+	})
+}
+
+// SetIO sets where the programs write their output and read their input,
+// object.StandardIO by default
+func (vm *VM) SetIO(io *object.IO) {
+	vm.runtime.IO = io
+}
+
+// RunWithLimits is Run for programs that can not be trusted to finish or to
+// stay small, like evaluator.EvalWithLimits. Steps are instructions run and
+// the heap counts the strings, arrays and hashes created by operators,
+// literals and builtins.
+func (vm *VM) RunWithLimits(ctx context.Context, program *ast.Program, limits object.Limits) object.Object {
+	previous := vm.runtime
+	vm.runtime = &object.Runtime{Context: ctx, Limits: limits, IO: previous.IO}
+	defer func() { vm.runtime = previous }()
+
+	return vm.Run(program)
+}
+
+// Define binds the global name to value, like Environment.Set
+func (vm *VM) Define(name string, value object.Object) {
+	index := vm.compiler.DefineGlobal(name)
+	vm.growGlobals()
+	vm.globals.Values[index] = value
+}
+
+// Names returns the names of the globals bound by Define and earlier programs,
+// like Environment.Names
+func (vm *VM) Names() []string {
+	var names []string
+	for i, name := range vm.globals.Names {
+		if i < len(vm.globals.Values) && vm.globals.Values[i] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (vm *VM) growGlobals() {
+	names := vm.compiler.Globals()
+	for len(vm.globals.Values) < len(names) {
+		vm.globals.Values = append(vm.globals.Values, nil)
+	}
+	vm.globals.Names = names
+}
+
+// Run compiles and runs program, the result is the value of its last
+// statement or an *object.Error like the one Eval would return
+func (vm *VM) Run(program *ast.Program) object.Object {
+	bytecode := vm.compiler.Compile(program)
+	vm.growGlobals()
+
+	main := &object.Closure{Fn: bytecode.Main, Globals: vm.globals}
+	vm.sp = 0
+	vm.frames = vm.frames[:0]
+	vm.handlers = vm.handlers[:0]
+	vm.pushFrame(main, 0)
+
+	vm.limited = vm.runtime.Context != nil || vm.runtime.Limits != (object.Limits{})
+	return vm.run()
+}
+
+func (vm *VM) run() object.Object {
+	for {
+		f := &vm.frames[len(vm.frames)-1]
+		fn := f.cl.Fn
+		ins := fn.Instructions
+		ip := f.ip
+		op := code.Opcode(ins[ip])
+
+		if vm.limited {
+			if err := evaluator.Step(vm.runtime); err != nil {
+				if !vm.raise(err, ip) {
+					return err
+				}
+				continue
+			}
+		}
+		f.ip++
+
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			index := code.ReadUint16(ins[ip+1:])
+			f.ip += 2
+			vm.push(fn.Constants[index])
+		case code.OpPop:
+			vm.sp--
+		case code.OpDup:
+			vm.push(vm.stack[vm.sp-1])
+		case code.OpTrue:
+			vm.push(evaluator.TRUE)
+		case code.OpFalse:
+			vm.push(evaluator.FALSE)
+		case code.OpNull:
+			vm.push(evaluator.NULL)
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpFloorDiv, code.OpPow,
+			code.OpShiftLeft, code.OpShiftRight, code.OpBitAnd, code.OpBitXor, code.OpBitOr,
+			code.OpEqual, code.OpNotEqual, code.OpLess, code.OpLessEqual, code.OpGreater, code.OpGreaterEqual:
+			right := vm.stack[vm.sp-1]
+			left := vm.stack[vm.sp-2]
+			vm.sp -= 2
+			if err = evaluator.ReserveInfix(vm.runtime, operators[op], left, right); err == nil {
+				err = vm.pushResult(evaluator.Allocate(vm.runtime, binaryOperation(op, left, right)))
+			}
+
+		case code.OpNot, code.OpMinus, code.OpPlus, code.OpBitNot:
+			right := vm.pop()
+			err = vm.pushResult(evaluator.Prefix(operators[op], right))
+
+		case code.OpJump:
+			f.ip = int(code.ReadUint16(ins[ip+1:]))
+		case code.OpJumpNotTruthy:
+			f.ip += 2
+			if !evaluator.IsTruthy(vm.pop()) {
+				f.ip = int(code.ReadUint16(ins[ip+1:]))
+			}
+		case code.OpAnd, code.OpOr:
+			f.ip += 2
+			if evaluator.IsTruthy(vm.stack[vm.sp-1]) == (op == code.OpOr) {
+				f.ip = int(code.ReadUint16(ins[ip+1:]))
+			} else {
+				vm.sp--
+			}
+
+		case code.OpGetGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			f.ip += 2
+			val := f.cl.Globals.Values[index]
+			if val == nil {
+				// Builtins can be shadowed by globals defined after the
+				// code using them was compiled
+				name := f.cl.Globals.Names[index]
+				builtin, ok := evaluator.Builtin(name)
+				if !ok {
+					err = evaluator.NewError(object.NAME_ERROR, "identifier not found: "+name)
+					break
+				}
+				val = builtin
+			}
+			vm.push(val)
+		case code.OpSetGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			f.ip += 2
+			f.cl.Globals.Values[index] = vm.pop()
+		case code.OpAssignGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			f.ip += 2
+			if f.cl.Globals.Values[index] == nil {
+				err = evaluator.NewError(object.NAME_ERROR, "assignment target not found: "+f.cl.Globals.Names[index])
+				break
+			}
+			f.cl.Globals.Values[index] = vm.stack[vm.sp-1]
+
+		case code.OpGetLocal:
+			index := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			val := vm.stack[f.bp+index]
+			if val == nil {
+				err = evaluator.NewError(object.NAME_ERROR, "identifier not found: "+fn.LocalNames[index])
+				break
+			}
+			vm.push(val)
+		case code.OpSetLocal:
+			index := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			vm.stack[f.bp+index] = vm.pop()
+		case code.OpAssignLocal:
+			index := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			if vm.stack[f.bp+index] == nil {
+				err = evaluator.NewError(object.NAME_ERROR, "assignment target not found: "+fn.LocalNames[index])
+				break
+			}
+			vm.stack[f.bp+index] = vm.stack[vm.sp-1]
+
+		case code.OpGetCell:
+			index := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			err = vm.getCell(vm.stack[f.bp+index].(*object.Cell), fn.LocalNames[index])
+		case code.OpSetCell:
+			index := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			vm.stack[f.bp+index].(*object.Cell).Value = vm.pop()
+		case code.OpAssignCell:
+			index := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			err = vm.assignCell(vm.stack[f.bp+index].(*object.Cell), fn.LocalNames[index])
+		case code.OpGetFree:
+			index := code.ReadUint8(ins[ip+1:])
+			f.ip++
+			err = vm.getCell(f.cl.Free[index], fn.FreeNames[index])
+		case code.OpAssignFree:
+			index := code.ReadUint8(ins[ip+1:])
+			f.ip++
+			err = vm.assignCell(f.cl.Free[index], fn.FreeNames[index])
+		case code.OpLoadCell:
+			index := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			vm.push(vm.stack[f.bp+index])
+		case code.OpLoadFree:
+			index := code.ReadUint8(ins[ip+1:])
+			f.ip++
+			vm.push(f.cl.Free[index])
+		case code.OpGetBuiltin:
+			index := code.ReadUint8(ins[ip+1:])
+			f.ip++
+			vm.push(builtins[index])
+		case code.OpResetLocals:
+			first := int(code.ReadUint16(ins[ip+1:]))
+			count := int(code.ReadUint16(ins[ip+3:]))
+			f.ip += 4
+			vm.resetLocals(f, first, count)
+
+		case code.OpArray:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			elements := make([]object.Object, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			err = vm.pushResult(evaluator.Allocate(vm.runtime, &object.Array{Elements: elements}))
+		case code.OpHashKey:
+			if key := vm.stack[vm.sp-1]; !isHashable(key) {
+				err = evaluator.NewError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
+			}
+		case code.OpHash:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			f.ip += 2
+			hash := object.NewHash()
+			for i := vm.sp - 2*n; i < vm.sp; i += 2 {
+				hash.Set(vm.stack[i].(object.Hashable), vm.stack[i+1])
+			}
+			vm.sp -= 2 * n
+			err = vm.pushResult(evaluator.Allocate(vm.runtime, hash))
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.Index(left, index))
+
+		case code.OpCall:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			f.ip++
+			err = vm.call(n)
+		case code.OpReturnValue:
+			result := vm.pop()
+			if len(vm.frames) == 1 {
+				return result
+			}
+			vm.sp = f.bp - 1
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.push(result)
+		case code.OpReturn:
+			return nil
+		case code.OpClosure:
+			index := code.ReadUint16(ins[ip+1:])
+			n := int(code.ReadUint8(ins[ip+3:]))
+			f.ip += 3
+			free := make([]*object.Cell, n)
+			for i := range free {
+				free[i] = vm.stack[vm.sp-n+i].(*object.Cell)
+			}
+			vm.sp -= n
+			vm.push(&object.Closure{
+				Fn:      fn.Constants[index].(*object.CompiledFunction),
+				Free:    free,
+				Globals: f.cl.Globals,
+			})
+
+		case code.OpIter:
+			iterable := vm.pop()
+			it, ok := newIterator(iterable)
+			if !ok {
+				err = evaluator.NewError(object.TYPE_ERROR, "cannot iterate over %s", iterable.Type())
+				break
+			}
+			vm.push(it)
+		case code.OpIterNext:
+			index := int(code.ReadUint16(ins[ip+1:]))
+			vars := int(code.ReadUint8(ins[ip+3:]))
+			f.ip += 5
+			it := vm.stack[f.bp+index].(*iterator)
+			key, value, ok := it.next()
+			if !ok {
+				f.ip = int(code.ReadUint16(ins[ip+4:]))
+				break
+			}
+			if vars == 2 {
+				vm.push(key)
+				vm.push(value)
+			} else if it.hash {
+				vm.push(key)
+			} else {
+				vm.push(value)
+			}
+
+		case code.OpTry:
+			f.ip += 2
+			vm.handlers = append(vm.handlers, handler{
+				frame: len(vm.frames) - 1,
+				sp:    vm.sp,
+				ip:    int(code.ReadUint16(ins[ip+1:])),
+			})
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case code.OpThrow:
+			err = evaluator.Throw(vm.pop())
+		case code.OpRaise:
+			index := code.ReadUint16(ins[ip+1:])
+			f.ip += 2
+			raised := *fn.Constants[index].(*object.Error)
+			err = &raised
+
+		case code.OpImport:
+			index := code.ReadUint16(ins[ip+1:])
+			f.ip += 2
+			path := fn.Constants[index].(*object.String).Value
+			mod := f.cl.Globals.Module
+			if mod == nil || mod.Importer == nil {
+				err = evaluator.NewError(object.IMPORT_ERROR, "cannot import %q: imports are not available here", path)
+				break
+			}
+			// Imported modules run with the IO and under the limits of the program
+			if m, ok := mod.Importer.(*evaluator.Modules); ok {
+				m.SetRuntime(vm.runtime)
+			}
+			imported, importErr := mod.Importer.Import(mod.Name, path)
+			if importErr != nil {
+				err = importErr
+				break
+			}
+			vm.push(imported)
+		case code.OpExport:
+			index := code.ReadUint16(ins[ip+1:])
+			f.ip += 2
+			if mod := f.cl.Globals.Module; mod != nil {
+				mod.Export(fn.Constants[index].(*object.String).Value)
+			}
+
+		default:
+			return evaluator.NewError(object.ERROR, "unknown opcode %d", op)
+		}
+
+		if err != nil && !vm.raise(err, ip) {
+			return err
+		}
+	}
+}
+
+func (vm *VM) push(obj object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+	vm.stack[vm.sp] = obj
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+// pushResult pushes the result of an operation, errors are returned instead
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
+
+func (vm *VM) getCell(cell *object.Cell, name string) *object.Error {
+	if cell.Value == nil {
+		return evaluator.NewError(object.NAME_ERROR, "identifier not found: "+name)
+	}
+	vm.push(cell.Value)
+	return nil
+}
+
+func (vm *VM) assignCell(cell *object.Cell, name string) *object.Error {
+	if cell.Value == nil {
+		return evaluator.NewError(object.NAME_ERROR, "assignment target not found: "+name)
+	}
+	cell.Value = vm.stack[vm.sp-1]
+	return nil
+}
+
+// resetLocals unbinds count locals of f from first, captured ones get new cells
+func (vm *VM) resetLocals(f *frame, first, count int) {
+	cells := f.cl.Fn.Cells
+	for i := first; i < first+count; i++ {
+		if cells[i] {
+			vm.stack[f.bp+i] = &object.Cell{}
+		} else {
+			vm.stack[f.bp+i] = nil
+		}
+	}
+}
+
+// pushFrame starts running cl with its arguments already on the stack at bp
+func (vm *VM) pushFrame(cl *object.Closure, bp int) {
+	top := bp + cl.Fn.NumLocals
+	for top >= len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+
+	for i := cl.Fn.NumParameters; i < cl.Fn.NumLocals; i++ {
+		vm.stack[bp+i] = nil
+	}
+	for i, captured := range cl.Fn.Cells {
+		if captured {
+			vm.stack[bp+i] = &object.Cell{Value: vm.stack[bp+i]}
+		}
+	}
+
+	vm.frames = append(vm.frames, frame{cl: cl, bp: bp})
+	vm.sp = top
+}
+
+func (vm *VM) call(n int) *object.Error {
+	callee := vm.stack[vm.sp-1-n]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		if callee.Fn.NumParameters != n {
+			err := evaluator.NewError(object.ARGUMENT_ERROR, "wrong number of arguments: want=%d, got=%d",
+				callee.Fn.NumParameters, n)
+			// Like the evaluator the stack includes the call that failed
+			caller := vm.stackTrace()
+			frame := &object.Frame{
+				Function: vm.functionName(callee, len(vm.frames)-1),
+				Call:     vm.callPosition(len(vm.frames) - 1).Span,
+				Args:     append([]object.Object{}, vm.stack[vm.sp-n:vm.sp]...),
+			}
+			if len(caller) > 0 {
+				frame.Caller = caller[0]
+			}
+			err.Stack = append([]*object.Frame{frame}, caller...)
+			return err
+		}
+		if vm.limited {
+			if err := evaluator.CheckDepth(vm.runtime, len(vm.frames)-1); err != nil {
+				return err
+			}
+		}
+		vm.pushFrame(callee, vm.sp-n)
+		return nil
+	case *object.Builtin:
+		result := callee.Call(vm.runtime, vm.stack[vm.sp-n:vm.sp]...)
+		vm.sp -= n + 1
+		if result == nil {
+			result = evaluator.NULL
+		}
+		return vm.pushResult(evaluator.Allocate(vm.runtime, result))
+	default:
+		return evaluator.NewError(object.TYPE_ERROR, "not a function: %s", callee.Type())
+	}
+}
+
+// raise tags err with where it was raised and unwinds to the innermost try
+// expression, it reports false if there is none
+func (vm *VM) raise(err *object.Error, ip int) bool {
+	f := &vm.frames[len(vm.frames)-1]
+	if err.Span.Start.Line == 0 {
+		err.Span = f.cl.Fn.Position(ip).Span
+		if mod := f.cl.Globals.Module; mod != nil {
+			err.Module = mod.Name
+		}
+	}
+	if err.Stack == nil {
+		err.Stack = vm.stackTrace()
+	}
+
+	if len(vm.handlers) == 0 {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.frames = vm.frames[:h.frame+1]
+	vm.frames[h.frame].ip = h.ip
+	vm.sp = h.sp
+	vm.push(&object.ErrorValue{Error: err})
+	return true
+}
+
+// stackTrace lists the functions being run, innermost first, nil when only
+// the program itself is
+func (vm *VM) stackTrace() []*object.Frame {
+	if len(vm.frames) < 2 {
+		return nil
+	}
+
+	stack := make([]*object.Frame, len(vm.frames)-1)
+	var caller *object.Frame
+	for i := 1; i < len(vm.frames); i++ {
+		f := &vm.frames[i]
+		args := make([]object.Object, f.cl.Fn.NumParameters)
+		for j := range args {
+			args[j] = vm.stack[f.bp+j]
+			if cell, ok := args[j].(*object.Cell); ok {
+				args[j] = cell.Value
+			}
+		}
+
+		frame := &object.Frame{
+			Function: vm.functionName(f.cl, i-1),
+			Call:     vm.callPosition(i - 1).Span,
+			Args:     args,
+			Caller:   caller,
+		}
+		stack[len(stack)-i] = frame
+		caller = frame
+	}
+
+	return stack
+}
+
+// callPosition is the position of the call the frame at index is making
+func (vm *VM) callPosition(index int) code.Position {
+	f := &vm.frames[index]
+	// ip is past the operand of OpCall
+	return f.cl.Fn.Position(f.ip - 2)
+}
+
+// functionName is the name cl is shown with in tracebacks when called from
+// the frame at index
+func (vm *VM) functionName(cl *object.Closure, caller int) string {
+	if cl.Fn.Name != "" {
+		return cl.Fn.Name
+	}
+	if callee := vm.callPosition(caller).Callee; callee != "" {
+		return callee
+	}
+	return "<anonymous>"
+}
+
+func isHashable(obj object.Object) bool {
+	_, ok := obj.(object.Hashable)
+	return ok
+}
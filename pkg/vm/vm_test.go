@@ -0,0 +1,200 @@
+package vm_test
+
+import (
+	"context"
+	"github.com/JasirZaeem/ape/pkg/lexer"
+	"github.com/JasirZaeem/ape/pkg/module"
+	"github.com/JasirZaeem/ape/pkg/object"
+	"github.com/JasirZaeem/ape/pkg/parser"
+	"github.com/JasirZaeem/ape/pkg/vm"
+	"testing"
+	"time"
+)
+
+func run(machine *vm.VM, input string) object.Object {
+	return machine.Run(parser.New(lexer.New(input)).ParseProgram())
+}
+
+func testInspect(t *testing.T, input string, obj object.Object, expected string) {
+	t.Helper()
+	if obj == nil {
+		t.Errorf("no result for %q", input)
+		return
+	}
+	if err, ok := obj.(*object.Error); ok {
+		t.Errorf("error for %q: %s", input, err.Traceback())
+		return
+	}
+	if obj.Inspect() != expected {
+		t.Errorf("wrong result for %q. expected = %q, got = %q", input, expected, obj.Inspect())
+	}
+}
+
+// The evaluator tests run every case on the VM too, these cover what is
+// particular to compiled code
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let counter = fn() { let c = 0; fn() { c = c + 1 } }; let k = counter(); k(); k(); k()`, "3"},
+		{`let f = fn(x) { fn(y) { fn(z) { x + y + z } } }; f(1)(2)(3)`, "6"},
+		{`let f = fn() { let g = fn() { h() }; let h = fn() { 2 }; g() }; f()`, "2"},
+		{`let f = fn() { let v = 1; let g = fn() { fn() { v } }; v = 7; g()() }; f()`, "7"},
+		{`let fs = []; for (i in range(3)) { let j = i * 10; fs = push(fs, fn() { i + j }) }; fs[0]() + fs[2]()`, "22"},
+		{`let fs = []; let i = 0; while (i < 3) { i = i + 1; fs = push(fs, fn() { i }) }; fs[0]()`, "3"},
+		{`let x = 1; let f = fn() { let y = x; let x = 2; y + x }; f()`, "3"},
+	}
+
+	for _, tt := range tests {
+		testInspect(t, tt.input, run(vm.New(nil), tt.input), tt.expected)
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	input := `let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(20)`
+	testInspect(t, input, run(vm.New(nil), input), "6765")
+
+	// Deep recursion grows the stack
+	input = `let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } }; sum(5000)`
+	testInspect(t, input, run(vm.New(nil), input), "12502500")
+}
+
+func TestJumpsOutOfExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let s = 0; for (x in range(10)) { s = s + [1, if (x > 5) { break } else { x }][1] }; s`, "15"},
+		{`let f = fn() { for (x in range(5)) { try { if (x == 3) { return x } } finally { 0 } } }; f()`, "3"},
+		{`let f = fn() { for (x in [1, 2]) { try { continue } finally { return 5 } } }; f()`, "5"},
+		{`let t = fn() { throw "x" }; let f = fn() { 1 + try { [1, t()] } catch (e) { len(e["message"]) } }; f()`, "2"},
+	}
+
+	for _, tt := range tests {
+		testInspect(t, tt.input, run(vm.New(nil), tt.input), tt.expected)
+	}
+}
+
+func TestGlobalsPersist(t *testing.T) {
+	machine := vm.New(nil)
+	machine.Define("limit", &object.Integer{Value: 3})
+
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{`let double = fn(x) { x * 2 }; let n = 1`, ""},
+		{`n = double(n) + limit`, "5"},
+		{`let len = fn(x) { 0 }; len("abc")`, "0"},
+		{`n`, "5"},
+	}
+
+	for _, tt := range inputs {
+		result := run(machine, tt.input)
+		if tt.expected == "" {
+			if result != nil {
+				t.Errorf("expected no result for %q. got = %s", tt.input, result.Inspect())
+			}
+			continue
+		}
+		testInspect(t, tt.input, result, tt.expected)
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `let inner = fn(a, b) { a + b };
+let outer = fn(x) {
+  inner(x, "s");
+};
+outer(1);`
+
+	err, ok := run(vm.New(nil), input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error returned")
+	}
+
+	expected := "ERROR: type mismatch: INTEGER + STRING (1:24)\n  in inner(1, \"s\") at 3:3\n  in outer(1) at 5:1"
+	if err.Traceback() != expected {
+		t.Errorf("wrong traceback. expected = %q, got = %q", expected, err.Traceback())
+	}
+}
+
+func TestModules(t *testing.T) {
+	loader := module.NewMemoryLoader(map[string]string{
+		"counter.ape": `export let count = 0;
+export let inc = fn() { count = count + 1 };`,
+	})
+
+	input := `import "counter.ape" as c; c["inc"](); c["inc"](); c["count"]`
+	machine := vm.New(vm.NewModules(loader).NewModule("main.ape"))
+	testInspect(t, input, run(machine, input), "2")
+
+	err, ok := run(machine, `c["missing"]`).(*object.Error)
+	if !ok {
+		t.Fatalf("no error returned")
+	}
+	if err.Module != "main.ape" || err.Message != `no export named "missing" in module /counter.ape` {
+		t.Errorf("wrong error. got = %s in %q", err.Message, err.Module)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Results too large for the heap are refused before they are built, long
+	// before the deadline
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelDeadline()
+
+	loader := module.NewMemoryLoader(map[string]string{"spin.ape": `while (true) {}`})
+
+	tests := []struct {
+		input        string
+		ctx          context.Context
+		limits       object.Limits
+		expectedKind string
+	}{
+		{"while (true) {}", context.Background(), object.Limits{MaxSteps: 1000}, "StepLimitError"},
+		{`import "spin.ape" as spin`, context.Background(), object.Limits{MaxSteps: 1000}, "StepLimitError"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", context.Background(), object.Limits{MaxDepth: 100}, "RecursionError"},
+		{`let s = "ape"; while (true) { s = s + s }`, context.Background(), object.Limits{MaxHeap: 1 << 16}, "MemoryError"},
+		{"let a = []; while (true) { a = push(a, 1) }", context.Background(), object.Limits{MaxHeap: 1 << 16}, "MemoryError"},
+		{"len(array(range(200000000)))", deadline, object.Limits{MaxHeap: 1 << 20}, "MemoryError"},
+		{`let s = "a"; for (i in range(12)) { s = s + s }; len(split(s, ""))`, context.Background(), object.Limits{MaxHeap: 1 << 16}, "MemoryError"},
+		{"len(array(range(200000000)))", expired, object.Limits{}, "TimeoutError"},
+		{"while (true) {}", expired, object.Limits{}, "TimeoutError"},
+		{"while (true) {}", cancelled, object.Limits{}, "CancelledError"},
+		{"let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { e[\"type\"] }", context.Background(), object.Limits{MaxDepth: 10}, ""},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(50)", context.Background(), object.Limits{MaxSteps: 10000, MaxDepth: 100, MaxHeap: 1 << 16}, ""},
+	}
+
+	for _, tt := range tests {
+		machine := vm.New(vm.NewModules(loader).NewModule(""))
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		ran := machine.RunWithLimits(tt.ctx, program, tt.limits)
+
+		errObj, ok := ran.(*object.Error)
+		if tt.expectedKind == "" {
+			if ok {
+				t.Errorf("unexpected error for %q. got = %s", tt.input, errObj.Traceback())
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("no error object returned for %q. got = %T(%+v)", tt.input, ran, ran)
+			continue
+		}
+		if errObj.Kind != tt.expectedKind {
+			t.Errorf("wrong error kind for %q. expected = %q, got = %q", tt.input, tt.expectedKind, errObj.Kind)
+		}
+	}
+
+	// The limits end with the run
+	machine := vm.New(nil)
+	program := parser.New(lexer.New("let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }")).ParseProgram()
+	machine.RunWithLimits(context.Background(), program, object.Limits{MaxDepth: 10})
+	testInspect(t, "f(100)", run(machine, "f(100)"), "0")
+}
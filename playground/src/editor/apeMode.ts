@@ -2,8 +2,14 @@ import { simpleMode } from "@codemirror/legacy-modes/mode/simple-mode";
 
 export const apeMode = simpleMode({
   start: [
+    { regex: /#.*/, token: "comment" },
+    { regex: /\/\*/, token: "comment", next: "comment" },
     { regex: /".*"/, token: "string" },
-    { regex: /(?:fn|let|return|if|else)\b/, token: "keyword" },
+    {
+      regex:
+        /(?:fn|let|return|if|else|while|for|in|break|continue|try|catch|finally|throw|import|as|export)\b/,
+      token: "keyword",
+    },
     { regex: /true|false|null/, token: "atom" },
     { regex: /\d+|[-+]?(?:\.\d+|\d+\.?\d*)/, token: "number" },
     { regex: /[-+\/*=<>!]/, token: "operator" },
@@ -11,6 +17,11 @@ export const apeMode = simpleMode({
     { regex: /[\}\]\)]/, dedent: true },
     { regex: /[a-z$][\w$]*/, token: "variable" },
   ],
-  comment: [],
-  meta: [],
+  comment: [
+    { regex: /.*?\*\//, token: "comment", next: "start" },
+    { regex: /.*/, token: "comment" },
+  ],
+  meta: {
+    lineComment: "#",
+  },
 });
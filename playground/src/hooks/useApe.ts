@@ -13,13 +13,17 @@ export enum ApeResultType {
   // Value types
   BOOLEAN = "BOOLEAN",
   INTEGER = "INTEGER",
+  BIGINT = "BIGINT",
   FLOAT = "FLOAT",
   STRING = "STRING",
   ARRAY = "ARRAY",
   HASH = "HASH",
+  RANGE = "RANGE",
   FUNCTION = "FUNCTION",
+  MODULE = "MODULE",
+  ERROR_VALUE = "ERROR_VALUE",
 
-  // Stdout added by hijacked console.log
+  // Output the program printed, from the output of its result
   STDOUT = "STDOUT",
 
   // Json Ast
@@ -35,9 +39,28 @@ export enum ApeCodeSource {
   EDITOR = "EDITOR",
 }
 
+export type ApePosition = {
+  offset: number;
+  line: number;
+  column: number;
+};
+
+export type ApeDiagnostic = {
+  severity: "error" | "warning" | "info";
+  code: string;
+  message: string;
+  hint: string;
+  start: ApePosition;
+  end: ApePosition;
+};
+
 export type ApeResult = {
   type: ApeResultType;
   value: string;
+  // Present on PARSER_ERROR results, and on others the resolver warned about
+  diagnostics?: ApeDiagnostic[];
+  // What the program printed while it ran, if anything
+  output?: string;
 };
 
 export type FormatCodeResult = {
@@ -76,22 +99,6 @@ export function useApeInterpreter() {
   const goRef = useRef<any>();
 
   useEffect(() => {
-    // override console.log to add output to results and then call original console.log
-    // @ts-ignore
-    const originalLog = console.log;
-    // @ts-ignore
-    console.log = (...args) => {
-      updateHistory((results) => [
-        ...results,
-        {
-          type: ApeResultType.STDOUT,
-          value: args.join(" "),
-          id: results.length,
-        },
-      ]);
-      originalLog(...args);
-    };
-
     const loadWasm = async () => {
       // @ts-ignore
       goRef.current = new globalThis.Go();
@@ -105,11 +112,6 @@ export function useApeInterpreter() {
       goRef.current.run(result.instance);
     };
     loadWasm().then(() => setReady(true));
-
-    return () => {
-      // @ts-ignore
-      console.log = originalLog;
-    };
   }, []);
 
   return {
@@ -133,9 +135,21 @@ export function useApeInterpreter() {
             type: ApeResultType.WASM_ERROR,
             value: "Interpreter not ready",
           };
+      const { output, ...evaluated } = result as ApeResult;
+      if (output) {
+        // Printed lines end with a newline, the output pane adds its own
+        updateHistory((results) => [
+          ...results,
+          {
+            type: ApeResultType.STDOUT,
+            value: output.replace(/\n$/, ""),
+            id: results.length,
+          },
+        ]);
+      }
       updateHistory((results) => [
         ...results,
-        { ...result, order, id: results.length },
+        { ...evaluated, order, id: results.length },
       ]);
       return result;
     },
@@ -186,6 +200,11 @@ export function useApeInterpreter() {
         };
       }
     },
+    addModule: (name: string, code: string) => {
+      // addApeModule global function is injected by Go
+      // @ts-ignore
+      ready && addApeModule(name, code);
+    },
     resetApe: () => {
       // resetApeEnvironment global function is injected by Go
       // @ts-ignore